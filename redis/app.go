@@ -2,20 +2,27 @@ package redis
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ClockTimer interface {
 	Now() time.Time
+	// After waits for d to elapse and then sends the current time on the
+	// returned channel, like time.After. Every timeout goes through it, so
+	// tests can fire them without sleeping.
+	After(d time.Duration) <-chan time.Time
 }
 
 type RealClockTimer struct{}
@@ -24,10 +31,19 @@ func (c RealClockTimer) Now() time.Time {
 	return time.Now()
 }
 
+func (c RealClockTimer) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 type ApplicationClient struct {
 	conn              net.Conn
 	isOnSubscribeMode bool
 	subscribedTo      map[string]bool
+
+	// noTouch keeps the reads of this client from counting as accesses to
+	// the keys, so admin tools can inspect keys without skewing their access
+	// frequency.
+	noTouch bool
 }
 
 func (ac *ApplicationClient) SubscribeTo(channelName string) {
@@ -35,13 +51,33 @@ func (ac *ApplicationClient) SubscribeTo(channelName string) {
 	ac.subscribedTo[channelName] = true
 }
 
+// snapshotFile is where the snapshot is saved and loaded from, relative to
+// the working directory.
+const snapshotFile = "redis-go.rdb"
+
+// ErrTruncatedSnapshot is returned in strict mode when a snapshot ends with a
+// command that was not written in full.
+var ErrTruncatedSnapshot = errors.New("truncated snapshot")
+
 type Application struct {
 	state          *ApplicationState
-	config         *ApplicationConfiguration
+	config         atomic.Pointer[ApplicationConfiguration]
 	logger         *slog.Logger
 	clock          ClockTimer
 	clients        map[string]*ApplicationClient
-	pubsubChannels map[string]map[string]net.Conn
+	pubsubMutex    *sync.RWMutex
+	pubsubChannels map[string]map[string]*subscriber
+	subscribers    map[string]*subscriber
+	replication    *replication
+	stats          *commandStats
+	scriptMutex    *sync.RWMutex
+
+	// lastSave is the unix time, in seconds, of the last successful save.
+	lastSave atomic.Int64
+
+	// wrongTypeErrors counts the operations that found a key holding a value
+	// of another type than the one they work on.
+	wrongTypeErrors atomic.Int64
 }
 
 func NewApplication(config *ApplicationConfiguration, timer ClockTimer, l *slog.Logger) *Application {
@@ -50,14 +86,22 @@ func NewApplication(config *ApplicationConfiguration, timer ClockTimer, l *slog.
 		keyspace: *newKeyspace(timer, mutex),
 		mutex:    mutex,
 	}
-	return &Application{
+	app := &Application{
 		state:          &state,
-		config:         config,
 		clock:          timer,
 		logger:         l,
 		clients:        make(map[string]*ApplicationClient),
-		pubsubChannels: make(map[string]map[string]net.Conn),
-	}
+		pubsubMutex:    &sync.RWMutex{},
+		pubsubChannels: make(map[string]map[string]*subscriber),
+		subscribers:    make(map[string]*subscriber),
+		replication:    newReplication(),
+		stats:          newCommandStats(),
+		scriptMutex:    &sync.RWMutex{},
+	}
+	app.config.Store(config)
+	app.state.keyspace.notify = app.notifyKeyspaceEvent
+	app.state.keyspace.wrongType = func(string) { app.wrongTypeErrors.Add(1) }
+	return app
 }
 
 func (app *Application) AddClient(c net.Conn, shouldLock bool) error {
@@ -89,6 +133,41 @@ func (app *Application) AddClient(c net.Conn, shouldLock bool) error {
 	return nil
 }
 
+// RemoveClient forgets the client of the connection c and drops it from the
+// channels it subscribed to. It is called once the connection is closed.
+func (app *Application) RemoveClient(c net.Conn) {
+	addr := c.RemoteAddr().String()
+
+	app.state.mutex.Lock()
+	client, ok := app.clients[addr]
+	delete(app.clients, addr)
+	app.state.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	app.pubsubMutex.Lock()
+	defer app.pubsubMutex.Unlock()
+
+	for chName := range client.subscribedTo {
+		cMap, ok := app.pubsubChannels[chName]
+		if !ok {
+			continue
+		}
+
+		delete(cMap, addr)
+		if len(cMap) == 0 {
+			delete(app.pubsubChannels, chName)
+		}
+	}
+
+	if s, ok := app.subscribers[addr]; ok {
+		s.stop()
+		delete(app.subscribers, addr)
+	}
+}
+
 func (app *Application) GetClient(c net.Conn) (*ApplicationClient, error) {
 	app.state.mutex.Lock()
 	defer app.state.mutex.Unlock()
@@ -111,20 +190,49 @@ func (app *Application) GetClient(c net.Conn) (*ApplicationClient, error) {
 }
 
 func (app *Application) ProcessRequest(m Message) (*CommandResult, error) {
+	command, err := app.decodeRequest(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.processCommand(command, m), nil
+}
+
+func (app *Application) decodeRequest(m Message) (*Cmd, error) {
 	command, err := DecodeMessage(m.raw, app)
+	if errors.Is(err, ErrEmptyCommand) {
+		return nil, err
+	}
 	if err != nil {
 		app.logger.Error("error decoding message: " + fmt.Sprintf("%s", err))
 		return nil, err
 	}
 
+	return command, nil
+}
+
+// processCommand runs the command decoded from m.
+func (app *Application) processCommand(command *Cmd, m Message) *CommandResult {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if len(command.processed) > 0 {
+		app.logger.Debug("received command",
+			slog.String("command", strings.ToLower(command.processed[0])),
+			slog.Int("args", len(command.processed)-1),
+			slog.Any("argv", redactArgs(command.processed)),
+		)
+	}
+
 	command.sender = m.conn
-	response, err := command.Process()
-	if err != nil {
-		app.logger.Error("error parsing message: " + fmt.Sprintf("%s", err))
-		return nil, err
+	response := command.Process(ctx)
+	if command.cmd != "" {
+		app.stats.Count(command.cmd)
 	}
 
-	return response, nil
+	return response
 }
 
 type ApplicationState struct {
@@ -139,88 +247,159 @@ func (as *ApplicationState) ResetCounter() {
 	as.keyspace.modifications = 0
 }
 
-func (as *ApplicationState) Save(out io.Writer) error {
-	as.mutex.RLock()
+// Save writes the snapshot of the dataset to out in format, the RESP format
+// when it is empty, and takes the changes it holds off the count of changes
+// since the last save.
+func (as *ApplicationState) Save(out io.Writer, format SnapshotFormat) error {
+	write := as.write
+	if format == SnapshotBinary {
+		write = as.writeBinary
+	}
 
-	for k, v := range as.keyspace.stringMap {
-		e := as.keyspace.keys[k]
+	saved, err := write(out)
+	if err != nil {
+		return err
+	}
 
-		kv := fmt.Sprintf("%s%s", SerializeBulkString(k), SerializeBulkString(v))
-		cmd := fmt.Sprintf("*3\r\n$3\r\nset\r\n%s", kv)
-		fmt.Fprint(out, cmd)
+	// changes made while the snapshot was written are not in it, so they still
+	// count. A load in between starts the count over, hence the floor.
+	as.mutex.Lock()
+	as.keyspace.modifications = max(as.keyspace.modifications-saved, 0)
+	as.mutex.Unlock()
+	return nil
+}
 
-		if e.expires != nil {
-			exp := e.expires.Unix()
-			cmd = fmt.Sprintf("*3\r\n$8\r\nexpireat\r\n%s$%d\r\n%d\r\n", SerializeBulkString(k), len(fmt.Sprint(exp)), exp)
+// write writes the snapshot of the dataset to out in the RESP format, without
+// counting it as a save. It returns the count of changes the snapshot holds.
+func (as *ApplicationState) write(out io.Writer) (int, error) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
 
-			fmt.Fprint(out, cmd)
-		}
+	return as.keyspace.modifications, as.writeEntries(out)
+}
+
+// writeEntries implements write. The caller must hold the lock.
+func (as *ApplicationState) writeEntries(out io.Writer) error {
+	// keys are sorted so snapshots of the same state are always identical
+	stringKeys := GetKeys(as.keyspace.stringMap, func(stringValue) bool { return true })
+	slices.Sort(stringKeys)
+	for _, k := range stringKeys {
+		fmt.Fprint(out, as.keyspace.serializeEntry(k))
 	}
 
-	for k, v := range as.keyspace.listMap {
-		e := as.keyspace.keys[k]
+	listKeys := GetKeys(as.keyspace.listMap, func(list) bool { return true })
+	slices.Sort(listKeys)
+	for _, k := range listKeys {
+		fmt.Fprint(out, as.keyspace.serializeEntry(k))
+	}
 
-		if v.size > 0 {
-			result := fmt.Sprintf("$%d\r\n%s\r\n", len(k), k)
-			for _, d := range v.ToSlice() {
-				string := SerializeBulkString(d)
-				result += string
-			}
-			cmd := fmt.Sprintf("*%d\r\n$5\r\nrpush\r\n%s", v.size+2, result)
-			fmt.Fprint(out, cmd)
+	sortedSetKeys := GetKeys(as.keyspace.sortedSetMap, func(*sortedSet) bool { return true })
+	slices.Sort(sortedSetKeys)
+	for _, k := range sortedSetKeys {
+		fmt.Fprint(out, as.keyspace.serializeEntry(k))
+	}
 
-			if e.expires != nil {
-				exp := e.expires.Unix()
-				cmd = fmt.Sprintf("*3\r\n$8\r\nexpireat\r\n%s$%d\r\n%d\r\n", SerializeBulkString(k), len(fmt.Sprint(exp)), exp)
+	return nil
+}
 
-				fmt.Fprint(out, cmd)
-			}
+// serializeEntry returns the commands that recreate the key and its expiry,
+// as they are written to the snapshot file. Callers must hold the keyspace
+// lock.
+func (ks *keyspace) serializeEntry(k string) string {
+	e := ks.keys[k]
+
+	var cmd string
+	switch e.group {
+	case "string":
+		v := ks.stringMap[k]
+		kv := fmt.Sprintf("%s%s", SerializeBulkString(k), SerializeBulkString(v.String()))
+		cmd = fmt.Sprintf("*3\r\n$3\r\nset\r\n%s", kv)
+
+	case "list":
+		v := ks.listMap[k]
+		if v.size == 0 {
+			return ""
 		}
-	}
 
-	as.mutex.RUnlock()
+		result := fmt.Sprintf("$%d\r\n%s\r\n", len(k), k)
+		for _, d := range v.ToSlice() {
+			string := SerializeBulkString(d)
+			result += string
+		}
+		cmd = fmt.Sprintf("*%d\r\n$5\r\nrpush\r\n%s", v.size+2, result)
 
-	as.ResetCounter()
-	return nil
-}
+	case "sorted-set":
+		v := ks.sortedSetMap[k]
+		if v.Len() == 0 {
+			return ""
+		}
 
-func splitByBulkArray(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	// Return nothing if at end of file and no data passed
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
+		result := fmt.Sprintf("$%d\r\n%s\r\n", len(k), k)
+		v.tree.InOrderTraversal(func(score float64, members []string) {
+			for _, m := range members {
+				result += SerializeBulkString(formatScore(score))
+				result += SerializeBulkString(m)
+			}
+		})
+		cmd = fmt.Sprintf("*%d\r\n$4\r\nzadd\r\n%s", 2*v.Len()+2, result)
 	}
 
-	// Find the index of the input of a newline followed by an
-	// asterisk sign.
-	if i := strings.Index(string(data), "\n*"); i >= 0 {
-		return i + 1, data[0 : i+1], nil
+	// the expiry is written with millisecond precision, and keys without one
+	// get no line at all, so persisted keys stay persisted
+	if e.expires != nil {
+		exp := fmt.Sprint(e.expires.UnixMilli())
+		cmd += fmt.Sprintf("*3\r\n$9\r\npexpireat\r\n%s%s", SerializeBulkString(k), SerializeBulkString(exp))
 	}
 
-	// If at end of file with data return the data
-	if atEOF {
-		return len(data), data, nil
-	}
-	return
+	return cmd
 }
 
+// Load loads the snapshot read from r, in either format. In the RESP format,
+// the commands of the snapshot are run and the ones that can't be decoded are
+// skipped. When the last one can't, or the last entry of a binary snapshot is
+// incomplete, the snapshot was most likely truncated while it was written, so
+// a warning is logged with the number of bytes left over, or
+// ErrTruncatedSnapshot is returned in strict mode.
 func (as *ApplicationState) Load(r io.Reader, a *Application) error {
-	s := bufio.NewScanner(r)
-	s.Split(splitByBulkArray)
+	br := bufio.NewReader(r)
+	if isBinarySnapshot(br) {
+		if err := as.loadBinary(br, a); err != nil {
+			return err
+		}
 
+		as.ResetCounter()
+		return nil
+	}
+
+	s := bufio.NewScanner(br)
+	s.Split(splitCommands)
+
+	leftover := 0
 	for s.Scan() {
 		line := s.Bytes()
 		cmd, err := DecodeMessage(line, a)
 		if err != nil {
+			leftover = len(line)
 			continue
 		}
+		leftover = 0
+
 		err = cmd.Parse()
 		if err != nil {
 			continue
 		}
 
-		_, err = cmd.Process()
-		if err != nil {
-			continue
+		cmd.internal = true
+		cmd.Process(context.Background())
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if leftover > 0 {
+		if err := a.truncatedSnapshot(leftover); err != nil {
+			return err
 		}
 	}
 
@@ -228,17 +407,68 @@ func (as *ApplicationState) Load(r io.Reader, a *Application) error {
 	return nil
 }
 
+// truncatedSnapshot reports a snapshot that ends leftover bytes into a
+// command or entry that was not written in full. It fails in strict mode and
+// only logs a warning otherwise.
+func (app *Application) truncatedSnapshot(leftover int) error {
+	if config := app.configuration(); config != nil && config.StrictSnapshotLoad {
+		return fmt.Errorf("%w: %d bytes left over", ErrTruncatedSnapshot, leftover)
+	}
+
+	app.logger.Warn(fmt.Sprintf("snapshot ends with a truncated command. %d bytes were left over", leftover))
+	return nil
+}
+
+// Reload round-trips the dataset through the snapshot format: it is written
+// to a temp file and loaded back into a fresh keyspace, which then replaces
+// the current one. The state is locked for the whole round-trip, so no write
+// from other clients lands in between and gets lost.
+func (app *Application) Reload() error {
+	f, err := os.CreateTemp("", "redis-go-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	app.state.mutex.Lock()
+	defer app.state.mutex.Unlock()
+
+	if err := app.state.writeEntries(f); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fresh := NewApplication(app.configuration(), app.clock, app.logger)
+	if err := fresh.state.Load(f, fresh); err != nil {
+		return err
+	}
+
+	app.state.keyspace.replaceData(&fresh.state.keyspace)
+	return nil
+}
+
+// LoadStateFromSnapshot loads the snapshot saved at snapshotFile, if any. It
+// is loaded into a fresh keyspace first, so a snapshot that fails to load
+// leaves the state empty instead of half loaded.
 func (app *Application) LoadStateFromSnapshot() {
-	if _, err := os.Stat("redis-go.rdb"); err == nil {
-		f, err := os.Open("redis-go.rdb")
+	if _, err := os.Stat(snapshotFile); err == nil {
+		f, err := os.Open(snapshotFile)
 		if err == nil {
 			app.logger.Info("loading previous state from snapshot")
-			err = app.state.Load(f, app)
+			fresh := NewApplication(app.configuration(), app.clock, app.logger)
+			err = fresh.state.Load(f, fresh)
 			f.Close()
 			if err == nil {
+				app.state.mutex.Lock()
+				app.state.keyspace.replaceData(&fresh.state.keyspace)
+				app.state.mutex.Unlock()
 				app.logger.Info("done loading snapshot")
 			} else {
-				app.logger.Info("failed to load state from snapshot. Proceeding with empty state")
+				app.logger.Info(fmt.Sprintf("failed to load state from snapshot: %v. Proceeding with empty state", err))
 			}
 		}
 	}
@@ -246,10 +476,11 @@ func (app *Application) LoadStateFromSnapshot() {
 
 func (app *Application) SetupSnapshotSavers() func() {
 	var closerFuncs []func()
-	for i := 0; i < len(app.config.Save); i += 2 {
-		seconds := app.config.Save[i]
-		changes := app.config.Save[i+1]
-		cs := RunEveryNSeconds(time.Duration(seconds)*time.Second, func() { SaveAfterNChanges(changes, app) })
+	save := app.configuration().Save
+	for i := 0; i < len(save); i += 2 {
+		seconds := save[i]
+		changes := save[i+1]
+		cs := RunEveryNSeconds(app.clock, time.Duration(seconds)*time.Second, func() { SaveAfterNChanges(changes, app) })
 		closerFuncs = append(closerFuncs, cs)
 	}
 
@@ -262,36 +493,145 @@ func (app *Application) SetupSnapshotSavers() func() {
 }
 
 func (app *Application) SetupKeyExpirer() func() {
-	return RunEveryNSeconds(time.Second/10, func() { CheckAndExpireKeys(app) })
+	return RunEveryNSeconds(app.clock, time.Second/10, func() { CheckAndExpireKeys(app) })
+}
+
+// SetupHeartbeat logs how many clients are connected, how many keys there are
+// and how many changes were made since the last save every interval. It
+// returns the function stopping it.
+func (app *Application) SetupHeartbeat(interval time.Duration) func() {
+	return RunEveryNSeconds(app.clock, interval, func() { LogHeartbeat(app) })
+}
+
+func LogHeartbeat(app *Application) {
+	app.state.mutex.RLock()
+	clients := len(app.clients)
+	keys := len(app.state.keyspace.keys)
+	modifications := app.state.keyspace.modifications
+	app.state.mutex.RUnlock()
+
+	app.logger.Info(fmt.Sprintf("heartbeat. clients: %d. keys: %d. changes since last save: %d", clients, keys, modifications))
 }
 
-func (app *Application) SubscribeConnection(chName string, c net.Conn) {
+// SubscribeConnection subscribes c to every channel in chNames and queues the
+// confirmation to c before releasing the pub/sub lock. Publishers queue their
+// messages under the same lock, so none of them for these channels can reach
+// c ahead of the confirmation.
+func (app *Application) SubscribeConnection(chNames []string, c net.Conn, confirmation []byte) error {
+	app.pubsubMutex.Lock()
+	defer app.pubsubMutex.Unlock()
+
 	cAddr := c.RemoteAddr().String()
-	cMap, ok := app.pubsubChannels[chName]
+	s, ok := app.subscribers[cAddr]
 	if !ok {
-		cMap = map[string]net.Conn{cAddr: c}
-		app.pubsubChannels[chName] = cMap
-		return
+		s = newSubscriber(c, app.subscriberBuffer(), app.logger)
+		app.subscribers[cAddr] = s
 	}
 
-	cMap[cAddr] = c
-}
+	for _, chName := range chNames {
+		cMap, ok := app.pubsubChannels[chName]
+		if !ok {
+			cMap = map[string]*subscriber{}
+			app.pubsubChannels[chName] = cMap
+		}
 
-func (app *Application) GetConnectionsPerChannelExcludingConn(chName string, excluded net.Conn) []net.Conn {
-	result := []net.Conn{}
+		cMap[cAddr] = s
+	}
 
-	cMap, ok := app.pubsubChannels[chName]
-	if !ok {
-		return result
+	if !s.send(confirmation) {
+		return fmt.Errorf("subscriber %s is disconnected", cAddr)
 	}
+	return nil
+}
 
-	for _, c := range cMap {
-		if c.RemoteAddr().String() != excluded.RemoteAddr().String() {
-			result = append(result, c)
+// Publish queues message to the subscribers of the channel, except for the
+// excluded connection, and returns how many of them will receive it.
+func (app *Application) Publish(chName string, message string, excluded net.Conn) int {
+	app.pubsubMutex.RLock()
+	defer app.pubsubMutex.RUnlock()
+
+	payload := []byte(SerializeArray([]interface{}{"message", chName, message}))
+
+	receivers := 0
+	for addr, s := range app.pubsubChannels[chName] {
+		if excluded != nil && addr == excluded.RemoteAddr().String() {
+			continue
 		}
+
+		if s.send(payload) {
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// NumSubscribers returns how many connections are subscribed to the channel.
+func (app *Application) NumSubscribers(chName string) int {
+	app.pubsubMutex.RLock()
+	defer app.pubsubMutex.RUnlock()
+
+	return len(app.pubsubChannels[chName])
+}
+
+// configuration returns the configuration of the application, or nil when it
+// has none. CONFIG SET replaces it as a whole instead of changing its fields,
+// so the returned configuration can be read without locking.
+func (app *Application) configuration() *ApplicationConfiguration {
+	return app.config.Load()
+}
+
+// subscriberBuffer returns how many messages can wait to be written to a
+// subscriber before it is disconnected.
+func (app *Application) subscriberBuffer() int {
+	config := app.configuration()
+	if config == nil || config.SubscriberBuffer < 1 {
+		return defaultSubscriberBuffer
+	}
+	return config.SubscriberBuffer
+}
+
+var keyspaceEventClasses = map[string]rune{
+	"del":         'g',
+	"expire":      'g',
+	"persist":     'g',
+	"set":         '$',
+	"incrby":      '$',
+	"append":      '$',
+	"rpush":       'l',
+	"lpush":       'l',
+	"zadd":        'z',
+	"zunionstore": 'z',
+	"zinterstore": 'z',
+	"zdiffstore":  'z',
+	"expired":     'x',
+}
+
+// notifyKeyspaceEvent publishes a keyspace event to the __keyspace@0__ and
+// __keyevent@0__ channels, depending on the notify-keyspace-events config.
+func (app *Application) notifyKeyspaceEvent(event string, key string) {
+	config := app.configuration()
+	if config == nil || config.notifyKeyspaceEvents == "" {
+		return
+	}
+
+	flags := config.notifyKeyspaceEvents
+	class, ok := keyspaceEventClasses[event]
+	if !ok || !strings.ContainsRune(flags, class) {
+		return
 	}
 
-	return result
+	if strings.ContainsRune(flags, 'K') {
+		app.publishEvent("__keyspace@0__:"+key, event)
+	}
+
+	if strings.ContainsRune(flags, 'E') {
+		app.publishEvent("__keyevent@0__:"+event, key)
+	}
+}
+
+func (app *Application) publishEvent(channel string, message string) {
+	app.Publish(channel, message, nil)
 }
 
 func SaveAfterNChanges(n int64, app *Application) {
@@ -301,33 +641,75 @@ func SaveAfterNChanges(n int64, app *Application) {
 
 	if modifications >= n {
 		app.logger.Info(fmt.Sprintf("saving snapshot after %d changes...", modifications))
-		f, err := os.Create("redis-go.rdb")
-		if err != nil {
-			app.logger.Error("failed to open redis-go.rdb file")
-			return
-		}
-		defer f.Close()
-
-		err = app.state.Save(f)
-		if err != nil {
-			app.logger.Error("failed to save snapshot")
+		if err := app.SnapshotNow(); err != nil {
+			app.logger.Error(fmt.Sprintf("failed to save snapshot: %v", err))
 			return
 		}
 		app.logger.Info("finished saving snapshot...")
 	}
 }
 
+// SnapshotNow saves the snapshot of the dataset to snapshotFile right away,
+// whatever the number of changes, and records when it was saved. Every save
+// goes through it, so they all create the file and reset the changes count
+// the same way.
+func (app *Application) SnapshotNow() error {
+	f, err := os.Create(snapshotFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := app.state.Save(f, app.snapshotFormat()); err != nil {
+		return err
+	}
+
+	// the snapshot is only saved once the file is closed without errors
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	app.lastSave.Store(app.clock.Now().Unix())
+	return nil
+}
+
+// LastSave returns the unix time, in seconds, of the last successful save, or
+// 0 when nothing was saved since the server started.
+func (app *Application) LastSave() int64 {
+	return app.lastSave.Load()
+}
+
+const (
+	// expireSampleSize is how many keys with an expiry each round of the
+	// active expirer looks at.
+	expireSampleSize = 20
+
+	// expireMaxRounds bounds the rounds of a single run, so a keyspace where
+	// most keys expired at once is cleaned over several runs instead of
+	// stalling the others.
+	expireMaxRounds = 16
+)
+
+// CheckAndExpireKeys deletes expired keys by sampling the keys with an expiry,
+// the way redis does, instead of walking the whole keyspace. Another round is
+// sampled while more than a quarter of the sampled keys had expired, since
+// there are likely many more to delete.
 func CheckAndExpireKeys(app *Application) {
-	state := app.state
-	state.mutex.RLock()
-	keys := GetKeys(state.keyspace.keys, func(ke keyspaceEntry) bool { return CheckIsExpired(app.clock, ke) })
-	state.mutex.RUnlock()
+	deleted := 0
+	for round := 0; round < expireMaxRounds; round++ {
+		expired, sampled := app.state.keyspace.SampleExpired(expireSampleSize)
+		if len(expired) != 0 {
+			app.state.keyspace.BulkDeleteExpired(expired)
+			deleted += len(expired)
+		}
 
-	nKeys := len(keys)
-	if nKeys != 0 {
-		app.logger.Info(fmt.Sprintf("deleting %d expired keys", nKeys))
+		if len(expired)*4 <= sampled {
+			break
+		}
+	}
 
-		app.state.keyspace.BulkDelete(keys)
+	if deleted != 0 {
+		app.logger.Info(fmt.Sprintf("deleted %d expired keys", deleted))
 	}
 }
 
@@ -346,18 +728,86 @@ func GetKeys[K comparable, V any](m map[K]V, filter func(V) bool) []K {
 
 var validSaveOptions map[string]bool = map[string]bool{"yes": true, "no": true}
 
-var configMap map[string]bool = map[string]bool{"appendonly": true, "save": true}
+var configMap map[string]bool = map[string]bool{
+	"appendonly":                true,
+	"save":                      true,
+	"notify-keyspace-events":    true,
+	"list-max-listpack-size":    true,
+	"zset-max-listpack-entries": true,
+	"proto-max-bulk-len":        true,
+}
+
+// defaultProtoMaxBulkLen is the proto-max-bulk-len default of redis, 512MB.
+const defaultProtoMaxBulkLen int64 = 512 * 1024 * 1024
 
 type ApplicationConfiguration struct {
-	appendonly string
-	save       string
-	Save       []int64
+	appendonly           string
+	save                 string
+	notifyKeyspaceEvents string
+	Save                 []int64
+
+	// the largest lists and sorted sets reported with the listpack encoding.
+	// The representation itself never changes, only the reported encoding.
+	listMaxListpackSize    int64
+	zsetMaxListpackEntries int64
+
+	// protoMaxBulkLen is the longest a string value can grow to.
+	protoMaxBulkLen int64
+
+	// ProcessPerConnection makes every connection process its own requests
+	// instead of funneling all of them through a single goroutine.
+	ProcessPerConnection bool
+
+	// Workers is how many goroutines process requests when not processing per
+	// connection. Connections are sharded to workers by their address, so the
+	// requests of one connection still run in order. Values below 1 mean 1.
+	Workers int
+
+	// SubscriberBuffer is how many published messages can wait to be written
+	// to a subscriber. A subscriber falling further behind is disconnected.
+	// Values below 1 mean defaultSubscriberBuffer.
+	SubscriberBuffer int
+
+	// DebugKeyspace enables DEBUG KEYSPACE, which lists every key in a single
+	// reply. It is meant for tests and is disabled by default.
+	DebugKeyspace bool
+
+	// DebugExpire enables DEBUG EXPIRE, which sets the expiry of a key to any
+	// time, past ones included. It is meant for tests and is disabled by
+	// default.
+	DebugExpire bool
+
+	// ReadOnly rejects the commands that write with ErrReadOnly, like a redis
+	// replica with replica-read-only set. Snapshots are still loaded.
+	ReadOnly bool
+
+	// SnapshotFormat is the format snapshots are saved in. Empty means
+	// SnapshotRESP.
+	SnapshotFormat SnapshotFormat
+
+	// StrictSnapshotLoad makes loading a snapshot that ends with a truncated
+	// command fail, instead of loading the commands before it.
+	StrictSnapshotLoad bool
+
+	// TCPNoDelay disables Nagle's algorithm on accepted TCP connections, so
+	// small replies are sent right away.
+	TCPNoDelay bool
+
+	// TCPKeepAlive is the period between the keepalive probes sent to idle
+	// clients on accepted TCP connections, like tcp-keepalive of redis. Zero
+	// disables them.
+	TCPKeepAlive time.Duration
 }
 
 func NewApplicationConfiguration(appendonly string, save string) (*ApplicationConfiguration, error) {
 	ac := ApplicationConfiguration{
-		appendonly: appendonly,
-		save:       save,
+		appendonly:             appendonly,
+		save:                   save,
+		listMaxListpackSize:    defaultEncodingLimits.listMaxListpackSize,
+		zsetMaxListpackEntries: defaultEncodingLimits.zsetMaxListpackEntries,
+		protoMaxBulkLen:        defaultProtoMaxBulkLen,
+		TCPNoDelay:             true,
+		TCPKeepAlive:           defaultTCPKeepAlive,
 	}
 
 	err := ac.validateAppendOnly()
@@ -373,6 +823,122 @@ func NewApplicationConfiguration(appendonly string, save string) (*ApplicationCo
 	return &ac, nil
 }
 
+// SetNotifyKeyspaceEvents sets which keyspace events are published. Like in
+// redis, K enables the __keyspace@0__ channels, E the __keyevent@0__ channels
+// and the remaining flags select the event classes: g (generic), $ (string),
+// l (list), z (sorted set), x (expired) and A as an alias for all of them.
+func (ac *ApplicationConfiguration) SetNotifyKeyspaceEvents(flags string) error {
+	result := ""
+	for _, f := range flags {
+		switch f {
+		default:
+			return fmt.Errorf("invalid notify-keyspace-events flag '%c'", f)
+		case 'A':
+			result += "g$lzx"
+		case 'K', 'E', 'g', '$', 'l', 'z', 'x':
+			result += string(f)
+		}
+	}
+
+	ac.notifyKeyspaceEvents = result
+	return nil
+}
+
+// Set changes the configuration parameter param to value, the way CONFIG SET
+// does. The configuration is left as it was if value is invalid.
+func (ac *ApplicationConfiguration) Set(param string, value string) error {
+	switch strings.ToLower(param) {
+	default:
+		return fmt.Errorf("invalid parameter '%s'", param)
+
+	case "appendonly":
+		updated := *ac
+		updated.appendonly = value
+		if err := updated.validateAppendOnly(); err != nil {
+			return err
+		}
+		ac.appendonly = value
+
+	case "save":
+		updated := *ac
+		updated.save = value
+		if err := updated.validateSave(); err != nil {
+			return err
+		}
+		ac.save = value
+		ac.Save = updated.Save
+
+	case "notify-keyspace-events":
+		return ac.SetNotifyKeyspaceEvents(value)
+
+	case "list-max-listpack-size":
+		size, err := parseEncodingLimit(param, value)
+		if err != nil {
+			return err
+		}
+		ac.listMaxListpackSize = size
+
+	case "zset-max-listpack-entries":
+		entries, err := parseEncodingLimit(param, value)
+		if err != nil {
+			return err
+		}
+		ac.zsetMaxListpackEntries = entries
+
+	case "proto-max-bulk-len":
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 1 {
+			return fmt.Errorf("invalid value '%s' for '%s'. Must be a positive integer.", value, param)
+		}
+		ac.protoMaxBulkLen = length
+	}
+
+	return nil
+}
+
+// snapshotFormat returns the format snapshots are saved in.
+func (app *Application) snapshotFormat() SnapshotFormat {
+	config := app.configuration()
+	if config == nil || config.SnapshotFormat == "" {
+		return SnapshotRESP
+	}
+
+	return config.SnapshotFormat
+}
+
+// maxStringLength returns how long string values can grow, set by
+// proto-max-bulk-len.
+func (app *Application) maxStringLength() int64 {
+	config := app.configuration()
+	if config == nil || config.protoMaxBulkLen < 1 {
+		return defaultProtoMaxBulkLen
+	}
+
+	return config.protoMaxBulkLen
+}
+
+func parseEncodingLimit(param string, value string) (int64, error) {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid value '%s' for '%s'. Must be a non-negative integer.", value, param)
+	}
+	return limit, nil
+}
+
+// encodingLimits returns the sizes up to which collections are reported with
+// the listpack encoding.
+func (app *Application) encodingLimits() encodingLimits {
+	config := app.configuration()
+	if config == nil {
+		return defaultEncodingLimits
+	}
+
+	return encodingLimits{
+		listMaxListpackSize:    config.listMaxListpackSize,
+		zsetMaxListpackEntries: config.zsetMaxListpackEntries,
+	}
+}
+
 func (ac ApplicationConfiguration) validateAppendOnly() error {
 	if _, ok := validSaveOptions[strings.ToLower(ac.appendonly)]; !ok {
 		return fmt.Errorf("invalid appendonly option '%s'. Only 'yes' or 'no' allowed.", ac.appendonly)
@@ -418,26 +984,28 @@ func (ac ApplicationConfiguration) parseSave() ([]int64, error) {
 	return result, nil
 }
 
-func RunEveryNSeconds(d time.Duration, runner func()) func() {
-	ticker := time.NewTicker(d)
+// RunEveryNSeconds calls runner every d, as measured by c, until the returned
+// function is called.
+func RunEveryNSeconds(c ClockTimer, d time.Duration, runner func()) func() {
 	done := make(chan struct{})
 	stopFunc := func() { close(done) }
 
 	go func() {
 		var wg sync.WaitGroup
+		defer wg.Wait()
+
 		for {
 			select {
-			case <-ticker.C:
+			case <-c.After(d):
 				wg.Add(1)
 				go func() {
 					runner()
 					wg.Done()
 				}()
 			case <-done:
-				break
+				return
 			}
 		}
-		wg.Wait()
 	}()
 
 	return stopFunc
@@ -2,9 +2,13 @@ package redis
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"maps"
-	"slices"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -21,7 +25,7 @@ func setupApp(tC appTestCase) *Application {
 	logger := NewTestLogger()
 	app := NewApplication(nil, timer, logger)
 	app.state.keyspace.keys = tC.state.ks
-	app.state.keyspace.stringMap = tC.state.sm
+	app.state.keyspace.stringMap = toStringValueMap(tC.state.sm)
 	app.state.keyspace.listMap = tC.state.lm
 
 	return app
@@ -30,7 +34,7 @@ func setupApp(tC appTestCase) *Application {
 func TestStateSave(t *testing.T) {
 	now := time.Now()
 	tomorrow := now.Add(24 * time.Hour)
-	tmwUnix := tomorrow.Unix()
+	tmwMilli := tomorrow.UnixMilli()
 	tc := appTestCase{
 		now: now,
 		state: mapState{
@@ -50,18 +54,18 @@ func TestStateSave(t *testing.T) {
 			},
 		},
 		want: []byte(
-			"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n" +
-				"*3\r\n$3\r\nset\r\n$5\r\nLater\r\n$5\r\nhello\r\n" +
-				fmt.Sprintf("*3\r\n$8\r\nexpireat\r\n$5\r\nLater\r\n$%d\r\n%d\r\n", len(fmt.Sprint(tmwUnix)), tmwUnix) +
-				"*4\r\n$5\r\nrpush\r\n$8\r\nNameList\r\n$2\r\nhi\r\n$1\r\n1\r\n" +
+			"*3\r\n$3\r\nset\r\n$5\r\nLater\r\n$5\r\nhello\r\n" +
+				fmt.Sprintf("*3\r\n$9\r\npexpireat\r\n$5\r\nLater\r\n$%d\r\n%d\r\n", len(fmt.Sprint(tmwMilli)), tmwMilli) +
+				"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n" +
 				"*4\r\n$5\r\nrpush\r\n$9\r\nLaterList\r\n$5\r\nhello\r\n$1\r\n2\r\n" +
-				fmt.Sprintf("*3\r\n$8\r\nexpireat\r\n$9\r\nLaterList\r\n$%d\r\n%d\r\n", len(fmt.Sprint(tmwUnix)), tmwUnix),
+				fmt.Sprintf("*3\r\n$9\r\npexpireat\r\n$9\r\nLaterList\r\n$%d\r\n%d\r\n", len(fmt.Sprint(tmwMilli)), tmwMilli) +
+				"*4\r\n$5\r\nrpush\r\n$8\r\nNameList\r\n$2\r\nhi\r\n$1\r\n1\r\n",
 		),
 	}
 	app := setupApp(tc)
 	buf := new(bytes.Buffer)
 
-	err := app.state.Save(buf)
+	err := app.state.Save(buf, SnapshotRESP)
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
@@ -76,59 +80,180 @@ func TestStateSave(t *testing.T) {
 	}
 }
 
-func (e keyspaceEntry) IsEqual(o keyspaceEntry) bool {
-	if e.group != o.group {
-		return false
+func TestSnapshotNow(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { os.Chdir(wd) })
 
-	if e.expires == nil && o.expires != nil {
-		return false
+	now := time.Now()
+	app := setupApp(appTestCase{
+		now: now,
+		state: mapState{
+			ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+		},
+	})
+	app.state.keyspace.modifications = 1
+
+	if got := app.LastSave(); got != 0 {
+		t.Errorf("got last save: %d. want: 0", got)
 	}
 
-	if e.expires != nil && o.expires == nil {
-		return false
+	if err := app.SnapshotNow(); err != nil {
+		t.Fatalf("expected no error. got: %v", err)
 	}
 
-	if e.expires == nil && o.expires == nil {
-		return true
+	got, err := os.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	eexp := e.expires.Format(time.RFC3339)
-	oexp := o.expires.Format(time.RFC3339)
+	want := "*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n"
+	if string(got) != want {
+		t.Errorf("got: %#v. want: %#v", string(got), want)
+	}
 
-	return oexp == eexp
+	if app.state.keyspace.modifications != 0 {
+		t.Error("modifications counter must be reset after a snapshot")
+	}
+
+	if got := app.LastSave(); got != now.Unix() {
+		t.Errorf("got last save: %d. want: %d", got, now.Unix())
+	}
 }
 
-func (ks keyspace) IsEqual(o keyspace) bool {
-	for k, e := range ks.keys {
-		oe, ok := o.keys[k]
-		if !ok || !e.IsEqual(oe) {
-			return false
+func TestStateSaveSortedSet(t *testing.T) {
+	now := time.Now()
+	app := setupApp(appTestCase{
+		now: now,
+		state: mapState{
+			ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	})
+
+	tree := NewTree[float64, string]()
+	tree.Put(2.5, "Ford")
+	tree.Put(1, "Norem")
+	tree.Put(1, "Castilla")
+	app.state.keyspace.sortedSetMap = map[string]*sortedSet{"myset": toSortedSet(*tree)}
+
+	buf := new(bytes.Buffer)
+	err := app.state.Save(buf, SnapshotRESP)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	want := "*8\r\n$4\r\nzadd\r\n$5\r\nmyset\r\n$1\r\n1\r\n$8\r\nCastilla\r\n$1\r\n1\r\n$5\r\nNorem\r\n$3\r\n2.5\r\n$4\r\nFord\r\n"
+	got := buf.String()
+	if got != want {
+		t.Errorf("\ngot:\n%s\n\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateSaveKeepsCurrentExpiry(t *testing.T) {
+	now := time.Now()
+	app := setupApp(appTestCase{
+		now: now,
+		state: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	})
+
+	ks := &app.state.keyspace
+	ks.SetStringKey("Persisted", "John", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Expiring", "Mary", &ExpiryDuration{magnitude: 1500, resolution: time.Millisecond})
+	if !ks.Persist("Persisted") {
+		t.Fatal("expected expiry to be removed")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := app.state.Save(buf, SnapshotRESP); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	reloaded := setupApp(appTestCase{
+		now: now,
+		state: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	})
+	if err := reloaded.state.Load(buf, reloaded); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	persisted, ok := reloaded.state.keyspace.keys["Persisted"]
+	if !ok {
+		t.Fatal("expected persisted key to be reloaded")
+	}
+	if persisted.expires != nil {
+		t.Errorf("expected persisted key to have no expiry. got: %v", persisted.expires)
+	}
+
+	expiring, ok := reloaded.state.keyspace.keys["Expiring"]
+	if !ok {
+		t.Fatal("expected expiring key to be reloaded")
+	}
+	want := now.Add(1500 * time.Millisecond).UnixMilli()
+	if expiring.expires == nil || expiring.expires.UnixMilli() != want {
+		t.Errorf("expected expiry to keep millisecond precision. got: %v. want: %d", expiring.expires, want)
+	}
+}
+
+// changingWriter sets a key the first time it is written to, while the
+// snapshot holds the read lock.
+type changingWriter struct {
+	bytes.Buffer
+	state *ApplicationState
+	once  sync.Once
+}
+
+func (w *changingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		go w.state.keyspace.SetStringKey("During", "Mary", nil)
+		// wait for the set to queue on the lock, so it runs before Save takes
+		// the lock again
+		for w.state.mutex.TryRLock() {
+			w.state.mutex.RUnlock()
+			runtime.Gosched()
 		}
+	})
+	return w.Buffer.Write(p)
+}
 
-		if e.group == "string" {
-			if !maps.Equal(ks.stringMap, o.stringMap) {
-				return false
-			}
-		} else {
-			ev, ok1 := ks.listMap[k]
-			ov, ok2 := o.listMap[k]
-			if !ok1 || !ok2 {
-				return false
+func TestStateSaveKeepsChangesMadeWhileWriting(t *testing.T) {
+	for _, format := range []SnapshotFormat{SnapshotRESP, SnapshotBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			app := NewApplication(nil, TestClockTimer{mockNow: time.Now()}, NewTestLogger())
+			app.state.keyspace.SetStringKey("Name", "John", nil)
+
+			w := &changingWriter{state: app.state}
+			if err := app.state.Save(w, format); err != nil {
+				t.Fatalf("%s", err)
 			}
 
-			if !slices.Equal(ev.ToSlice(), ov.ToSlice()) {
-				return false
+			if got := app.state.keyspace.modifications; got != 1 {
+				t.Errorf("expected the change made while saving to count. got: %d. want: 1", got)
 			}
-		}
+		})
 	}
-
-	return true
 }
 
 func TestStateLoad(t *testing.T) {
 	now := time.Now()
-	tomorrow := now.Add(24 * time.Hour)
+	// expireat only keeps whole seconds
+	tomorrow := now.Add(24 * time.Hour).Truncate(time.Second)
 	tmwUnix := tomorrow.Unix()
 	want := keyspace{
 		keys: map[string]keyspaceEntry{
@@ -137,9 +262,9 @@ func TestStateLoad(t *testing.T) {
 			"NameList":  {group: "list", expires: nil},
 			"LaterList": {group: "list", expires: &tomorrow},
 		},
-		stringMap: map[string]string{
-			"Name":  "John",
-			"Later": "hello",
+		stringMap: map[string]stringValue{
+			"Name":  newStringValue([]byte("John")),
+			"Later": newStringValue([]byte("hello")),
 		},
 		listMap: map[string]list{
 			"NameList":  NewListFromSlice([]string{"hi", "1"}),
@@ -179,7 +304,429 @@ func TestStateLoad(t *testing.T) {
 	gotState := app.state
 	gotKs := gotState.keyspace
 
-	if !gotKs.IsEqual(want) {
+	if !gotKs.Equal(want) {
 		t.Errorf("got: %#v. want: %#v", gotKs, want)
 	}
 }
+
+func TestExpirerKeepsKeysSetAgain(t *testing.T) {
+	now := time.Now()
+	expired := &ExpiryDuration{magnitude: -1, resolution: time.Second}
+
+	t.Run("key set again before deletion", func(t *testing.T) {
+		app := NewApplication(nil, TestClockTimer{mockNow: now}, NewTestLogger())
+		ks := &app.state.keyspace
+		ks.SetStringKey("Name", "old", expired)
+		ks.SetStringKey("Name", "new", nil)
+
+		deleted := ks.BulkDeleteExpired([]string{"Name"})
+		if deleted["Name"] != 0 {
+			t.Errorf("expected key to not be deleted. got: %v", deleted)
+		}
+
+		kr := ks.Get("Name")
+		if !kr.IsString() || *kr.str != "new" {
+			t.Errorf("got: %#v. want: 'new'", kr)
+		}
+	})
+
+	t.Run("expirer running concurrently with writes", func(t *testing.T) {
+		app := NewApplication(nil, TestClockTimer{mockNow: now}, NewTestLogger())
+		ks := &app.state.keyspace
+
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					CheckAndExpireKeys(app)
+				}
+			}
+		}()
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			ks.SetStringKey("Name", "old", expired)
+			ks.SetStringKey("Name", "new", nil)
+
+			kr := ks.Get("Name")
+			if !kr.IsString() || *kr.str != "new" {
+				t.Fatalf("iteration %d: key set without expiry was deleted. got: %#v", i, kr)
+			}
+		}
+	})
+}
+
+func TestStateLoadInlineCommands(t *testing.T) {
+	now := time.Now()
+	tomorrow := now.Add(24 * time.Hour).Truncate(time.Second)
+	want := keyspace{
+		keys: map[string]keyspaceEntry{
+			"Name":     {group: "string", expires: nil},
+			"Later":    {group: "string", expires: &tomorrow},
+			"NameList": {group: "list", expires: nil},
+			"Counter":  {group: "string", expires: nil},
+		},
+		stringMap: map[string]stringValue{
+			"Name":    newStringValue([]byte("John")),
+			"Later":   newStringValue([]byte("hello")),
+			"Counter": newStringValue([]byte("2")),
+		},
+		listMap: map[string]list{
+			"NameList": NewListFromSlice([]string{"hi", "1"}),
+		},
+	}
+
+	data := []byte(
+		"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n" +
+			"set Later hello\r\n" +
+			fmt.Sprintf("expireat Later %d\n", tomorrow.Unix()) +
+			"*4\r\n$5\r\nrpush\r\n$8\r\nNameList\r\n$2\r\nhi\r\n$1\r\n1\r\n" +
+			"  incr   Counter\r\n" +
+			"*2\r\n$4\r\nincr\r\n$7\r\nCounter\r\n",
+	)
+
+	app := setupApp(
+		appTestCase{
+			now: now,
+			state: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			}})
+
+	err := app.state.Load(bytes.NewReader(data), app)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !app.state.keyspace.Equal(want) {
+		t.Errorf("got: %#v. want: %#v", app.state.keyspace, want)
+	}
+}
+
+func TestStateLoadTruncated(t *testing.T) {
+	complete := "*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n"
+	truncated := "*3\r\n$3\r\nset\r\n$5\r\nLater\r\n$5\r\nhel"
+	data := []byte(complete + truncated)
+
+	setup := func(strict bool) (*Application, *bytes.Buffer) {
+		app := setupApp(appTestCase{
+			now: time.Now(),
+			state: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		})
+		app.config.Store(&ApplicationConfiguration{StrictSnapshotLoad: strict})
+
+		logs := new(bytes.Buffer)
+		app.logger = slog.New(slog.NewTextHandler(logs, &testLogOpts))
+		return app, logs
+	}
+
+	t.Run("truncated command is reported", func(t *testing.T) {
+		app, logs := setup(false)
+		if err := app.state.Load(bytes.NewReader(data), app); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if !app.state.keyspace.Exists("Name") {
+			t.Error("expected the complete command to be loaded")
+		}
+		if app.state.keyspace.Exists("Later") {
+			t.Error("expected the truncated command to be skipped")
+		}
+
+		want := fmt.Sprintf("%d bytes were left over", len(truncated))
+		if got := logs.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, want) {
+			t.Errorf("expected a warning with '%s'. got logs:\n%s", want, got)
+		}
+	})
+
+	t.Run("complete snapshot is not reported", func(t *testing.T) {
+		app, logs := setup(false)
+		if err := app.state.Load(bytes.NewReader([]byte(complete)), app); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if got := logs.String(); strings.Contains(got, "left over") {
+			t.Errorf("expected no warning. got logs:\n%s", got)
+		}
+	})
+
+	t.Run("strict mode fails", func(t *testing.T) {
+		app, _ := setup(true)
+		err := app.state.Load(bytes.NewReader(data), app)
+		if !errors.Is(err, ErrTruncatedSnapshot) {
+			t.Errorf("got error: %v. want: %v", err, ErrTruncatedSnapshot)
+		}
+	})
+}
+
+func TestBinarySnapshot(t *testing.T) {
+	now := time.Now()
+	empty := appTestCase{
+		now: now,
+		state: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app := setupApp(empty)
+	ks := &app.state.keyspace
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Counter", "-42", nil)
+	ks.SetStringKey("Session", "abc\r\n\x00", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.PushToTail("Names", []string{"John", "", "Mary"})
+	ks.PushToTail("Queue", []string{"job"})
+	ks.Expire("Queue", 60)
+	ks.PutInSortedSet("Scores", []string{"1.5", "John", "-inf", "Mary", "1.5", "Ford", "inf", "Norem"}, zaddAlways, false)
+
+	resp := new(bytes.Buffer)
+	if err := app.state.Save(resp, SnapshotRESP); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	binary := new(bytes.Buffer)
+	if err := app.state.Save(binary, SnapshotBinary); err != nil {
+		t.Fatalf("%s", err)
+	}
+	snapshot := binary.Bytes()
+
+	t.Run("round trip", func(t *testing.T) {
+		reloaded := setupApp(empty)
+		if err := reloaded.state.Load(bytes.NewReader(snapshot), reloaded); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if !reloaded.state.keyspace.Equal(app.state.keyspace) {
+			t.Errorf("got: %#v. want: %#v", reloaded.state.keyspace, app.state.keyspace)
+		}
+		if reloaded.state.keyspace.modifications != 0 {
+			t.Error("modifications counter must be 0 after calling load")
+		}
+	})
+
+	t.Run("smaller than resp", func(t *testing.T) {
+		if len(snapshot) >= resp.Len() {
+			t.Errorf("got binary size: %d. want less than the resp size: %d", len(snapshot), resp.Len())
+		}
+	})
+
+	t.Run("expired keys are skipped", func(t *testing.T) {
+		later := setupApp(appTestCase{now: now.Add(time.Minute), state: empty.state})
+		if err := later.state.Load(bytes.NewReader(snapshot), later); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if later.state.keyspace.Exists("Session") || later.state.keyspace.Exists("Queue") {
+			t.Error("expected expired keys to be skipped")
+		}
+		if !later.state.keyspace.Exists("Name") {
+			t.Error("expected persistent keys to be loaded")
+		}
+	})
+
+	t.Run("truncated entry is reported", func(t *testing.T) {
+		truncated := setupApp(empty)
+		logs := new(bytes.Buffer)
+		truncated.logger = slog.New(slog.NewTextHandler(logs, &testLogOpts))
+
+		// keys are saved sorted, so the cut lands in Session, the last entry
+		cut := snapshot[:len(snapshot)-10]
+		if err := truncated.state.Load(bytes.NewReader(cut), truncated); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		_, scores := truncated.state.keyspace.keys["Scores"]
+		_, session := truncated.state.keyspace.keys["Session"]
+		if !scores || session {
+			t.Error("expected the entries before the truncated one to be loaded")
+		}
+		if got := logs.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "left over") {
+			t.Errorf("expected a warning. got logs:\n%s", got)
+		}
+
+		truncated.config.Store(&ApplicationConfiguration{StrictSnapshotLoad: true})
+		if err := truncated.state.Load(bytes.NewReader(cut), truncated); !errors.Is(err, ErrTruncatedSnapshot) {
+			t.Errorf("got error: %v. want: %v", err, ErrTruncatedSnapshot)
+		}
+	})
+}
+
+func TestCheckAndExpireKeys(t *testing.T) {
+	now := time.Now()
+	app := NewApplication(nil, TestClockTimer{mockNow: now}, NewTestLogger())
+	ks := &app.state.keyspace
+
+	expired := &ExpiryDuration{magnitude: -1, resolution: time.Second}
+	later := &ExpiryDuration{magnitude: 1, resolution: time.Hour}
+	for i := 0; i < 100; i++ {
+		ks.SetStringKey(fmt.Sprintf("persistent:%d", i), "value", nil)
+		ks.SetStringKey(fmt.Sprintf("expired:%d", i), "value", expired)
+	}
+	for i := 0; i < 10; i++ {
+		ks.SetStringKey(fmt.Sprintf("later:%d", i), "value", later)
+	}
+	ks.Persist("later:0")
+
+	if len(ks.volatile) != 109 {
+		t.Fatalf("expected only keys with expiry to be tracked. got: %d", len(ks.volatile))
+	}
+
+	// with only 9 keys expiring later, most of every sample is expired, so
+	// a single run keeps sampling until every expired key is deleted
+	CheckAndExpireKeys(app)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := ks.keys[fmt.Sprintf("expired:%d", i)]; ok {
+			t.Errorf("expected 'expired:%d' to be deleted", i)
+		}
+	}
+
+	if len(ks.keys) != 110 {
+		t.Errorf("expected keys without expiry or expiring later to be kept. got: %d keys", len(ks.keys))
+	}
+
+	if len(ks.volatile) != 9 {
+		t.Errorf("expected deleted keys to not be tracked. got: %d", len(ks.volatile))
+	}
+}
+
+func BenchmarkExpireKeys(b *testing.B) {
+	now := time.Now()
+	app := NewApplication(nil, TestClockTimer{mockNow: now}, NewTestLogger())
+	ks := &app.state.keyspace
+
+	// a large keyspace where a few keys have an expiry that is not due yet
+	later := &ExpiryDuration{magnitude: 1, resolution: time.Hour}
+	for i := 0; i < 100_000; i++ {
+		var exp *ExpiryDuration
+		if i%100 == 0 {
+			exp = later
+		}
+		ks.SetStringKey(fmt.Sprintf("key:%d", i), "value", exp)
+	}
+
+	b.Run("full scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ks.mutex.RLock()
+			keys := GetKeys(ks.keys, func(ke keyspaceEntry) bool { return CheckIsExpired(app.clock, ke) })
+			ks.mutex.RUnlock()
+
+			ks.BulkDeleteExpired(keys)
+		}
+	})
+
+	b.Run("sampled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CheckAndExpireKeys(app)
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer safe to write from the goroutines of a runner
+// while a test reads it.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeat(t *testing.T) {
+	now := time.Now()
+	out := &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(out, &testLogOpts))
+	app := NewApplication(nil, TestClockTimer{mockNow: now}, logger)
+	app.state.keyspace.SetStringKey("Name", "John", nil)
+	app.state.keyspace.SetStringKey("Surname", "Doe", nil)
+
+	stop := app.SetupHeartbeat(10 * time.Millisecond)
+	defer stop()
+
+	want := "heartbeat. clients: 0. keys: 2. changes since last save: 2"
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected heartbeat to be logged. got logs: %q", out.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunEveryNSeconds(t *testing.T) {
+	now := time.Now()
+	fire := make(chan time.Time)
+	ran := make(chan struct{})
+
+	stop := RunEveryNSeconds(TestClockTimer{mockNow: now, fire: fire}, time.Hour, func() { ran <- struct{}{} })
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		fire <- now
+		select {
+		case <-ran:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected run %d after the timer fired", i+1)
+		}
+	}
+}
+
+func TestSortedSetExpiry(t *testing.T) {
+	now := time.Now()
+	assertDeleted := func(t *testing.T, ks *keyspace) {
+		t.Helper()
+		if _, ok := ks.keys["Scores"]; ok {
+			t.Error("expected key to be deleted")
+		}
+		if _, ok := ks.sortedSetMap["Scores"]; ok {
+			t.Error("expected sorted set to be deleted")
+		}
+		if _, ok := ks.volatile["Scores"]; ok {
+			t.Error("expected key to no longer be volatile")
+		}
+	}
+
+	setup := func() *Application {
+		app := NewApplication(nil, TestClockTimer{mockNow: now}, NewTestLogger())
+		app.state.keyspace.PutInSortedSet("Scores", []string{"1", "John", "2", "Jane"}, zaddAlways, false)
+		if !app.state.keyspace.Expire("Scores", 10) {
+			t.Fatal("expected key to exist")
+		}
+
+		// the deadline passes
+		app.state.keyspace.clock = TestClockTimer{mockNow: now.Add(time.Minute)}
+		return app
+	}
+
+	t.Run("lazily on access", func(t *testing.T) {
+		app := setup()
+		if kr := app.state.keyspace.Get("Scores"); kr.IsValid() {
+			t.Errorf("expected expired key to be missing. got: %#v", kr)
+		}
+		assertDeleted(t, &app.state.keyspace)
+	})
+
+	t.Run("actively by the expirer", func(t *testing.T) {
+		app := setup()
+		CheckAndExpireKeys(app)
+		assertDeleted(t, &app.state.keyspace)
+	})
+}
@@ -1,29 +1,60 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"redis"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
 	programName := os.Args[0]
 	args := os.Args[1:]
 
 	c, err := NewConfigs(programName, args)
+	if errors.Is(err, flag.ErrHelp) {
+		// usage was already printed by the parser
+		os.Exit(0)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	if c.ShowVersion {
+		fmt.Printf("%s %s\n", filepath.Base(programName), version)
+		os.Exit(0)
+	}
+
+	logOut := os.Stderr
+	if c.LogFile != "" {
+		f, err := os.OpenFile(c.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logOut = f
+	}
+
 	logOpts := &slog.HandlerOptions{
 		Level: c.LogLevel,
 	}
-	logHandler := slog.NewTextHandler(os.Stderr, logOpts)
+	var logHandler slog.Handler = slog.NewTextHandler(logOut, logOpts)
+	if c.LogFormat == "json" {
+		logHandler = slog.NewJSONHandler(logOut, logOpts)
+	}
 	logger := slog.New(logHandler)
 
 	server, err := redis.NewServer(c.Host, c.Port, logger)
@@ -36,27 +67,68 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	config.ProcessPerConnection = c.PerConnection
+	config.Workers = c.Workers
+	config.SubscriberBuffer = c.SubscriberBuffer
+	config.DebugKeyspace = c.DebugKeyspace
+	config.DebugExpire = c.DebugExpire
+	config.StrictSnapshotLoad = c.StrictSnapshotLoad
+	config.SnapshotFormat = c.SnapshotFormat
+	config.ReadOnly = c.ReadOnly
+	config.TCPNoDelay = c.TCPNoDelay
+	config.TCPKeepAlive = c.TCPKeepAlive
 
 	timer := redis.RealClockTimer{}
 	app := redis.NewApplication(config, timer, logger)
 
 	app.LoadStateFromSnapshot()
-	app.SetupSnapshotSavers()
-	app.SetupKeyExpirer()
+	defer app.SetupSnapshotSavers()()
+	defer app.SetupKeyExpirer()()
+	if c.Heartbeat > 0 {
+		defer app.SetupHeartbeat(c.Heartbeat)()
+	}
+
+	if c.HealthPort != 0 {
+		healthAddr := net.JoinHostPort(c.Host, strconv.Itoa(c.HealthPort))
+		handler := redis.NewHealthHandler(server.Addr().String(), app)
+		go func() {
+			logger.Info("Initialized health server " + healthAddr)
+			if err := http.ListenAndServe(healthAddr, handler); err != nil {
+				logger.Error(fmt.Sprintf("health server stopped: %v", err))
+			}
+		}()
+	}
 
 	redis.Listen(server, app, logger)
 }
 
 type configs struct {
-	Host     string
-	Port     int
-	LogLevel slog.Level
+	Host          string
+	Port          int
+	LogLevel      slog.Level
+	LogFile       string
+	LogFormat     string
+	PerConnection bool
+	Workers       int
+	HealthPort    int
+	Heartbeat     time.Duration
+	ShowVersion   bool
+
+	SubscriberBuffer   int
+	DebugKeyspace      bool
+	DebugExpire        bool
+	StrictSnapshotLoad bool
+	SnapshotFormat     redis.SnapshotFormat
+	ReadOnly           bool
+	TCPNoDelay         bool
+	TCPKeepAlive       time.Duration
 }
 
 func NewConfigs(programName string, args []string) (*configs, error) {
 	c := configs{
-		Host:     "localhost",
-		LogLevel: slog.LevelInfo,
+		Host:      "localhost",
+		LogLevel:  slog.LevelInfo,
+		LogFormat: "text",
 	}
 
 	err := c.Parse(programName, args)
@@ -79,7 +151,15 @@ func (c *configs) Parse(programName string, args []string) error {
 		return nil
 	})
 
-	flags.IntVar(&c.Port, "p", 6700, "host port")
+	c.Port = 6700
+	flags.Func("p", "host port, from 1 to 65535 (default 6700)", func(s string) error {
+		port, err := parsePort(s)
+		if err != nil {
+			return err
+		}
+		c.Port = port
+		return nil
+	})
 
 	flags.Func("l", "logger level", func(s string) error {
 		switch strings.ToLower(s) {
@@ -98,6 +178,57 @@ func (c *configs) Parse(programName string, args []string) error {
 		return nil
 	})
 
+	flags.StringVar(&c.LogFile, "logfile", "", "append the logs to `file` instead of writing them to stderr")
+	flags.Func("logformat", "logs format: text or json (default text)", func(s string) error {
+		switch strings.ToLower(s) {
+		default:
+			return fmt.Errorf("invalid logs format '%s'", s)
+		case "text", "json":
+			c.LogFormat = strings.ToLower(s)
+		}
+
+		return nil
+	})
+
+	flags.BoolVar(&c.PerConnection, "per-connection", false, "process the requests of each connection in its own goroutine")
+	flags.IntVar(&c.Workers, "workers", 1, "number of goroutines processing requests, ignored with --per-connection")
+	flags.IntVar(&c.SubscriberBuffer, "subscriber-buffer", 1024, "published messages waiting to be written to a subscriber before it is disconnected")
+	flags.Func("health-port", "port of the HTTP server exposing /health and /metrics, from 1 to 65535 (disabled by default)", func(s string) error {
+		port, err := parsePort(s)
+		if err != nil {
+			return err
+		}
+		c.HealthPort = port
+		return nil
+	})
+	flags.BoolVar(&c.TCPNoDelay, "tcp-nodelay", true, "send small replies right away instead of batching them, by disabling Nagle's algorithm")
+	flags.DurationVar(&c.TCPKeepAlive, "tcp-keepalive", 300*time.Second, "period between the keepalive probes sent to idle clients, 0 disables them")
+	flags.Func("snapshot-format", "format of the saved snapshots: resp or binary (default resp). Snapshots in either format are loaded", func(s string) error {
+		switch format := redis.SnapshotFormat(strings.ToLower(s)); format {
+		default:
+			return fmt.Errorf("invalid snapshot format '%s'", s)
+		case redis.SnapshotRESP, redis.SnapshotBinary:
+			c.SnapshotFormat = format
+		}
+
+		return nil
+	})
+	flags.BoolVar(&c.ReadOnly, "read-only", false, "reject the commands that write, like a read only replica")
+	flags.BoolVar(&c.StrictSnapshotLoad, "strict-snapshot-load", false, "start with an empty state when the snapshot ends with a truncated command, instead of loading the commands before it")
+	flags.BoolVar(&c.DebugKeyspace, "enable-debug-keyspace", false, "enable DEBUG KEYSPACE, which lists every key. Meant for tests")
+	flags.BoolVar(&c.DebugExpire, "enable-debug-expire", false, "enable DEBUG EXPIRE, which sets the expiry of a key to any time. Meant for tests")
+	flags.DurationVar(&c.Heartbeat, "heartbeat", 0, "log the number of clients, keys and unsaved changes every `interval`, e.g. 30s (disabled by default)")
+	flags.BoolVar(&c.ShowVersion, "v", false, "print the version and exit")
+	flags.BoolVar(&c.ShowVersion, "version", false, "print the version and exit")
+
+	// -h is the host address, so usage is only printed with --help
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: %s [options]\n\n", programName)
+		fmt.Fprintf(out, "Starts a redis compatible server.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
 	err := flags.Parse(args)
 	if err != nil {
 		return err
@@ -105,3 +236,11 @@ func (c *configs) Parse(programName string, args []string) error {
 
 	return nil
 }
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port '%s'. Must be a number from 1 to 65535", s)
+	}
+	return port, nil
+}
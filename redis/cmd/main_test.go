@@ -0,0 +1,207 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigsParser(t *testing.T) {
+	t.Run("version flags should be set", func(t *testing.T) {
+		for _, arg := range []string{"-v", "--version"} {
+			c, err := NewConfigs("redis-server-go", []string{arg})
+			if err != nil {
+				t.Fatalf("expected no error for '%s'. got: %v", arg, err)
+			}
+
+			if !c.ShowVersion {
+				t.Errorf("expected version to be shown for '%s'", arg)
+			}
+		}
+	})
+
+	t.Run("version should not be shown by default", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.ShowVersion {
+			t.Error("expected version to not be shown")
+		}
+	})
+
+	t.Run("h should still be the host address", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{"-h", "127.0.0.1", "-v"})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.Host != "127.0.0.1" {
+			t.Errorf("got: %s. want: 127.0.0.1", c.Host)
+		}
+
+		if !c.ShowVersion {
+			t.Error("expected version to be shown")
+		}
+	})
+}
+
+func TestTCPFlags(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if !c.TCPNoDelay {
+			t.Error("expected nodelay to be enabled by default")
+		}
+		if c.TCPKeepAlive != 300*time.Second {
+			t.Errorf("got keepalive: %v. want: 5m0s", c.TCPKeepAlive)
+		}
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{"--tcp-nodelay=false", "--tcp-keepalive", "30s"})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.TCPNoDelay {
+			t.Error("expected nodelay to be disabled")
+		}
+		if c.TCPKeepAlive != 30*time.Second {
+			t.Errorf("got keepalive: %v. want: 30s", c.TCPKeepAlive)
+		}
+	})
+}
+
+func TestPortParser(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		port    string
+		want    int
+		wantErr bool
+	}{
+		{desc: "normal port", port: "6379", want: 6379},
+		{desc: "highest port", port: "65535", want: 65535},
+		{desc: "port 0", port: "0", wantErr: true},
+		{desc: "port out of range", port: "70000", wantErr: true},
+		{desc: "negative port", port: "-1", wantErr: true},
+		{desc: "not a number", port: "redis", wantErr: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c, err := NewConfigs("redis-server-go", []string{"-p", tC.port})
+			if tC.wantErr {
+				if err == nil {
+					t.Errorf("expected an error for port '%s'", tC.port)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if c.Port != tC.want {
+				t.Errorf("got: %d. want: %d", c.Port, tC.want)
+			}
+		})
+	}
+
+	t.Run("default port", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.Port != 6700 {
+			t.Errorf("got: %d. want: 6700", c.Port)
+		}
+	})
+}
+
+func TestHealthPortParser(t *testing.T) {
+	c, err := NewConfigs("redis-server-go", []string{})
+	if err != nil {
+		t.Fatalf("expected no error. got: %v", err)
+	}
+
+	if c.HealthPort != 0 {
+		t.Errorf("expected health server to be disabled by default. got port: %d", c.HealthPort)
+	}
+
+	c, err = NewConfigs("redis-server-go", []string{"--health-port", "8080"})
+	if err != nil {
+		t.Fatalf("expected no error. got: %v", err)
+	}
+
+	if c.HealthPort != 8080 {
+		t.Errorf("got: %d. want: 8080", c.HealthPort)
+	}
+
+	if _, err := NewConfigs("redis-server-go", []string{"--health-port", "0"}); err == nil {
+		t.Error("expected an error for port '0'")
+	}
+}
+
+func TestLogFlagsParser(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.LogFile != "" {
+			t.Errorf("expected logs to go to stderr. got file: %s", c.LogFile)
+		}
+
+		if c.LogFormat != "text" {
+			t.Errorf("got: %s. want: text", c.LogFormat)
+		}
+	})
+
+	t.Run("file and format", func(t *testing.T) {
+		c, err := NewConfigs("redis-server-go", []string{"--logfile", "server.log", "--logformat", "JSON"})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if c.LogFile != "server.log" {
+			t.Errorf("got: %s. want: server.log", c.LogFile)
+		}
+
+		if c.LogFormat != "json" {
+			t.Errorf("got: %s. want: json", c.LogFormat)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		if _, err := NewConfigs("redis-server-go", []string{"--logformat", "xml"}); err == nil {
+			t.Error("expected an error for format 'xml'")
+		}
+	})
+}
+
+func TestHeartbeatParser(t *testing.T) {
+	c, err := NewConfigs("redis-server-go", []string{})
+	if err != nil {
+		t.Fatalf("expected no error. got: %v", err)
+	}
+	if c.Heartbeat != 0 {
+		t.Errorf("expected heartbeat to be disabled by default. got: %v", c.Heartbeat)
+	}
+
+	c, err = NewConfigs("redis-server-go", []string{"--heartbeat", "30s"})
+	if err != nil {
+		t.Fatalf("expected no error. got: %v", err)
+	}
+	if c.Heartbeat != 30*time.Second {
+		t.Errorf("got: %v. want: 30s", c.Heartbeat)
+	}
+
+	if _, err := NewConfigs("redis-server-go", []string{"--heartbeat", "often"}); err == nil {
+		t.Error("expected error for invalid interval")
+	}
+}
@@ -0,0 +1,43 @@
+// respinspect reads RESP frames from stdin and prints one decoded command per
+// line. Useful to check payloads crafted by hand for the server tests.
+package main
+
+import (
+	"fmt"
+	"os"
+	"redis"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	line := 0
+	err := redis.InspectMessages(os.Stdin, func(elements []string, err error) {
+		line++
+		if err != nil {
+			fmt.Printf("%d: error: %v\n", line, err)
+			return
+		}
+
+		fmt.Printf("%d: %s\n", line, formatCommand(elements))
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read from stdin. err:", err)
+		os.Exit(1)
+	}
+}
+
+func formatCommand(elements []string) string {
+	if len(elements) == 0 {
+		return "(empty)"
+	}
+
+	parts := make([]string, 0, len(elements))
+	parts = append(parts, strings.ToUpper(elements[0]))
+	for _, e := range elements[1:] {
+		parts = append(parts, strconv.Quote(e))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -1,9 +1,14 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +18,11 @@ type Command string
 
 type CommandResult struct {
 	message []byte
+
+	// stream, when set, writes the reply straight to each target in place of
+	// message. Commands with replies that can grow large use it, so the reply
+	// is never built in memory as a whole.
+	stream  func(w io.Writer) error
 	targets []net.Conn
 }
 
@@ -24,16 +34,44 @@ const (
 	CONFIG    = "CONFIG"
 	EXPIRE    = "EXPIRE"
 	EXPIREAT  = "EXPIREAT"
+	PEXPIREAT = "PEXPIREAT"
+	PERSIST   = "PERSIST"
 	EXISTS    = "EXISTS"
 	DEL       = "DEL"
 	INCR      = "INCR"
 	DECR      = "DECR"
+	INCRBY    = "INCRBY"
+	DECRBY    = "DECRBY"
 	RPUSH     = "RPUSH"
 	LPUSH     = "LPUSH"
 	SUBSCRIBE = "SUBSCRIBE"
 	PUBLISH   = "PUBLISH"
 	ZADD      = "ZADD"
 	ZRANGE    = "ZRANGE"
+	ZSCORE    = "ZSCORE"
+	APPEND    = "APPEND"
+	ZSCAN     = "ZSCAN"
+	DEBUG     = "DEBUG"
+	TIME      = "TIME"
+	MEMORY    = "MEMORY"
+	OBJECT    = "OBJECT"
+	CLIENT    = "CLIENT"
+	REPLICAOF = "REPLICAOF"
+	IDGEN     = "IDGEN"
+	EVAL      = "EVAL"
+	MSETEX    = "MSETEX"
+	PUBSUB    = "PUBSUB"
+	INFO      = "INFO"
+	RANDOMKEY = "RANDOMKEY"
+	CAS       = "CAS"
+	MIGRATE   = "MIGRATE"
+
+	ZUNION      = "ZUNION"
+	ZINTER      = "ZINTER"
+	ZDIFF       = "ZDIFF"
+	ZUNIONSTORE = "ZUNIONSTORE"
+	ZINTERSTORE = "ZINTERSTORE"
+	ZDIFFSTORE  = "ZDIFFSTORE"
 )
 
 var cmdParseTable = map[string]Command{
@@ -44,16 +82,161 @@ var cmdParseTable = map[string]Command{
 	"config":    CONFIG,
 	"expire":    EXPIRE,
 	"expireat":  EXPIREAT,
+	"pexpireat": PEXPIREAT,
+	"persist":   PERSIST,
 	"exists":    EXISTS,
 	"del":       DEL,
+	"unlink":    DEL,
 	"incr":      INCR,
 	"decr":      DECR,
+	"incrby":    INCRBY,
+	"decrby":    DECRBY,
 	"rpush":     RPUSH,
 	"lpush":     LPUSH,
 	"subscribe": SUBSCRIBE,
 	"publish":   PUBLISH,
 	"zadd":      ZADD,
 	"zrange":    ZRANGE,
+	"zscore":    ZSCORE,
+	"append":    APPEND,
+	"zscan":     ZSCAN,
+	"debug":     DEBUG,
+	"time":      TIME,
+	"memory":    MEMORY,
+	"object":    OBJECT,
+	"client":    CLIENT,
+	"replicaof": REPLICAOF,
+	"slaveof":   REPLICAOF,
+	"idgen":     IDGEN,
+	"eval":      EVAL,
+	"msetex":    MSETEX,
+	"pubsub":    PUBSUB,
+	"info":      INFO,
+	"randomkey": RANDOMKEY,
+	"cas":       CAS,
+	"migrate":   MIGRATE,
+
+	"zunion":      ZUNION,
+	"zinter":      ZINTER,
+	"zdiff":       ZDIFF,
+	"zunionstore": ZUNIONSTORE,
+	"zinterstore": ZINTERSTORE,
+	"zdiffstore":  ZDIFFSTORE,
+}
+
+// commandArity holds how many elements each command takes, its name included,
+// following the redis convention: a positive arity is the exact count and a
+// negative one, -N, means at least N. Process checks it before dispatching,
+// so the process functions only validate what depends on the arguments
+// themselves, like options that must come in pairs.
+var commandArity = map[Command]int{
+	PING:      -1,
+	ECHO:      2,
+	SET:       -3,
+	GET:       2,
+	CONFIG:    -2,
+	EXPIRE:    3,
+	EXPIREAT:  3,
+	PEXPIREAT: 3,
+	PERSIST:   2,
+	EXISTS:    -2,
+	DEL:       -2,
+	INCR:      2,
+	DECR:      2,
+	INCRBY:    3,
+	DECRBY:    3,
+	RPUSH:     -2,
+	LPUSH:     -2,
+	SUBSCRIBE: -2,
+	PUBLISH:   3,
+	ZADD:      -4,
+	ZRANGE:    -4,
+	ZSCORE:    3,
+	APPEND:    3,
+	ZSCAN:     -3,
+	DEBUG:     -2,
+	TIME:      1,
+	MEMORY:    -2,
+	OBJECT:    -2,
+	CLIENT:    -2,
+	REPLICAOF: 3,
+	IDGEN:     2,
+	EVAL:      -3,
+	MSETEX:    -4,
+	PUBSUB:    -2,
+	INFO:      -1,
+	RANDOMKEY: -1,
+	CAS:       4,
+	MIGRATE:   6,
+
+	ZUNION:      -3,
+	ZINTER:      -3,
+	ZDIFF:       -3,
+	ZUNIONSTORE: -4,
+	ZINTERSTORE: -4,
+	ZDIFFSTORE:  -4,
+}
+
+// commandWrites tells, for every command, whether it changes the dataset.
+// Read-only mode rejects the commands that do. EVAL is not a write itself,
+// since the commands of its script are checked one by one.
+var commandWrites = map[Command]bool{
+	PING:      false,
+	ECHO:      false,
+	SET:       true,
+	GET:       false,
+	CONFIG:    false,
+	EXPIRE:    true,
+	EXPIREAT:  true,
+	PEXPIREAT: true,
+	PERSIST:   true,
+	EXISTS:    false,
+	DEL:       true,
+	INCR:      true,
+	DECR:      true,
+	INCRBY:    true,
+	DECRBY:    true,
+	RPUSH:     true,
+	LPUSH:     true,
+	SUBSCRIBE: false,
+	PUBLISH:   false,
+	ZADD:      true,
+	ZRANGE:    false,
+	ZSCORE:    false,
+	APPEND:    true,
+	ZSCAN:     false,
+	DEBUG:     false,
+	TIME:      false,
+	MEMORY:    false,
+	OBJECT:    false,
+	CLIENT:    false,
+	REPLICAOF: false,
+	IDGEN:     true,
+	EVAL:      false,
+	MSETEX:    true,
+	PUBSUB:    false,
+	INFO:      false,
+	RANDOMKEY: false,
+	CAS:       true,
+	MIGRATE:   true,
+
+	ZUNION:      false,
+	ZINTER:      false,
+	ZDIFF:       false,
+	ZUNIONSTORE: true,
+	ZINTERSTORE: true,
+	ZDIFFSTORE:  true,
+}
+
+// hasValidArity reports whether n elements, the command name included, match
+// the arity of cmd.
+func hasValidArity(cmd Command, n int) bool {
+	arity := commandArity[cmd]
+	if arity < 0 {
+		return n >= -arity
+	}
+
+	return n == arity
 }
 
 type Cmd struct {
@@ -62,9 +245,21 @@ type Cmd struct {
 	cmd       Command
 	args      []string
 	sender    net.Conn
+
+	// inScript is set on the commands run by an EVAL script, which already
+	// holds the script lock.
+	inScript bool
+
+	// internal is set on the commands the server runs on its own, like the
+	// ones replayed from a snapshot, which read-only mode does not reject.
+	internal bool
 }
 
 func (c *Cmd) Parse() error {
+	if len(c.processed) == 0 {
+		return ErrEmptyCommand
+	}
+
 	lower := strings.ToLower(c.processed[0])
 	cmd, ok := cmdParseTable[lower]
 	if !ok {
@@ -77,14 +272,37 @@ func (c *Cmd) Parse() error {
 	return nil
 }
 
-func (c *Cmd) Process() (*CommandResult, error) {
+// Process runs the command. Blocking commands abort when ctx is done, which
+// happens when the client disconnects or the server shuts down. Commands that
+// don't block ignore it. A command that fails replies with its error,
+// serialized by SerializeError.
+func (c *Cmd) Process(ctx context.Context) *CommandResult {
 	err := c.Parse()
 	targets := []net.Conn{c.sender}
 	if err != nil {
-		return &CommandResult{message: []byte(""), targets: targets}, err
+		return &CommandResult{message: []byte(SerializeError(err)), targets: targets}
+	}
+
+	if !hasValidArity(c.cmd, len(c.processed)) {
+		return &CommandResult{message: []byte(SerializeError(c.wrongNumOfArgs())), targets: targets}
+	}
+
+	if config := c.app.configuration(); commandWrites[c.cmd] && !c.internal && config != nil && config.ReadOnly {
+		return &CommandResult{message: []byte(SerializeError(ErrReadOnly)), targets: targets}
+	}
+
+	// EVAL runs while no other command does, so its commands are applied
+	// atomically.
+	if c.cmd == EVAL {
+		c.app.scriptMutex.Lock()
+		defer c.app.scriptMutex.Unlock()
+	} else if !c.inScript {
+		c.app.scriptMutex.RLock()
+		defer c.app.scriptMutex.RUnlock()
 	}
 
 	var r string
+	var stream func(io.Writer) error
 
 	switch c.cmd {
 	default:
@@ -102,7 +320,7 @@ func (c *Cmd) Process() (*CommandResult, error) {
 		r, err = processSet(c.args, c.app)
 
 	case GET:
-		r, err = processGet(c.args, c.app)
+		r, err = processGet(c.args, c.sender, c.app)
 
 	case CONFIG:
 		r, err = processConfig(c.args, c.app)
@@ -113,6 +331,12 @@ func (c *Cmd) Process() (*CommandResult, error) {
 	case EXPIREAT:
 		r, err = processExpireAt(c.args, c.app)
 
+	case PEXPIREAT:
+		r, err = processPExpireAt(c.args, c.app)
+
+	case PERSIST:
+		r, err = processPersist(c.args, c.app)
+
 	case EXISTS:
 		r, err = processExists(c.args, c.app)
 
@@ -125,6 +349,12 @@ func (c *Cmd) Process() (*CommandResult, error) {
 	case DECR:
 		r, err = processDecrement(c.args, c.app)
 
+	case INCRBY:
+		r, err = processIncrementBy(c.args, c.app)
+
+	case DECRBY:
+		r, err = processDecrementBy(c.args, c.app)
+
 	case RPUSH:
 		r, err = processRPush(c.args, c.app)
 
@@ -132,53 +362,250 @@ func (c *Cmd) Process() (*CommandResult, error) {
 		r, err = processLPush(c.args, c.app)
 
 	case SUBSCRIBE:
-		r, err = processSubscribe(c.args, c.sender, c.app)
+		r, targets, err = processSubscribe(c.args, c.sender, c.app)
 
 	case PUBLISH:
-		r, targets, err = processPublish(c.args, c.sender, c.app)
-
-		// REFACTOR: I find this rather ugly/cumbersome to write a response to publisher connection
-		// while still inside the command.
-		c.sender.Write([]byte(SerializeInteger(len(targets))))
+		r, err = processPublish(c.args, c.sender, c.app)
 
 	case ZADD:
 		r, err = processZAdd(c.args, c.app)
 
 	case ZRANGE:
-		r, err = processZRange(c.args, c.app)
+		r, stream, err = processZRange(c.args, c.sender, c.app)
+
+	case ZSCORE:
+		r, err = processZScore(c.args, c.sender, c.app)
+
+	case APPEND:
+		r, err = processAppend(c.args, c.app)
+
+	case ZSCAN:
+		r, err = processZScan(c.args, c.sender, c.app)
+
+	case DEBUG:
+		r, err = processDebug(ctx, c.args, c.app)
+
+	case TIME:
+		r, err = processTime(c.args, c.app)
+
+	case MEMORY:
+		r, err = processMemory(c.args, c.app)
+
+	case OBJECT:
+		r, err = processObject(c.args, c.app)
+
+	case CLIENT:
+		r, err = processClient(c.args, c.sender, c.app)
+
+	case REPLICAOF:
+		r, err = processReplicaOf(c.args, c.app)
+
+	case IDGEN:
+		r, err = processIdGen(c.args, c.app)
+
+	case EVAL:
+		r, err = processEval(ctx, c.args, c.sender, c.app)
+
+	case MSETEX:
+		r, err = processMSetEx(c.args, c.app)
+
+	case PUBSUB:
+		r, err = processPubSub(c.args, c.app)
+
+	case INFO:
+		r, err = processInfo(c.args, c.app)
+
+	case RANDOMKEY:
+		r, err = processRandomKey(c.args, c.app)
+
+	case CAS:
+		r, err = processCas(c.args, c.app)
+
+	case MIGRATE:
+		r, err = processMigrate(ctx, c.args, c.app)
+
+	case ZUNION, ZINTER, ZDIFF:
+		r, stream, err = processZCombine(c.cmd, c.args, c.app)
+
+	case ZUNIONSTORE, ZINTERSTORE, ZDIFFSTORE:
+		r, err = processZCombineStore(c.cmd, c.args, c.app)
+	}
+
+	// the process functions don't know the name the command was called by
+	if err == ErrWrongArgs {
+		err = c.wrongNumOfArgs()
+	}
+
+	var wrongType *wrongTypeKeyError
+	if errors.As(err, &wrongType) {
+		c.app.logger.Debug("wrong type",
+			slog.String("command", strings.ToLower(string(c.cmd))),
+			slog.String("key", wrongType.key),
+		)
+	}
+
+	if err != nil {
+		return &CommandResult{message: []byte(SerializeError(err)), targets: targets}
 	}
 
-	return &CommandResult{message: []byte(r), targets: targets}, err
+	return &CommandResult{message: []byte(r), stream: stream, targets: targets}
 }
 
-var wrongNumOfArgsErr = errors.New("wrong number of arguments.")
+// redactedArg replaces the arguments that may hold a password in logs.
+const redactedArg = "(redacted)"
 
-func processEcho(args []string) (string, error) {
-	if len(args) != 1 {
-		return "", wrongNumOfArgsErr
+// sensitiveConfigs are the CONFIG SET parameters whose value is a password.
+var sensitiveConfigs = map[string]bool{"requirepass": true, "masterauth": true}
+
+// redactArgs returns the arguments of the command in processed, its name
+// excluded, with the ones that may hold a password replaced by redactedArg:
+// every argument of AUTH, the username and password after the AUTH option of
+// HELLO and the values of the sensitiveConfigs given to CONFIG SET.
+func redactArgs(processed []string) []string {
+	args := slices.Clone(processed[1:])
+
+	switch strings.ToLower(processed[0]) {
+	case "auth":
+		for i := range args {
+			args[i] = redactedArg
+		}
+
+	case "hello":
+		for i := 0; i < len(args); i++ {
+			if strings.EqualFold(args[i], "auth") {
+				for j := i + 1; j < len(args) && j <= i+2; j++ {
+					args[j] = redactedArg
+				}
+				i += 2
+			}
+		}
+
+	case "config":
+		if len(args) == 0 || !strings.EqualFold(args[0], "set") {
+			break
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			if sensitiveConfigs[strings.ToLower(args[i])] {
+				args[i+1] = redactedArg
+			}
+		}
 	}
 
+	return args
+}
+
+// wrongNumOfArgs returns the error naming the command, as it was called, for
+// a number of arguments that doesn't match it.
+func (c *Cmd) wrongNumOfArgs() error {
+	return fmt.Errorf("%w for '%s' command", ErrWrongArgs, strings.ToLower(c.processed[0]))
+}
+
+// commandHelp holds the usage lines replied by the HELP subcommand of the
+// command families that have subcommands.
+var commandHelp = map[Command][]string{
+	OBJECT: {
+		"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"ENCODING <key>",
+		"    Return the kind of internal representation used in order to store the value associated with a <key>.",
+		"FREQ <key>",
+		"    Return the logarithmic access frequency counter of <key>.",
+		"HELP",
+		"    Print this help.",
+	},
+	CLIENT: {
+		"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"NO-EVICT (ON|OFF)",
+		"    Accepted for compatibility only, keys are never evicted.",
+		"NO-TOUCH (ON|OFF)",
+		"    Keep the reads of this connection from changing the access frequency of keys.",
+		"HELP",
+		"    Print this help.",
+	},
+	CONFIG: {
+		"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"GET <parameter> [<parameter> ...]",
+		"    Return the values of the configuration parameters.",
+		"SET <parameter> <value> [<parameter> <value> ...]",
+		"    Set the configuration parameters to the given values.",
+		"HELP",
+		"    Print this help.",
+	},
+	DEBUG: {
+		"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CHANGE-REPL-ID",
+		"    Accepted for compatibility only, this server has no replication ID.",
+		"EXPIRE <key> <unix-time-milliseconds>",
+		"    Set the expiry of the key, past times included. Only enabled with --enable-debug-expire.",
+		"KEYSPACE",
+		"    List every key with its group and expiry. Only enabled with --enable-debug-keyspace.",
+		"OBJECT <key>",
+		"    Show low level info about the key and associated value.",
+		"RELOAD",
+		"    Save the dataset to a temp file and load it back, replacing the current one.",
+		"SLEEP <seconds>",
+		"    Block the processing of the connection for <seconds>. Decimals allowed.",
+		"HELP",
+		"    Print this help.",
+	},
+	PUBSUB: {
+		"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"NUMSUB [<channel> ...]",
+		"    Return the number of subscribers for the specified channels.",
+		"HELP",
+		"    Print this help.",
+	},
+	MEMORY: {
+		"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"USAGE <key> [SAMPLES <count>]",
+		"    Return the approximate memory usage in bytes of <key> and its value.",
+		"HELP",
+		"    Print this help.",
+	},
+}
+
+// serializeHelp serializes help lines as an array of simple strings, the way
+// redis-cli expects them.
+func serializeHelp(lines []string) string {
+	result := fmt.Sprintf("*%d\r\n", len(lines))
+	for _, line := range lines {
+		result += SerializeSimpleString(line)
+	}
+	return result
+}
+
+// touchesKeys reports whether the reads of sender count as accesses to the
+// keys. Requests without a known client, like the ones replayed from a
+// snapshot, always do.
+func touchesKeys(sender net.Conn, app *Application) bool {
+	if sender == nil {
+		return true
+	}
+
+	client, err := app.GetClient(sender)
+	return err != nil || !client.noTouch
+}
+
+func processEcho(args []string) (string, error) {
 	return SerializeBulkString(args[0]), nil
 }
 
 func processSet(args []string, app *Application) (string, error) {
 	nArgs := len(args)
-	if nArgs < 2 {
-		return "", wrongNumOfArgsErr
-	}
-
 	if nArgs > 2 && nArgs != 4 {
-		return "", wrongNumOfArgsErr
+		return "", ErrWrongArgs
 	}
 
 	key := args[0]
 	value := args[1]
+	if int64(len(value)) > app.maxStringLength() {
+		return "", ErrStringTooLong
+	}
 
 	var expiry *ExpiryDuration
 	if nArgs > 2 {
 		resolutionType := strings.ToUpper(args[2])
 		if resolutionType != "EX" && resolutionType != "PX" {
-			return "", errors.New("invalid resolution type")
+			return "", fmt.Errorf("%w, invalid resolution type '%s'", ErrSyntax, args[2])
 		}
 
 		var resolution time.Duration
@@ -201,13 +628,39 @@ func processSet(args []string, app *Application) (string, error) {
 	return OK_SIMPLE_STRING, nil
 }
 
-func processGet(args []string, app *Application) (string, error) {
-	if len(args) != 1 {
-		return "", wrongNumOfArgsErr
+// processMSetEx handles MSETEX seconds key value [key value ...], a non
+// standard command setting every pair with the same expiry at once.
+func processMSetEx(args []string, app *Application) (string, error) {
+	if len(args)%2 != 1 {
+		return "", ErrWrongArgs
+	}
+
+	seconds, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || seconds <= 0 {
+		return "", errors.New("invalid expire time in 'msetex' command")
 	}
 
+	for i := 2; i < len(args); i += 2 {
+		if int64(len(args[i])) > app.maxStringLength() {
+			return "", ErrStringTooLong
+		}
+	}
+
+	expiry := &ExpiryDuration{magnitude: seconds, resolution: time.Second}
+	app.state.keyspace.SetStringKeys(args[1:], expiry)
+
+	return OK_SIMPLE_STRING, nil
+}
+
+func processGet(args []string, sender net.Conn, app *Application) (string, error) {
 	key := args[0]
-	k := app.state.keyspace.Get(key)
+	k, err := app.state.keyspace.GetTyped(key, "string", touchesKeys(sender, app))
+	if errors.Is(err, ErrWrongType) {
+		// redis would reply with an error here, but we deliberately treat
+		// keys of other types as non existing for GET
+		return NIL_BULK_STRING, nil
+	}
+
 	if !k.IsValid() || !k.IsString() {
 		return NIL_BULK_STRING, nil
 	}
@@ -216,56 +669,97 @@ func processGet(args []string, app *Application) (string, error) {
 }
 
 func processConfig(args []string, app *Application) (string, error) {
+	if len(args) == 1 && strings.ToUpper(args[0]) == "HELP" {
+		return serializeHelp(commandHelp[CONFIG]), nil
+	}
+
 	if len(args) < 2 {
-		return "", wrongNumOfArgsErr
+		return "", ErrWrongArgs
 	}
 
+	config := app.configuration()
 	cmd := strings.ToUpper(args[0])
 	switch cmd {
 	default:
-		return SerializeSimpleError(fmt.Sprintf("invalid cmd '%s'", cmd)), nil
+		return "", fmt.Errorf("invalid cmd '%s'", cmd)
 	case "GET":
 		params := args[1:]
 
 		// this is supposed to be a slice of strings, however go forces
 		// us to use a slice of interface to allow array serialization
-		configs := make([]interface{}, len(params))
+		configs := make([]interface{}, 0, 2*len(params))
 
 		for _, p := range params {
 			p = strings.ToLower(p)
 			if _, ok := configMap[p]; !ok {
-				return SerializeSimpleError(fmt.Sprintf("invalid parameter '%s'", p)), nil
+				return "", fmt.Errorf("invalid parameter '%s'", p)
 			}
 
 			switch p {
 			case "appendonly":
 				configs = append(configs, p)
-				configs = append(configs, app.config.appendonly)
+				configs = append(configs, config.appendonly)
 
 			case "save":
 				configs = append(configs, p)
-				configs = append(configs, app.config.save)
+				configs = append(configs, config.save)
+
+			case "notify-keyspace-events":
+				configs = append(configs, p)
+				configs = append(configs, config.notifyKeyspaceEvents)
+
+			case "list-max-listpack-size":
+				configs = append(configs, p)
+				configs = append(configs, strconv.FormatInt(config.listMaxListpackSize, 10))
+
+			case "zset-max-listpack-entries":
+				configs = append(configs, p)
+				configs = append(configs, strconv.FormatInt(config.zsetMaxListpackEntries, 10))
+
+			case "proto-max-bulk-len":
+				configs = append(configs, p)
+				configs = append(configs, strconv.FormatInt(app.maxStringLength(), 10))
 			}
 
 		}
 
 		return SerializeArray(configs), nil
 
+	case "SET":
+		pairs := args[1:]
+		if len(pairs)%2 != 0 {
+			return "", ErrWrongArgs
+		}
+
+		// either every parameter is set or none is. The configuration is
+		// replaced instead of changed, since other goroutines read it while
+		// holding no lock, and set again if another CONFIG SET replaced it
+		// in between
+		for {
+			updated := *config
+			for i := 0; i < len(pairs); i += 2 {
+				if err := updated.Set(pairs[i], pairs[i+1]); err != nil {
+					return "", err
+				}
+			}
+
+			if app.config.CompareAndSwap(config, &updated) {
+				break
+			}
+			config = app.configuration()
+		}
+
+		return OK_SIMPLE_STRING, nil
 	}
 }
 
 func processExpire(args []string, app *Application) (string, error) {
-	if len(args) != 2 {
-		return "", wrongNumOfArgsErr
-	}
-
 	key := args[0]
 	rawDelta := args[1]
 
 	delta, err := strconv.ParseInt(rawDelta, 10, 0)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse '%s' to integer", rawDelta)
-		return SerializeSimpleError(msg), nil
+		return "", fmt.Errorf("could not parse '%s' to integer", rawDelta)
 	}
 
 	ok := app.state.keyspace.Expire(key, delta)
@@ -277,20 +771,33 @@ func processExpire(args []string, app *Application) (string, error) {
 }
 
 func processExpireAt(args []string, app *Application) (string, error) {
-	if len(args) != 2 {
-		return "", wrongNumOfArgsErr
+	key := args[0]
+	rawStamp := args[1]
+
+	stamp, err := strconv.ParseInt(rawStamp, 10, 0)
+	if err != nil {
+		return "", fmt.Errorf("could not parse '%s' to integer", rawStamp)
 	}
 
+	deadline := time.Unix(stamp, 0)
+	ok := app.state.keyspace.ExpireAt(key, deadline)
+	if !ok {
+		return SerializeInteger(0), nil
+	}
+
+	return SerializeInteger(1), nil
+}
+
+func processPExpireAt(args []string, app *Application) (string, error) {
 	key := args[0]
 	rawStamp := args[1]
 
 	stamp, err := strconv.ParseInt(rawStamp, 10, 0)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse '%s' to integer", rawStamp)
-		return SerializeSimpleError(msg), nil
+		return "", fmt.Errorf("could not parse '%s' to integer", rawStamp)
 	}
 
-	deadline := time.Unix(stamp, 0)
+	deadline := time.UnixMilli(stamp)
 	ok := app.state.keyspace.ExpireAt(key, deadline)
 	if !ok {
 		return SerializeInteger(0), nil
@@ -299,11 +806,15 @@ func processExpireAt(args []string, app *Application) (string, error) {
 	return SerializeInteger(1), nil
 }
 
-func processExists(args []string, app *Application) (string, error) {
-	if len(args) < 1 {
-		return "", wrongNumOfArgsErr
+func processPersist(args []string, app *Application) (string, error) {
+	if !app.state.keyspace.Persist(args[0]) {
+		return SerializeInteger(0), nil
 	}
 
+	return SerializeInteger(1), nil
+}
+
+func processExists(args []string, app *Application) (string, error) {
 	keyCount := app.state.keyspace.BulkExists(args)
 
 	finalCount := 0
@@ -316,10 +827,6 @@ func processExists(args []string, app *Application) (string, error) {
 }
 
 func processDelete(args []string, app *Application) (string, error) {
-	if len(args) < 1 {
-		return "", wrongNumOfArgsErr
-	}
-
 	keyCount := app.state.keyspace.BulkDelete(args)
 
 	finalCount := 0
@@ -332,78 +839,130 @@ func processDelete(args []string, app *Application) (string, error) {
 }
 
 func processIncrement(args []string, app *Application) (string, error) {
-	if len(args) != 1 {
-		return "", wrongNumOfArgsErr
+	return incrementKey(args[0], 1, app)
+}
+
+func processDecrement(args []string, app *Application) (string, error) {
+	return incrementKey(args[0], -1, app)
+}
+
+func processIncrementBy(args []string, app *Application) (string, error) {
+	amount, err := parseInteger(args[1])
+	if err != nil {
+		return "", err
 	}
 
-	key := args[0]
-	value, err := app.state.keyspace.IncrementBy(key, 1)
+	return incrementKey(args[0], amount, app)
+}
+
+func processDecrementBy(args []string, app *Application) (string, error) {
+	amount, err := parseInteger(args[1])
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
-	return SerializeInteger(value), nil
+	if amount == math.MinInt64 {
+		return "", ErrOverflow
+	}
+
+	return incrementKey(args[0], -amount, app)
 }
 
-func processDecrement(args []string, app *Application) (string, error) {
-	if len(args) != 1 {
-		return "", wrongNumOfArgsErr
+// parseInteger parses the amount argument of the integer commands, failing
+// with the same error they give for stored values that are not integers.
+func parseInteger(raw string) (int, error) {
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
 	}
 
-	key := args[0]
-	value, err := app.state.keyspace.IncrementBy(key, -1)
+	return int(value), nil
+}
+
+// incrementKey adds delta to the integer stored at key and serializes the
+// reply shared by INCR, DECR, INCRBY and DECRBY.
+func incrementKey(key string, delta int, app *Application) (string, error) {
+	value, err := app.state.keyspace.IncrementBy(key, delta)
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
 	return SerializeInteger(value), nil
 }
 
-func processRPush(args []string, app *Application) (string, error) {
-	if len(args) < 1 {
-		return "", wrongNumOfArgsErr
+// idGenKeyPrefix prefixes the keys holding the counters of IDGEN. They are
+// plain string keys, so they are saved and loaded like any other key.
+const idGenKeyPrefix = "__idgen__:"
+
+// processIdGen returns the next id of the namespace, starting at 1. It is not
+// a redis command.
+func processIdGen(args []string, app *Application) (string, error) {
+	return incrementKey(idGenKeyPrefix+args[0], 1, app)
+}
+
+func processAppend(args []string, app *Application) (string, error) {
+	key := args[0]
+	value := args[1]
+
+	length, err := app.state.keyspace.Append(key, value, app.maxStringLength())
+	if err != nil {
+		return "", err
+	}
+
+	return SerializeInteger(length), nil
+}
+
+// processCas sets the key to the new value only if it holds the expected one,
+// replying 1 when it does and 0 otherwise. It is not a redis command.
+func processCas(args []string, app *Application) (string, error) {
+	key, expected, value := args[0], args[1], args[2]
+	if int64(len(value)) > app.maxStringLength() {
+		return "", ErrStringTooLong
+	}
+
+	swapped, err := app.state.keyspace.CompareAndSet(key, expected, value)
+	if err != nil {
+		return "", err
+	}
+
+	if swapped {
+		return SerializeInteger(1), nil
 	}
+	return SerializeInteger(0), nil
+}
 
+func processRPush(args []string, app *Application) (string, error) {
 	key := args[0]
 	values := args[1:]
 
 	length, err := app.state.keyspace.PushToTail(key, values)
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
 	return SerializeInteger(length), nil
 }
 
 func processLPush(args []string, app *Application) (string, error) {
-	if len(args) < 1 {
-		return "", wrongNumOfArgsErr
-	}
-
 	key := args[0]
 	values := args[1:]
 
 	length, err := app.state.keyspace.PushToHead(key, values)
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
 	return SerializeInteger(length), nil
 }
 
-func processSubscribe(args []string, sender net.Conn, app *Application) (string, error) {
-	if len(args) < 1 {
-		return "", wrongNumOfArgsErr
-	}
-
+func processSubscribe(args []string, sender net.Conn, app *Application) (string, []net.Conn, error) {
 	client, err := app.GetClient(sender)
 	if err != nil {
-		return "", err
+		return "", []net.Conn{}, err
 	}
 
 	response := ""
 	for i, cName := range args {
-		app.SubscribeConnection(cName, sender)
 		client.SubscribeTo(cName)
 
 		arr := make([]interface{}, 0)
@@ -414,92 +973,869 @@ func processSubscribe(args []string, sender net.Conn, app *Application) (string,
 		response += SerializeArray(arr)
 	}
 
-	return response, nil
+	// the confirmation is written along with the subscription, so there is
+	// nothing left for the caller to send
+	err = app.SubscribeConnection(args, sender, []byte(response))
+	return "", []net.Conn{}, err
 }
 
-func processPublish(args []string, sender net.Conn, app *Application) (string, []net.Conn, error) {
-	if len(args) != 2 {
-		return "", []net.Conn{}, wrongNumOfArgsErr
-	}
-
+func processPublish(args []string, sender net.Conn, app *Application) (string, error) {
 	channel := args[0]
 	message := args[1]
 
-	targets := app.GetConnectionsPerChannelExcludingConn(channel, sender)
-	if len(targets) == 0 {
-		app.pubsubChannels[channel] = make(map[string]net.Conn)
-	}
+	receivers := app.Publish(channel, message, sender)
+	return SerializeInteger(receivers), nil
+}
+
+func processPubSub(args []string, app *Application) (string, error) {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	default:
+		return "", fmt.Errorf("invalid subcommand '%s'", args[0])
 
-	result := make([]interface{}, 0)
-	result = append(result, "message")
-	result = append(result, channel)
-	result = append(result, message)
+	case "HELP":
+		return serializeHelp(commandHelp[PUBSUB]), nil
 
-	response := SerializeArray(result)
-	return response, targets, nil
-}
+	case "NUMSUB":
+		counts := make([]interface{}, 0, 2*len(args[1:]))
+		for _, chName := range args[1:] {
+			counts = append(counts, chName, app.NumSubscribers(chName))
+		}
 
-func processZAdd(args []string, app *Application) (string, error) {
-	if len(args) < 3 {
-		return "", wrongNumOfArgsErr
+		return SerializeArray(counts), nil
 	}
+}
 
+// processZAdd handles ZADD key [NX | XX] [CH] [INCR] score member [score
+// member ...]. With INCR it works like ZINCRBY, replying with the new score,
+// or with nil when NX or XX kept the member from being written.
+func processZAdd(args []string, app *Application) (string, error) {
 	key := args[0]
 	values := args[1:]
 
-	if len(values)%2 != 0 {
-		msg := "<score> <member> values must come in pairs"
-		return SerializeSimpleError(msg), nil
+	cond := zaddAlways
+	ch, incr, nx, xx := false, false, false, false
+options:
+	for len(values) > 0 {
+		switch strings.ToUpper(values[0]) {
+		default:
+			break options
+		case "NX":
+			nx, cond = true, zaddOnlyNew
+		case "XX":
+			xx, cond = true, zaddOnlyExisting
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		}
+		values = values[1:]
+	}
+
+	if nx && xx {
+		return "", errors.New("XX and NX options at the same time are not compatible")
+	}
+
+	if len(values) == 0 || len(values)%2 != 0 {
+		return "", errors.New("<score> <member> values must come in pairs")
 	}
 
+	// NaN compares false against every score, so it can't be ordered
 	for i := 0; i < len(values); i += 2 {
-		rawScore := values[i]
-		_, err := strconv.ParseFloat(rawScore, 64)
+		score, err := strconv.ParseFloat(values[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return "", ErrNotFloat
+		}
+	}
+
+	if incr {
+		if len(values) != 2 {
+			return "", errors.New("INCR option supports a single increment-element pair")
+		}
+
+		delta, _ := strconv.ParseFloat(values[0], 64)
+		score, ok, err := app.state.keyspace.IncrementSortedSetScore(key, values[1], delta, cond)
 		if err != nil {
-			msg := fmt.Sprintf("could not parse '%s' to float", rawScore)
-			return SerializeSimpleError(msg), nil
+			return "", err
 		}
+		if !ok {
+			return NIL_BULK_STRING, nil
+		}
+		return SerializeBulkString(formatScore(score)), nil
 	}
 
-	length, err := app.state.keyspace.PutInSortedSet(key, values)
+	length, err := app.state.keyspace.PutInSortedSet(key, values, cond, ch)
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
 	return SerializeInteger(length), nil
 }
 
-func processZRange(args []string, app *Application) (string, error) {
-	if len(args) != 3 {
-		return "", wrongNumOfArgsErr
+// zrangeArgs holds the arguments of ZRANGE key start stop [BYSCORE | BYLEX]
+// [REV] [LIMIT offset count] [WITHSCORES].
+type zrangeArgs struct {
+	key   string
+	start string
+	stop  string
+
+	// by is how start and stop are read: as ranks when empty, or as
+	// "BYSCORE" or "BYLEX" bounds.
+	by         string
+	rev        bool
+	limited    bool
+	offset     int64
+	count      int64
+	withScores bool
+
+	// startRank and stopRank are start and stop of a range of ranks, while
+	// inRange reports whether a member is within the bounds of BYSCORE or
+	// BYLEX, and is nil for a range of ranks.
+	startRank int64
+	stopRank  int64
+	inRange   func(scoredMember) bool
+}
+
+func parseZRangeArgs(args []string) (zrangeArgs, error) {
+	za := zrangeArgs{key: args[0], start: args[1], stop: args[2]}
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		default:
+			return za, fmt.Errorf("%w, invalid option '%s'", ErrSyntax, args[i])
+
+		case "BYSCORE", "BYLEX":
+			za.by = opt
+
+		case "REV":
+			za.rev = true
+
+		case "WITHSCORES":
+			za.withScores = true
+
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return za, ErrSyntax
+			}
+
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return za, ErrNotInteger
+			}
+			count, err := strconv.ParseInt(args[i+2], 10, 64)
+			if err != nil {
+				return za, ErrNotInteger
+			}
+			za.limited, za.offset, za.count = true, offset, count
+			i += 2
+		}
+	}
+
+	if za.limited && za.by == "" {
+		return za, fmt.Errorf("%w, LIMIT is only supported in combination with either BYSCORE or BYLEX", ErrSyntax)
+	}
+	if za.withScores && za.by == "BYLEX" {
+		return za, fmt.Errorf("%w, WITHSCORES not supported in combination with BYLEX", ErrSyntax)
+	}
+
+	return za, za.parseRange()
+}
+
+// parseRange parses start and stop as ranks, or as the bounds of BYSCORE and
+// BYLEX. With REV, start and stop of BYSCORE and BYLEX are swapped, like in
+// redis.
+func (za *zrangeArgs) parseRange() error {
+	if za.by == "" {
+		start, err := strconv.ParseInt(za.start, 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+
+		stop, err := strconv.ParseInt(za.stop, 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+
+		za.startRank, za.stopRank = start, stop
+		return nil
 	}
 
-	key := args[0]
-	rawStart := args[1]
-	rawStop := args[2]
+	rawMin, rawMax := za.start, za.stop
+	if za.rev {
+		rawMin, rawMax = rawMax, rawMin
+	}
+
+	switch za.by {
+	case "BYSCORE":
+		min, err := parseScoreBound(rawMin)
+		if err != nil {
+			return err
+		}
+		max, err := parseScoreBound(rawMax)
+		if err != nil {
+			return err
+		}
+		za.inRange = func(m scoredMember) bool { return min.admitsAsMin(m.score) && max.admitsAsMax(m.score) }
+
+	case "BYLEX":
+		min, err := parseLexBound(rawMin)
+		if err != nil {
+			return err
+		}
+		max, err := parseLexBound(rawMax)
+		if err != nil {
+			return err
+		}
+		za.inRange = func(m scoredMember) bool { return min.admitsAsMin(m.member) && max.admitsAsMax(m.member) }
+	}
 
-	start, err := strconv.ParseInt(rawStart, 0, 10)
+	return nil
+}
+
+// window returns how many of the members of set within the range come before
+// the ones replied, and how many are replied. With REV the members are
+// counted from the highest score down.
+func (za zrangeArgs) window(set *sortedSet) (int64, int64) {
+	if za.inRange == nil {
+		return rankWindow(set.Len(), za.startRank, za.stopRank)
+	}
+
+	matches := int64(0)
+	set.Walk(za.rev, func(m scoredMember) bool {
+		if za.inRange(m) {
+			matches++
+		}
+		return true
+	})
+
+	if !za.limited {
+		return 0, matches
+	}
+	if za.offset < 0 || za.offset >= matches {
+		return 0, 0
+	}
+
+	n := matches - za.offset
+	if za.count >= 0 && za.count < n {
+		n = za.count
+	}
+	return za.offset, n
+}
+
+// write writes the members of the range of set to w as they are walked, so
+// the reply is never held in memory.
+func (za zrangeArgs) write(w io.Writer, set *sortedSet) error {
+	skip, n := za.window(set)
+	length := n
+	if za.withScores {
+		length *= 2
+	}
+	if _, err := fmt.Fprintf(w, "*%d\r\n", length); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var err error
+	set.Walk(za.rev, func(m scoredMember) bool {
+		if za.inRange != nil && !za.inRange(m) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+
+		err = writeScoredMember(w, m, za.withScores)
+		n--
+		return err == nil && n > 0
+	})
+	return err
+}
+
+// scoreBound is a score bound of BYSCORE. It is exclusive when prefixed by
+// '(', and can be -inf or +inf.
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+func parseScoreBound(raw string) (scoreBound, error) {
+	b := scoreBound{}
+	if strings.HasPrefix(raw, "(") {
+		b.exclusive = true
+		raw = raw[1:]
+	}
+
+	score, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(score) {
+		return b, errors.New("min or max is not a float")
+	}
+	b.score = score
+	return b, nil
+}
+
+// admitsAsMin reports whether score is within the bound used as a minimum.
+func (b scoreBound) admitsAsMin(score float64) bool {
+	return score > b.score || (!b.exclusive && score == b.score)
+}
+
+// admitsAsMax reports whether score is within the bound used as a maximum.
+func (b scoreBound) admitsAsMax(score float64) bool {
+	return score < b.score || (!b.exclusive && score == b.score)
+}
+
+// lexBound is a member bound of BYLEX: '[' or '(' followed by the member for
+// an inclusive or exclusive bound, or '-' and '+' for the lowest and highest
+// possible members.
+type lexBound struct {
+	member    string
+	exclusive bool
+	infinite  int
+}
+
+func parseLexBound(raw string) (lexBound, error) {
+	switch {
+	case raw == "-":
+		return lexBound{infinite: -1}, nil
+	case raw == "+":
+		return lexBound{infinite: 1}, nil
+	case strings.HasPrefix(raw, "["):
+		return lexBound{member: raw[1:]}, nil
+	case strings.HasPrefix(raw, "("):
+		return lexBound{member: raw[1:], exclusive: true}, nil
+	}
+
+	return lexBound{}, errors.New("min or max not valid string range item")
+}
+
+// admitsAsMin reports whether member is within the bound used as a minimum.
+func (b lexBound) admitsAsMin(member string) bool {
+	if b.infinite != 0 {
+		return b.infinite < 0
+	}
+	return member > b.member || (!b.exclusive && member == b.member)
+}
+
+// admitsAsMax reports whether member is within the bound used as a maximum.
+func (b lexBound) admitsAsMax(member string) bool {
+	if b.infinite != 0 {
+		return b.infinite > 0
+	}
+	return member < b.member || (!b.exclusive && member == b.member)
+}
+
+func processZRange(args []string, sender net.Conn, app *Application) (string, func(io.Writer) error, error) {
+	za, err := parseZRangeArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the type of the key is checked before the reply is streamed, so it can
+	// still be replied with an error
+	ks := &app.state.keyspace
+	if err := ks.ReadSortedSet(za.key, false, func(*sortedSet) error { return nil }); err != nil {
+		return "", nil, err
+	}
+
+	// the members are written while the set is read locked, instead of
+	// being copied out of it first
+	touch := touchesKeys(sender, app)
+	stream := func(w io.Writer) error {
+		err := ks.ReadSortedSet(za.key, touch, func(set *sortedSet) error {
+			return za.write(w, set)
+		})
+
+		// the key was replaced by a value of another type since it was checked
+		var wrongType *wrongTypeKeyError
+		if errors.As(err, &wrongType) {
+			_, err = io.WriteString(w, SerializeError(err))
+		}
+		return err
+	}
+
+	return "", stream, nil
+}
+
+// sortedSetCombineOps maps the commands combining sorted sets to the
+// operation they do.
+var sortedSetCombineOps = map[Command]string{
+	ZUNION:      "union",
+	ZINTER:      "inter",
+	ZDIFF:       "diff",
+	ZUNIONSTORE: "union",
+	ZINTERSTORE: "inter",
+	ZDIFFSTORE:  "diff",
+}
+
+type sortedSetCombineArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  string
+	withScores bool
+}
+
+// parseSortedSetCombineArgs parses numkeys key [key ...] followed by the
+// options of cmd: WEIGHTS and AGGREGATE for unions and intersections, and
+// WITHSCORES for the commands that reply with the members.
+func parseSortedSetCombineArgs(cmd Command, args []string) (sortedSetCombineArgs, error) {
+	parsed := sortedSetCombineArgs{aggregate: "sum"}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return parsed, fmt.Errorf("could not parse '%s' to integer", args[0])
+	}
+	if numKeys < 1 {
+		return parsed, fmt.Errorf("at least 1 input key is needed for '%s' command", strings.ToLower(string(cmd)))
+	}
+	if numKeys > len(args)-1 {
+		return parsed, ErrWrongArgs
+	}
+
+	parsed.keys = args[1 : numKeys+1]
+	isDiff := sortedSetCombineOps[cmd] == "diff"
+	isStore := strings.HasSuffix(string(cmd), "STORE")
+
+	options := args[numKeys+1:]
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(options[i])
+		switch {
+		case option == "WEIGHTS" && !isDiff:
+			if len(options)-i-1 < numKeys {
+				return parsed, ErrWrongArgs
+			}
+
+			parsed.weights = make([]float64, 0, numKeys)
+			for _, raw := range options[i+1 : i+1+numKeys] {
+				weight, err := strconv.ParseFloat(raw, 64)
+				if err != nil {
+					return parsed, fmt.Errorf("could not parse '%s' to float", raw)
+				}
+				parsed.weights = append(parsed.weights, weight)
+			}
+			i += numKeys
+
+		case option == "AGGREGATE" && !isDiff:
+			if i+1 >= len(options) {
+				return parsed, ErrWrongArgs
+			}
+
+			aggregate := strings.ToLower(options[i+1])
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return parsed, fmt.Errorf("invalid aggregate '%s'. Only 'sum', 'min' or 'max' allowed.", options[i+1])
+			}
+			parsed.aggregate = aggregate
+			i++
+
+		case option == "WITHSCORES" && !isStore:
+			parsed.withScores = true
+
+		default:
+			return parsed, fmt.Errorf("%w, invalid option '%s'", ErrSyntax, options[i])
+		}
+	}
+
+	return parsed, nil
+}
+
+func processZCombine(cmd Command, args []string, app *Application) (string, func(io.Writer) error, error) {
+	parsed, err := parseSortedSetCombineArgs(cmd, args)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse '%s' to integer", rawStart)
-		return SerializeSimpleError(msg), nil
+		return "", nil, err
 	}
 
-	stop, err := strconv.ParseInt(rawStop, 0, 10)
+	members, err := app.state.keyspace.SortedSetCombine(sortedSetCombineOps[cmd], parsed.keys, parsed.weights, parsed.aggregate)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse '%s' to integer", rawStop)
-		return SerializeSimpleError(msg), nil
+		return "", nil, err
+	}
+
+	stream := func(w io.Writer) error {
+		return writeScoredMembers(w, members, parsed.withScores)
 	}
 
-	values, err := app.state.keyspace.GetSortedSetValuesByRange(key, start, stop)
+	return "", stream, nil
+}
+
+func processZCombineStore(cmd Command, args []string, app *Application) (string, error) {
+	dest := args[0]
+	parsed, err := parseSortedSetCombineArgs(cmd, args[1:])
 	if err != nil {
-		return SerializeSimpleError(err.Error()), nil
+		return "", err
 	}
 
-	result := make([]interface{}, 0)
-	for _, v := range values {
-		result = append(result, v)
+	size, err := app.state.keyspace.SortedSetCombineStore(dest, sortedSetCombineOps[cmd], parsed.keys, parsed.weights, parsed.aggregate)
+	if err != nil {
+		return "", err
 	}
-	response := SerializeArray(result)
 
-	return response, nil
+	return SerializeInteger(size), nil
+}
+
+func processZScore(args []string, sender net.Conn, app *Application) (string, error) {
+	score, ok, err := app.state.keyspace.SortedSetScore(args[0], args[1], touchesKeys(sender, app))
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return NIL_BULK_STRING, nil
+	}
+
+	return SerializeBulkString(formatScore(score)), nil
+}
+
+func processZScan(args []string, sender net.Conn, app *Application) (string, error) {
+	if len(args)%2 != 0 {
+		return "", ErrWrongArgs
+	}
+
+	key := args[0]
+	rawCursor := args[1]
+
+	cursor, err := strconv.ParseInt(rawCursor, 10, 64)
+	if err != nil || cursor < 0 {
+		return "", fmt.Errorf("invalid cursor '%s'", rawCursor)
+	}
+
+	pattern := ""
+	count := int64(10)
+	for i := 2; i < len(args); i += 2 {
+		option := strings.ToUpper(args[i])
+		value := args[i+1]
+
+		switch option {
+		default:
+			return "", fmt.Errorf("%w, invalid option '%s'", ErrSyntax, args[i])
+
+		case "MATCH":
+			pattern = value
+
+		case "COUNT":
+			count, err = strconv.ParseInt(value, 10, 0)
+			if err != nil || count < 1 {
+				return "", fmt.Errorf("could not parse '%s' to a positive integer", value)
+			}
+		}
+	}
+
+	next, members, err := app.state.keyspace.ScanSortedSet(key, int(cursor), pattern, int(count), touchesKeys(sender, app))
+	if err != nil {
+		return "", err
+	}
+
+	elements := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		elements = append(elements, m.member)
+		elements = append(elements, formatScore(m.score))
+	}
+
+	result := []interface{}{fmt.Sprint(next), elements}
+	return SerializeArray(result), nil
+}
+
+func processDebug(ctx context.Context, args []string, app *Application) (string, error) {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	default:
+		return "", fmt.Errorf("invalid subcommand '%s'", args[0])
+
+	case "HELP":
+		return serializeHelp(commandHelp[DEBUG]), nil
+
+	case "CHANGE-REPL-ID":
+		if len(args) != 1 {
+			return "", ErrWrongArgs
+		}
+
+		return OK_SIMPLE_STRING, nil
+
+	case "EXPIRE":
+		if len(args) != 3 {
+			return "", ErrWrongArgs
+		}
+
+		if config := app.configuration(); config == nil || !config.DebugExpire {
+			return "", errors.New("DEBUG EXPIRE is disabled")
+		}
+
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "", ErrNotInteger
+		}
+
+		if !app.state.keyspace.ForceExpiry(args[1], time.UnixMilli(ms)) {
+			return "", errors.New("no such key")
+		}
+
+		return OK_SIMPLE_STRING, nil
+
+	case "KEYSPACE":
+		if len(args) != 1 {
+			return "", ErrWrongArgs
+		}
+
+		if config := app.configuration(); config == nil || !config.DebugKeyspace {
+			return "", errors.New("DEBUG KEYSPACE is disabled")
+		}
+
+		return SerializeBulkString(app.state.keyspace.Dump()), nil
+
+	case "OBJECT":
+		if len(args) != 2 {
+			return "", ErrWrongArgs
+		}
+
+		desc, ok := app.state.keyspace.Describe(args[1], app.encodingLimits())
+		if !ok {
+			return "", errors.New("no such key")
+		}
+
+		return SerializeBulkString(desc), nil
+
+	case "RELOAD":
+		if len(args) != 1 {
+			return "", ErrWrongArgs
+		}
+
+		if err := app.Reload(); err != nil {
+			return "", fmt.Errorf("failed to reload dataset: %v", err)
+		}
+
+		return OK_SIMPLE_STRING, nil
+
+	case "SLEEP":
+		if len(args) != 2 {
+			return "", ErrWrongArgs
+		}
+
+		seconds, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("could not parse '%s' to float", args[1])
+		}
+
+		select {
+		case <-app.clock.After(time.Duration(seconds * float64(time.Second))):
+			return OK_SIMPLE_STRING, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// processInfo replies with the requested sections of the server information,
+// or with all of them when none is named. Unknown sections are left out.
+// Only the stats and keyspace sections are implemented.
+func processInfo(args []string, app *Application) (string, error) {
+	all := len(args) == 0
+	requested := make(map[string]bool, len(args))
+	for _, section := range args {
+		section = strings.ToLower(section)
+		switch section {
+		case "all", "default", "everything":
+			all = true
+		}
+		requested[section] = true
+	}
+
+	var info strings.Builder
+	if all || requested["stats"] {
+		info.WriteString(infoStats(app))
+	}
+	if all || requested["keyspace"] {
+		info.WriteString(infoKeyspace(app))
+	}
+
+	return SerializeBulkString(info.String()), nil
+}
+
+// infoStats reports the counters of the server. Only the wrong type errors
+// are tracked.
+func infoStats(app *Application) string {
+	return fmt.Sprintf("# Stats\r\nwrong_type_errors:%d\r\n\r\n", app.wrongTypeErrors.Load())
+}
+
+// infoKeyspace reports the number of keys of each database that has any, and
+// how many of them have an expiry. There is a single database and the
+// average ttl is not tracked, so it is always 0.
+func infoKeyspace(app *Application) string {
+	section := "# Keyspace\r\n"
+
+	keys, expires := app.state.keyspace.Stats()
+	if keys > 0 {
+		section += fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+	}
+
+	return section
+}
+
+// typeGroups maps the type names of redis to the groups of the keyspace.
+var typeGroups = map[string]string{
+	"string": "string",
+	"list":   "list",
+	"zset":   "sorted-set",
+}
+
+// processRandomKey replies with a random key, or nil when there are none.
+// Like the TYPE option of SCAN, 'TYPE type' only picks keys of that type.
+func processRandomKey(args []string, app *Application) (string, error) {
+	group := ""
+	switch len(args) {
+	case 0:
+	case 2:
+		if strings.ToUpper(args[0]) != "TYPE" {
+			return "", fmt.Errorf("%w, invalid option '%s'", ErrSyntax, args[0])
+		}
+
+		var ok bool
+		group, ok = typeGroups[strings.ToLower(args[1])]
+		if !ok {
+			return "", fmt.Errorf("unknown type name '%s'", args[1])
+		}
+	default:
+		return "", ErrSyntax
+	}
+
+	key, ok := app.state.keyspace.RandomKey(group)
+	if !ok {
+		return NIL_BULK_STRING, nil
+	}
+
+	return SerializeBulkString(key), nil
+}
+
+func processTime(args []string, app *Application) (string, error) {
+	now := app.clock.Now()
+	seconds := now.Unix()
+	micros := now.UnixMicro() - seconds*1_000_000
+
+	result := []interface{}{fmt.Sprint(seconds), fmt.Sprint(micros)}
+	return SerializeArray(result), nil
+}
+
+func processMemory(args []string, app *Application) (string, error) {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	default:
+		return "", fmt.Errorf("invalid subcommand '%s'", args[0])
+
+	case "HELP":
+		return serializeHelp(commandHelp[MEMORY]), nil
+
+	case "USAGE":
+		// SAMPLES is accepted for compatibility, but every element is always
+		// accounted for.
+		if len(args) != 2 && len(args) != 4 {
+			return "", ErrWrongArgs
+		}
+
+		if len(args) == 4 {
+			if strings.ToUpper(args[2]) != "SAMPLES" {
+				return "", fmt.Errorf("%w, invalid option '%s'", ErrSyntax, args[2])
+			}
+
+			if _, err := strconv.Atoi(args[3]); err != nil {
+				return "", fmt.Errorf("could not parse '%s' to integer", args[3])
+			}
+		}
+
+		usage, ok := app.state.keyspace.MemoryUsage(args[1])
+		if !ok {
+			return NIL_BULK_STRING, nil
+		}
+
+		return SerializeInteger(usage), nil
+	}
+}
+
+func processObject(args []string, app *Application) (string, error) {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	default:
+		return "", fmt.Errorf("invalid subcommand '%s'", args[0])
+
+	case "HELP":
+		return serializeHelp(commandHelp[OBJECT]), nil
+
+	case "FREQ":
+		if len(args) != 2 {
+			return "", ErrWrongArgs
+		}
+
+		freq, ok := app.state.keyspace.Frequency(args[1])
+		if !ok {
+			return NIL_BULK_STRING, nil
+		}
+
+		return SerializeInteger(freq), nil
+
+	case "ENCODING":
+		if len(args) != 2 {
+			return "", ErrWrongArgs
+		}
+
+		encoding, ok := app.state.keyspace.Encoding(args[1], app.encodingLimits())
+		if !ok {
+			return NIL_BULK_STRING, nil
+		}
+
+		return SerializeBulkString(encoding), nil
+	}
+}
+
+func processClient(args []string, sender net.Conn, app *Application) (string, error) {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	default:
+		return "", fmt.Errorf("invalid subcommand '%s'", args[0])
+
+	case "HELP":
+		return serializeHelp(commandHelp[CLIENT]), nil
+
+	case "NO-TOUCH", "NO-EVICT":
+		if len(args) != 2 {
+			return "", ErrWrongArgs
+		}
+
+		var enabled bool
+		switch strings.ToLower(args[1]) {
+		default:
+			return "", fmt.Errorf("%w, invalid option '%s'. Only 'on' or 'off' allowed.", ErrSyntax, args[1])
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		}
+
+		// there is no eviction, so NO-EVICT is accepted and ignored
+		if subcommand == "NO-EVICT" {
+			return OK_SIMPLE_STRING, nil
+		}
+
+		client, err := app.GetClient(sender)
+		if err != nil {
+			return "", err
+		}
+
+		client.noTouch = enabled
+		return OK_SIMPLE_STRING, nil
+	}
+}
+
+func processReplicaOf(args []string, app *Application) (string, error) {
+	if strings.ToUpper(args[0]) == "NO" && strings.ToUpper(args[1]) == "ONE" {
+		app.replication.ReplicateFrom("", app)
+		return OK_SIMPLE_STRING, nil
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port < 1 || port > 65535 {
+		return "", errors.New("Invalid master port")
+	}
+
+	addr := net.JoinHostPort(args[0], strconv.Itoa(port))
+	if app.replication.Master() == addr {
+		return SerializeSimpleString("OK Already connected to specified master"), nil
+	}
+
+	app.replication.ReplicateFrom(addr, app)
+	return OK_SIMPLE_STRING, nil
 }
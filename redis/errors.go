@@ -0,0 +1,71 @@
+package redis
+
+import "errors"
+
+// CommandError is a kind of command failure. Errors wrapping one are replied
+// with its Prefix, the code clients use to tell errors apart, followed by the
+// error message.
+type CommandError interface {
+	error
+	Prefix() string
+}
+
+type commandError struct {
+	prefix  string
+	message string
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+func (e *commandError) Prefix() string {
+	return e.prefix
+}
+
+var (
+	// ErrWrongType is wrapped by keyspace operations that find a key holding a
+	// value of a different group than the one the operation works on.
+	ErrWrongType CommandError = &commandError{prefix: "WRONGTYPE", message: "does not support this operation"}
+
+	// ErrWrongArgs is returned when the number of arguments doesn't match the
+	// command.
+	ErrWrongArgs CommandError = &commandError{prefix: "ERR", message: "wrong number of arguments"}
+
+	// ErrSyntax is wrapped by the errors of options that are not valid for the
+	// command.
+	ErrSyntax CommandError = &commandError{prefix: "ERR", message: "syntax error"}
+
+	// ErrNotInteger is returned by integer operations when the stored value is
+	// not an integer.
+	ErrNotInteger CommandError = &commandError{prefix: "ERR", message: "value is not an integer or out of range"}
+
+	// ErrNotFloat is returned when a score is not a float, or is NaN.
+	ErrNotFloat CommandError = &commandError{prefix: "ERR", message: "value is not a valid float"}
+
+	// ErrStringTooLong is returned by writes that would make a string longer
+	// than proto-max-bulk-len.
+	ErrStringTooLong CommandError = &commandError{prefix: "ERR", message: "string exceeds maximum allowed size"}
+
+	// ErrReadOnly is returned by the commands that write when the server runs
+	// in read-only mode.
+	ErrReadOnly CommandError = &commandError{prefix: "READONLY", message: "You can't write against a read only replica"}
+
+	// ErrBusyKey is returned by MIGRATE when the target already has the key.
+	ErrBusyKey CommandError = &commandError{prefix: "BUSYKEY", message: "Target key name already exists."}
+
+	// ErrMigrateIO is wrapped by the errors of MIGRATE talking to the target.
+	ErrMigrateIO CommandError = &commandError{prefix: "IOERR", message: "error or timeout"}
+)
+
+// SerializeError serializes err as a RESP error. Errors wrapping a
+// CommandError start with its prefix, and any other error with ERR.
+func SerializeError(err error) string {
+	prefix := "ERR"
+	var ce CommandError
+	if errors.As(err, &ce) {
+		prefix = ce.Prefix()
+	}
+
+	return SerializeSimpleError(prefix + " " + err.Error())
+}
@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSerializeError(t *testing.T) {
+	testCases := []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{
+			desc: "wrong type",
+			err:  wrongTypeError("Name"),
+			want: "-WRONGTYPE key 'Name' does not support this operation\r\n",
+		},
+		{
+			desc: "wrong number of arguments",
+			err:  fmt.Errorf("%w for 'get' command", ErrWrongArgs),
+			want: "-ERR wrong number of arguments for 'get' command\r\n",
+		},
+		{
+			desc: "syntax error",
+			err:  fmt.Errorf("%w, invalid option 'score'", ErrSyntax),
+			want: "-ERR syntax error, invalid option 'score'\r\n",
+		},
+		{
+			desc: "not an integer",
+			err:  ErrNotInteger,
+			want: "-ERR value is not an integer or out of range\r\n",
+		},
+		{
+			desc: "protocol error",
+			err:  fmt.Errorf("%w: missing bulk length", ErrProtocol),
+			want: "-ERR Protocol error: missing bulk length\r\n",
+		},
+		{
+			desc: "untyped error",
+			err:  errors.New("no such key"),
+			want: "-ERR no such key\r\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := SerializeError(tC.err); got != tC.want {
+				t.Errorf("got: %q. want: %q", got, tC.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package redis
+
+import (
+	containerlist "container/list"
+	"sync"
+)
+
+// defaultGlobCacheSize is how many compiled patterns compileGlob keeps.
+const defaultGlobCacheSize = 128
+
+type globTokenKind int
+
+const (
+	globLiteral globTokenKind = iota
+	globAnyChar
+	globAnyString
+	globCharClass
+)
+
+// globToken is one element of a compiled pattern: a literal byte, '?', '*' or
+// a character class.
+type globToken struct {
+	kind  globTokenKind
+	c     byte
+	class *globClass
+}
+
+// globClass is a compiled character class. Single characters are kept as
+// ranges of one.
+type globClass struct {
+	negate bool
+	ranges [][2]byte
+}
+
+func (gc *globClass) matches(c byte) bool {
+	matched := false
+	for _, r := range gc.ranges {
+		if c >= r[0] && c <= r[1] {
+			matched = true
+			break
+		}
+	}
+
+	return matched != gc.negate
+}
+
+// globMatcher is a redis style glob pattern parsed once, so it can be matched
+// against many strings.
+type globMatcher struct {
+	tokens []globToken
+}
+
+// parseGlob compiles a redis style glob pattern. It supports '*', '?',
+// character classes like '[abc]', '[^abc]' and '[a-z]', and '\' to escape
+// special characters.
+func parseGlob(pattern string) *globMatcher {
+	tokens := make([]globToken, 0, len(pattern))
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// consecutive stars match the same as one
+			if n := len(tokens); n == 0 || tokens[n-1].kind != globAnyString {
+				tokens = append(tokens, globToken{kind: globAnyString})
+			}
+			pattern = pattern[1:]
+
+		case '?':
+			tokens = append(tokens, globToken{kind: globAnyChar})
+			pattern = pattern[1:]
+
+		case '[':
+			var class *globClass
+			class, pattern = parseGlobClass(pattern[1:])
+			tokens = append(tokens, globToken{kind: globCharClass, class: class})
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			tokens = append(tokens, globToken{kind: globLiteral, c: pattern[0]})
+			pattern = pattern[1:]
+		}
+	}
+
+	return &globMatcher{tokens: tokens}
+}
+
+// parseGlobClass compiles the character class at the start of pattern (right
+// after the opening bracket) and returns the pattern remaining after the
+// closing bracket.
+func parseGlobClass(pattern string) (*globClass, string) {
+	class := &globClass{}
+	if len(pattern) > 0 && pattern[0] == '^' {
+		class.negate = true
+		pattern = pattern[1:]
+	}
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			class.ranges = append(class.ranges, [2]byte{pattern[1], pattern[1]})
+			pattern = pattern[2:]
+
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			class.ranges = append(class.ranges, [2]byte{lo, hi})
+			pattern = pattern[3:]
+
+		default:
+			class.ranges = append(class.ranges, [2]byte{pattern[0], pattern[0]})
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		// skip the closing bracket
+		pattern = pattern[1:]
+	}
+
+	return class, pattern
+}
+
+// Match reports whether s matches the pattern.
+func (m *globMatcher) Match(s string) bool {
+	return matchGlobTokens(m.tokens, s)
+}
+
+func matchGlobTokens(tokens []globToken, s string) bool {
+	for i, t := range tokens {
+		if t.kind == globAnyString {
+			if i == len(tokens)-1 {
+				return true
+			}
+
+			for j := 0; j <= len(s); j++ {
+				if matchGlobTokens(tokens[i+1:], s[j:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(s) == 0 {
+			return false
+		}
+
+		switch t.kind {
+		case globLiteral:
+			if s[0] != t.c {
+				return false
+			}
+		case globCharClass:
+			if !t.class.matches(s[0]) {
+				return false
+			}
+		}
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// globCache is a bounded LRU cache of compiled patterns, safe for concurrent
+// use.
+type globCache struct {
+	mutex   sync.Mutex
+	size    int
+	order   *containerlist.List // of *globCacheEntry, most recently used first
+	entries map[string]*containerlist.Element
+}
+
+type globCacheEntry struct {
+	pattern string
+	matcher *globMatcher
+}
+
+func newGlobCache(size int) *globCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &globCache{
+		size:    size,
+		order:   containerlist.New(),
+		entries: make(map[string]*containerlist.Element, size),
+	}
+}
+
+// get returns the matcher of pattern, compiling it when it is not cached. The
+// least recently used pattern is dropped once the cache is full.
+func (gc *globCache) get(pattern string) *globMatcher {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	if e, ok := gc.entries[pattern]; ok {
+		gc.order.MoveToFront(e)
+		return e.Value.(*globCacheEntry).matcher
+	}
+
+	m := parseGlob(pattern)
+	gc.entries[pattern] = gc.order.PushFront(&globCacheEntry{pattern: pattern, matcher: m})
+	if gc.order.Len() > gc.size {
+		oldest := gc.order.Back()
+		gc.order.Remove(oldest)
+		delete(gc.entries, oldest.Value.(*globCacheEntry).pattern)
+	}
+
+	return m
+}
+
+var globs = newGlobCache(defaultGlobCacheSize)
+
+// compileGlob returns the compiled pattern, reusing it when the pattern was
+// compiled recently.
+func compileGlob(pattern string) *globMatcher {
+	return globs.get(pattern)
+}
+
+// matchGlob reports whether s matches the redis style glob pattern. See
+// parseGlob for the supported syntax.
+func matchGlob(pattern string, s string) bool {
+	return compileGlob(pattern).Match(s)
+}
@@ -0,0 +1,89 @@
+package redis
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"user:*:name", "user:1/2:name", true},
+		{"", "", true},
+		{"", "a", false},
+		{"a**b", "axyzb", true},
+		{"h[z-a]llo", "hmllo", true},
+		{"h[\\]]llo", "h]llo", true},
+		{"h[abc", "hb", true},
+		{"h[abc", "hbx", false},
+		{"end\\", "end\\", true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.pattern+" "+tC.s, func(t *testing.T) {
+			got := matchGlob(tC.pattern, tC.s)
+			if got != tC.want {
+				t.Errorf("got: %v. want: %v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestGlobCache(t *testing.T) {
+	cache := newGlobCache(2)
+
+	a := cache.get("a*")
+	cache.get("b*")
+	if cache.get("a*") != a {
+		t.Error("expected cached pattern to be reused")
+	}
+
+	// b* is now the least recently used pattern
+	cache.get("c*")
+	if _, ok := cache.entries["b*"]; ok {
+		t.Error("expected least recently used pattern to be dropped")
+	}
+	if cache.get("a*") != a {
+		t.Error("expected recently used pattern to be kept")
+	}
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Errorf("expected cache to hold 2 patterns. got: %d", cache.order.Len())
+	}
+
+	if !cache.get("c*").Match("cat") || cache.get("c*").Match("dog") {
+		t.Error("expected cached matcher to match like the pattern")
+	}
+}
+
+func BenchmarkGlob(b *testing.B) {
+	pattern := "user:[0-9]*:na?e"
+	s := "user:1234:name"
+
+	b.Run("compile every call", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parseGlob(pattern).Match(s)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			compileGlob(pattern).Match(s)
+		}
+	})
+}
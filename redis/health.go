@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const healthCheckTimeout = time.Second
+
+// NewHealthHandler serves /health, which replies 200 when the RESP server at
+// addr accepts a connection and answers PING, and /metrics, which exposes the
+// counters written by WriteMetrics.
+func NewHealthHandler(addr string, app *Application) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if err := ping(addr); err != nil {
+			app.logger.Error(fmt.Sprintf("health check failed: %v", err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "OK")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := app.WriteMetrics(w); err != nil {
+			app.logger.Error(fmt.Sprintf("failed to write metrics: %v", err))
+		}
+	})
+	return mux
+}
+
+func ping(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, healthCheckTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(healthCheckTimeout))
+	if err := WriteBulkStringArray(conn, []string{"PING"}); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if reply != "+PONG\r\n" {
+		return fmt.Errorf("unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
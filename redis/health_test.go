@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+func TestHealthHandler(t *testing.T) {
+	app := NewApplication(nil, TestClockTimer{mockNow: time.Now()}, NewTestLogger())
+	app.state.keyspace.SetStringKey("Name", "John", nil)
+	app.state.keyspace.PushToTail("Names", []string{"John"})
+
+	srv, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatalf("failed to setup listener: %v", err)
+	}
+	go func() { Listen(srv, app, app.logger) }()
+
+	handler := NewHealthHandler(srv.Addr().String(), app)
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		body, _ := io.ReadAll(rec.Result().Body)
+		return rec.Code, string(body)
+	}
+
+	t.Run("health while accepting connections", func(t *testing.T) {
+		code, _ := get("/health")
+		if code != http.StatusOK {
+			t.Errorf("got status: %d. want: %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		code, body := get("/metrics")
+		if code != http.StatusOK {
+			t.Fatalf("got status: %d. want: %d", code, http.StatusOK)
+		}
+
+		for _, want := range []string{
+			"redis_commands_processed_total{command=\"ping\"} 1\n",
+			"redis_keys 2\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected metrics to contain %q. got: %s", want, body)
+			}
+		}
+	})
+
+	t.Run("health once the server is closed", func(t *testing.T) {
+		srv.Close()
+
+		code, _ := get("/health")
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("got status: %d. want: %d", code, http.StatusServiceUnavailable)
+		}
+	})
+}
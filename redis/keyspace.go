@@ -1,10 +1,17 @@
 package redis
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unsafe"
 )
 
 type keyspaceEntry struct {
@@ -12,14 +19,52 @@ type keyspaceEntry struct {
 	expires *time.Time
 }
 
+// Equal reports whether both entries hold the same type and expire at the same
+// time. Expiry is compared with millisecond precision, the precision snapshots
+// keep it with.
+func (e keyspaceEntry) Equal(o keyspaceEntry) bool {
+	if e.group != o.group {
+		return false
+	}
+
+	if e.expires == nil || o.expires == nil {
+		return e.expires == nil && o.expires == nil
+	}
+
+	return e.expires.UnixMilli() == o.expires.UnixMilli()
+}
+
 type keyspace struct {
-	clock         ClockTimer
-	mutex         *sync.RWMutex
-	keys          map[string]keyspaceEntry
-	stringMap     map[string]string
-	listMap       map[string]list
-	sortedSetMap  map[string]rbtree[float64, string]
+	clock        ClockTimer
+	mutex        *sync.RWMutex
+	keys         map[string]keyspaceEntry
+	stringMap    map[string]stringValue
+	listMap      map[string]list
+	sortedSetMap map[string]*sortedSet
+
+	// modifications counts the changes since the last snapshot, to decide
+	// when to save the next one. Like the dirty counter of redis, a write adds
+	// the number of keys or elements it changed, and writes that change
+	// nothing add none.
 	modifications int
+
+	// volatile holds the keys that have an expiry, so the active expirer
+	// samples them without walking the keys that never expire. Entries are
+	// kept in sync by setEntry and deleteEntry.
+	volatile map[string]struct{}
+
+	// frequencies maps keys to their *lfuCounter. It lives outside of keys so
+	// reads can count accesses while holding only the read lock.
+	frequencies *sync.Map
+
+	// notify, when set, is called with the event name and key after every
+	// change to the keyspace. It runs while the keyspace lock is held.
+	notify func(event string, key string)
+
+	// wrongType, when set, is called with the key every time requireGroup
+	// finds it holding a value of another group. It may run while only the
+	// read lock is held.
+	wrongType func(key string)
 }
 
 type KeyResult struct {
@@ -38,70 +83,252 @@ func (kr KeyResult) IsString() bool {
 	return kr.IsValid() && hasString
 }
 
+// ErrOverflow is returned by integer operations whose result does not fit in
+// 64 bits.
+var ErrOverflow = errors.New("increment or decrement would overflow")
+
+// wrongTypeKeyError is the error of operations on key, which holds a value of
+// another group. It wraps ErrWrongType.
+type wrongTypeKeyError struct {
+	key string
+}
+
+func (e *wrongTypeKeyError) Error() string {
+	return fmt.Sprintf("key '%s' %s", e.key, ErrWrongType)
+}
+
+func (e *wrongTypeKeyError) Unwrap() error {
+	return ErrWrongType
+}
+
+func wrongTypeError(key string) error {
+	return &wrongTypeKeyError{key: key}
+}
+
+// requireGroup returns the entry of key when it holds a value of group, and an
+// error wrapping ErrWrongType when it holds a value of another group. Keys that
+// don't exist or have expired are returned as a zero entry, without error. The
+// caller must hold the lock.
+func (ks *keyspace) requireGroup(key string, group string) (keyspaceEntry, error) {
+	ke, ok := ks.keys[key]
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return keyspaceEntry{}, nil
+	}
+
+	if ke.group != group {
+		if ks.wrongType != nil {
+			ks.wrongType(key)
+		}
+		return keyspaceEntry{}, wrongTypeError(key)
+	}
+
+	return ke, nil
+}
+
+// expireIfNeeded deletes key if it has expired, so writes start over from an
+// empty value. The caller must hold the write lock.
+func (ks *keyspace) expireIfNeeded(key string) {
+	ke, ok := ks.keys[key]
+	if !ok || !CheckIsExpired(ks.clock, ke) {
+		return
+	}
+
+	ks.removeKey(key, ke.group, "expired")
+}
+
+func (ks *keyspace) notifyEvent(event string, key string) {
+	if ks.notify != nil {
+		ks.notify(event, key)
+	}
+}
+
 func newKeyspace(clock ClockTimer, m *sync.RWMutex) *keyspace {
 	return &keyspace{
 		mutex:         m,
 		clock:         clock,
 		keys:          make(map[string]keyspaceEntry),
-		stringMap:     make(map[string]string),
+		stringMap:     make(map[string]stringValue),
 		listMap:       make(map[string]list),
-		sortedSetMap:  make(map[string]rbtree[float64, string]),
+		sortedSetMap:  make(map[string]*sortedSet),
 		modifications: 0,
+		volatile:      make(map[string]struct{}),
+		frequencies:   &sync.Map{},
 	}
 }
 
-func (ks *keyspace) Get(key string) KeyResult {
+// replaceData swaps the data of the keyspace for the data of other. Access
+// frequencies start over, since other does not share them. The caller must
+// hold the write lock.
+func (ks *keyspace) replaceData(other *keyspace) {
+	ks.keys = other.keys
+	ks.stringMap = other.stringMap
+	ks.listMap = other.listMap
+	ks.sortedSetMap = other.sortedSetMap
+	ks.volatile = other.volatile
+	ks.frequencies = &sync.Map{}
+}
+
+// setEntry stores the entry of key. The caller must hold the write lock.
+func (ks *keyspace) setEntry(key string, ke keyspaceEntry) {
+	ks.keys[key] = ke
+	if ke.expires != nil {
+		ks.volatile[key] = struct{}{}
+	} else {
+		delete(ks.volatile, key)
+	}
+}
+
+// deleteEntry removes the entry of key, leaving its value to the caller. The
+// caller must hold the write lock.
+func (ks *keyspace) deleteEntry(key string) {
+	delete(ks.keys, key)
+	delete(ks.volatile, key)
+}
+
+// deleteValue removes the value of key from the map of its group. The caller
+// must hold the write lock.
+func (ks *keyspace) deleteValue(key string, group string) {
+	switch group {
+	case "string":
+		delete(ks.stringMap, key)
+	case "list":
+		delete(ks.listMap, key)
+	case "sorted-set":
+		delete(ks.sortedSetMap, key)
+	}
+}
+
+// removeKey deletes key, its value and its access frequency, and publishes
+// event for it. The caller must hold the write lock.
+func (ks *keyspace) removeKey(key string, group string, event string) {
+	ks.deleteValue(key, group)
+	ks.deleteEntry(key)
+	ks.modifications += 1
+	ks.frequencies.Delete(key)
+	ks.notifyEvent(event, key)
+}
+
+// touch counts an access to key.
+func (ks *keyspace) touch(key string) {
+	c, ok := ks.frequencies.Load(key)
+	if !ok {
+		c, _ = ks.frequencies.LoadOrStore(key, newLFUCounter())
+	}
+	c.(*lfuCounter).Increment()
+}
+
+// Frequency returns the logarithmic access frequency of key, without counting
+// it as an access.
+func (ks *keyspace) Frequency(key string) (int, bool) {
 	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
 	ke, ok := ks.keys[key]
-	ks.mutex.RUnlock()
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return 0, false
+	}
 
+	c, ok := ks.frequencies.Load(key)
 	if !ok {
-		return KeyResult{}
+		return lfuInitValue, true
 	}
+	return c.(*lfuCounter).Value(), true
+}
 
-	if ke.expires != nil && ks.clock.Now().After(*ke.expires) {
-		ks.mutex.Lock()
-		switch ke.group {
-		case "string":
-			delete(ks.stringMap, key)
+func (ks *keyspace) Get(key string) KeyResult {
+	return ks.get(key, true)
+}
 
-		case "list":
-			delete(ks.listMap, key)
-		}
+// get returns the value of key. With touch set, the read counts as an access
+// to the key.
+func (ks *keyspace) get(key string, touch bool) KeyResult {
+	ks.mutex.RLock()
+	ke, ok := ks.keys[key]
+	if ok && !CheckIsExpired(ks.clock, ke) {
+		kr := ks.readValue(key, ke.group, touch)
+		ks.mutex.RUnlock()
+		return kr
+	}
+	ks.mutex.RUnlock()
 
-		delete(ks.keys, key)
-		ks.modifications += 1
-		ks.mutex.Unlock()
+	if ok {
+		ks.deleteIfExpired(key)
+	}
+	return KeyResult{}
+}
 
-		return KeyResult{}
+// GetTyped works like Get, but returns an error wrapping ErrWrongType when
+// the key exists and is not of the expectedGroup. Callers decide how to render
+// it: GET replies nil while INCR replies with the error. With touch unset, the
+// read does not count as an access to the key.
+func (ks *keyspace) GetTyped(key string, expectedGroup string, touch bool) (KeyResult, error) {
+	// the type is checked and the value read under the same lock, so the key
+	// can't change type in between
+	ks.mutex.RLock()
+	ke, err := ks.requireGroup(key, expectedGroup)
+	if err != nil {
+		ks.mutex.RUnlock()
+		return KeyResult{}, err
+	}
+
+	if ke.group == "" {
+		// the key doesn't exist, or is still there but has expired
+		_, expired := ks.keys[key]
+		ks.mutex.RUnlock()
+		if expired {
+			ks.deleteIfExpired(key)
+		}
+		return KeyResult{}, nil
 	}
 
+	kr := ks.readValue(key, ke.group, touch)
+	ks.mutex.RUnlock()
+	return kr, nil
+}
+
+// readValue returns the value of key, which holds a value of group. The caller
+// must hold the lock.
+func (ks *keyspace) readValue(key string, group string, touch bool) KeyResult {
 	var kr KeyResult
-	ks.mutex.RLock()
-	switch ke.group {
-	default:
-		kr = KeyResult{}
+	switch group {
 	case "string":
-		v := ks.stringMap[key]
+		v := ks.stringMap[key].String()
 		kr = KeyResult{str: &v}
 	case "list":
 		v := ks.listMap[key]
 		kr = KeyResult{arr: v.ToSlice()}
 	}
-	ks.mutex.RUnlock()
-
+	if touch {
+		ks.touch(key)
+	}
 	return kr
 }
 
+// deleteIfExpired deletes key if it has expired, taking the write lock.
+func (ks *keyspace) deleteIfExpired(key string) {
+	ks.mutex.Lock()
+	ks.expireIfNeeded(key)
+	ks.mutex.Unlock()
+}
+
+// Expire adds duration seconds to the expiry of key, or sets it to expire in
+// duration seconds when it has none. Like in redis, a non positive duration
+// deletes the key right away. It reports whether the key existed.
 func (ks *keyspace) Expire(key string, duration int64) bool {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
+	ks.expireIfNeeded(key)
 	ke, ok := ks.keys[key]
 	if !ok {
 		return false
 	}
 
+	if duration <= 0 {
+		ks.removeKey(key, ke.group, "del")
+		return true
+	}
+
 	var final time.Time
 	if ke.expires == nil {
 		final = ks.clock.Now().Add(time.Duration(duration) * time.Second)
@@ -111,25 +338,74 @@ func (ks *keyspace) Expire(key string, duration int64) bool {
 	}
 
 	ke.expires = &final
-	ks.keys[key] = ke
+	ks.setEntry(key, ke)
 	ks.modifications += 1
+	ks.notifyEvent("expire", key)
 
 	return true
 }
 
+// ExpireAt sets key to expire at deadline. A deadline that is not in the
+// future deletes the key right away. It reports whether the key existed.
 func (ks *keyspace) ExpireAt(key string, deadline time.Time) bool {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
+	ks.expireIfNeeded(key)
 	ke, ok := ks.keys[key]
 	if !ok {
 		return false
 	}
 
+	if !deadline.After(ks.clock.Now()) {
+		ks.removeKey(key, ke.group, "del")
+		return true
+	}
+
 	ke.expires = &deadline
-	ks.keys[key] = ke
+	ks.setEntry(key, ke)
 	ks.modifications += 1
+	ks.notifyEvent("expire", key)
+
+	return true
+}
+
+// Persist removes the expiry of key. It reports whether the key existed and
+// had an expiry to remove.
+func (ks *keyspace) Persist(key string) bool {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
 
+	ke, ok := ks.keys[key]
+	if !ok || ke.expires == nil || CheckIsExpired(ks.clock, ke) {
+		return false
+	}
+
+	ke.expires = nil
+	ks.setEntry(key, ke)
+	ks.modifications += 1
+	ks.notifyEvent("persist", key)
+
+	return true
+}
+
+// ForceExpiry sets the expiry of key to deadline, even when it already
+// passed, in which case the key is left for the lazy or active expiry to
+// delete. It reports false when the key is missing.
+func (ks *keyspace) ForceExpiry(key string, deadline time.Time) bool {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.expireIfNeeded(key)
+	ke, ok := ks.keys[key]
+	if !ok {
+		return false
+	}
+
+	ke.expires = &deadline
+	ks.setEntry(key, ke)
+	ks.modifications += 1
+	ks.notifyEvent("expire", key)
 	return true
 }
 
@@ -137,46 +413,49 @@ func (ks *keyspace) Exists(key string) bool {
 	return ks.Get(key).IsValid()
 }
 
+// BulkExists counts, for each distinct key, how many times it was given and
+// exists. Keys that do not exist are reported with a count of 0.
 func (ks *keyspace) BulkExists(keys []string) map[string]int {
 	ks.mutex.RLock()
 	defer ks.mutex.RUnlock()
 
-	keyCount := map[string]int{}
+	keyCount := make(map[string]int, len(keys))
 	for _, key := range keys {
-		_, ok := ks.keys[key]
-		_, kcOk := keyCount[key]
-		if ok {
-			if kcOk {
-				keyCount[key] += 1
-			} else {
-				keyCount[key] = 1
-			}
-		} else {
-			keyCount[key] = 0
+		count := keyCount[key]
+		if _, ok := ks.keys[key]; ok {
+			count += 1
 		}
+		keyCount[key] = count
 	}
 	return keyCount
 }
 
 func (ks *keyspace) BulkDelete(keys []string) map[string]int {
+	return ks.bulkDelete(keys, "del", false)
+}
+
+// BulkDeleteExpired works like BulkDelete, but notifies the deletions as
+// expirations. The keys are usually collected before the lock is taken, so
+// keys that are no longer expired by then, because they were set again in the
+// meantime, are kept.
+func (ks *keyspace) BulkDeleteExpired(keys []string) map[string]int {
+	return ks.bulkDelete(keys, "expired", true)
+}
+
+func (ks *keyspace) bulkDelete(keys []string, event string, onlyExpired bool) map[string]int {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
 	keyCount := map[string]int{}
 	for _, key := range keys {
 		ke, ok := ks.keys[key]
+		if ok && onlyExpired && !CheckIsExpired(ks.clock, ke) {
+			ok = false
+		}
+
 		_, kcOk := keyCount[key]
 		if ok {
-
-			switch ke.group {
-			case "string":
-				delete(ks.stringMap, key)
-			case "list":
-				delete(ks.listMap, key)
-			}
-
-			delete(ks.keys, key)
-			ks.modifications += 1
+			ks.removeKey(key, ke.group, event)
 
 			if kcOk {
 				keyCount[key] += 1
@@ -201,11 +480,28 @@ func (ks *keyspace) SetStringKey(key string, value string, exp *ExpiryDuration)
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
+	ks.setString(key, value, exp)
+}
+
+// SetStringKeys sets every key/value pair in pairs, all with the same expiry,
+// under a single lock, so no other command sees only some of them set.
+func (ks *keyspace) SetStringKeys(pairs []string, exp *ExpiryDuration) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ks.setString(pairs[i], pairs[i+1], exp)
+	}
+}
+
+// setString stores value at key, replacing the value of any group. The
+// caller must hold the write lock.
+func (ks *keyspace) setString(key string, value string, exp *ExpiryDuration) {
 	ke, ok := ks.keys[key]
-	if ok && ke.group == "list" {
-		delete(ks.listMap, key)
+	if ok && ke.group != "string" {
+		ks.deleteValue(key, ke.group)
 	}
-	ks.stringMap[key] = value
+	ks.stringMap[key] = newStringValue([]byte(value))
 	newKey := keyspaceEntry{group: "string", expires: nil}
 
 	if exp != nil {
@@ -213,8 +509,10 @@ func (ks *keyspace) SetStringKey(key string, value string, exp *ExpiryDuration)
 		newKey.expires = &final
 	}
 
-	ks.keys[key] = newKey
+	ks.setEntry(key, newKey)
 	ks.modifications += 1
+	ks.touch(key)
+	ks.notifyEvent("set", key)
 }
 
 func (ks *keyspace) SetListKey(key string, value []string, exp *ExpiryDuration) {
@@ -222,19 +520,21 @@ func (ks *keyspace) SetListKey(key string, value []string, exp *ExpiryDuration)
 	defer ks.mutex.Unlock()
 
 	ke, ok := ks.keys[key]
-	if ok && ke.group == "string" {
-		delete(ks.stringMap, key)
+	if ok && ke.group != "list" {
+		ks.deleteValue(key, ke.group)
 	}
 	ks.listMap[key] = NewListFromSlice(value)
-	newKey := keyspaceEntry{group: "string", expires: nil}
+	newKey := keyspaceEntry{group: "list", expires: nil}
 
 	if exp != nil {
 		final := ks.clock.Now().Add(time.Duration(exp.magnitude) * exp.resolution)
 		newKey.expires = &final
 	}
 
-	ks.keys[key] = newKey
+	ks.setEntry(key, newKey)
 	ks.modifications += 1
+	ks.touch(key)
+	ks.notifyEvent("set", key)
 }
 
 func (ks *keyspace) SetKey(key string, value interface{}, exp *ExpiryDuration) {
@@ -250,15 +550,20 @@ func (ks *keyspace) IncrementBy(key string, value int) (int, error) {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
-	ke, ok := ks.keys[key]
-	if !ok {
-		ks.keys[key] = keyspaceEntry{group: "string", expires: nil}
-		ks.stringMap[key] = "0"
-		return 0, nil
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "string")
+	if err != nil {
+		return 0, err
 	}
 
-	if ke.group != "string" {
-		return 0, fmt.Errorf("key '%s' does not support this operation", key)
+	if ke.group == "" {
+		// a missing key counts as 0
+		ks.setEntry(key, keyspaceEntry{group: "string", expires: nil})
+		ks.stringMap[key] = intStringValue(int64(value))
+		ks.modifications += 1
+		ks.touch(key)
+		ks.notifyEvent("incrby", key)
+		return value, nil
 	}
 
 	strVal, ok := ks.stringMap[key]
@@ -269,31 +574,106 @@ func (ks *keyspace) IncrementBy(key string, value int) (int, error) {
 		return 0, fmt.Errorf("key '%s' not found", key)
 	}
 
-	intVal, err := strconv.ParseInt(strVal, 10, 0)
-	if err != nil {
-		return 0, fmt.Errorf("key '%s' cannot be parsed to integer", key)
+	intVal, ok := strVal.Int()
+	if !ok {
+		return 0, ErrNotInteger
+	}
+
+	if (value > 0 && intVal > math.MaxInt64-int64(value)) || (value < 0 && intVal < math.MinInt64-int64(value)) {
+		return 0, ErrOverflow
 	}
 
 	newVal := int(intVal) + value
-	ks.stringMap[key] = fmt.Sprintf("%d", newVal)
+	ks.stringMap[key] = intStringValue(int64(newVal))
 
 	ks.modifications += 1
+	ks.touch(key)
+	ks.notifyEvent("incrby", key)
 	return newVal, nil
 }
 
+// Append adds value to the end of the string at key, creating it when
+// missing. It fails with ErrStringTooLong, leaving the string as it was, when
+// the result would be longer than maxLen.
+func (ks *keyspace) Append(key string, value string, maxLen int64) (int, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "string")
+	if err != nil {
+		return 0, err
+	}
+
+	if int64(ks.stringMap[key].Len())+int64(len(value)) > maxLen {
+		return 0, ErrStringTooLong
+	}
+
+	if ke.group == "" {
+		ks.stringMap[key] = stringValue{raw: []byte(value)}
+		ks.setEntry(key, keyspaceEntry{group: "string", expires: nil})
+		ks.modifications += 1
+		ks.touch(key)
+		ks.notifyEvent("append", key)
+		return len(value), nil
+	}
+
+	// like in redis, appending drops the int form, since the value is
+	// unlikely to be a number anymore
+	strVal := append(ks.stringMap[key].Bytes(), value...)
+	ks.stringMap[key] = stringValue{raw: strVal}
+	ks.modifications += 1
+	ks.touch(key)
+	ks.notifyEvent("append", key)
+	return len(strVal), nil
+}
+
+// CompareAndSet sets key to value only if it currently holds expected, and
+// reports whether it did. A missing key is set only when expected is empty.
+// The expiry of the key is kept, since the key is only updated.
+func (ks *keyspace) CompareAndSet(key string, expected string, value string) (bool, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "string")
+	if err != nil {
+		return false, err
+	}
+
+	if ke.group == "" {
+		if expected != "" {
+			return false, nil
+		}
+		ks.setEntry(key, keyspaceEntry{group: "string", expires: nil})
+	} else if ks.stringMap[key].String() != expected {
+		return false, nil
+	}
+
+	ks.stringMap[key] = newStringValue([]byte(value))
+	ks.modifications += 1
+	ks.touch(key)
+	ks.notifyEvent("set", key)
+	return true, nil
+}
+
 func (ks *keyspace) PushToTail(key string, values []string) (int, error) {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
-	ke, ok := ks.keys[key]
-	if !ok {
-		ks.listMap[key] = NewListFromSlice(values)
-		ks.keys[key] = keyspaceEntry{group: "list", expires: nil}
-		return len(values), nil
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "list")
+	if err != nil {
+		return 0, err
 	}
 
-	if ke.group != "list" {
-		return 0, fmt.Errorf("key '%s' does not support this operation", key)
+	if ke.group == "" {
+		ks.listMap[key] = NewListFromSlice(values)
+		ks.setEntry(key, keyspaceEntry{group: "list", expires: nil})
+		ks.modifications += len(values)
+		ks.touch(key)
+		ks.notifyEvent("rpush", key)
+		return len(values), nil
 	}
 
 	listVal, ok := ks.listMap[key]
@@ -307,7 +687,9 @@ func (ks *keyspace) PushToTail(key string, values []string) (int, error) {
 	listVal.AppendSliceToTail(values)
 
 	ks.listMap[key] = listVal
-	ks.modifications += 1
+	ks.modifications += len(values)
+	ks.touch(key)
+	ks.notifyEvent("rpush", key)
 	return listVal.size, nil
 }
 
@@ -315,15 +697,19 @@ func (ks *keyspace) PushToHead(key string, values []string) (int, error) {
 	ks.mutex.Lock()
 	defer ks.mutex.Unlock()
 
-	ke, ok := ks.keys[key]
-	if !ok {
-		ks.listMap[key] = NewListFromSlice(values)
-		ks.keys[key] = keyspaceEntry{group: "list", expires: nil}
-		return len(values), nil
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "list")
+	if err != nil {
+		return 0, err
 	}
 
-	if ke.group != "list" {
-		return 0, fmt.Errorf("key '%s' does not support this operation", key)
+	if ke.group == "" {
+		ks.listMap[key] = NewListFromSlice(values)
+		ks.setEntry(key, keyspaceEntry{group: "list", expires: nil})
+		ks.modifications += len(values)
+		ks.touch(key)
+		ks.notifyEvent("lpush", key)
+		return len(values), nil
 	}
 
 	listVal, ok := ks.listMap[key]
@@ -337,32 +723,86 @@ func (ks *keyspace) PushToHead(key string, values []string) (int, error) {
 	listVal.AppendSliceToHead(values)
 
 	ks.listMap[key] = listVal
-	ks.modifications += 1
+	ks.modifications += len(values)
+	ks.touch(key)
+	ks.notifyEvent("lpush", key)
 	return listVal.size, nil
 }
 
-func (ks *keyspace) PutInSortedSet(key string, values []string) (int, error) {
-	ks.mutex.Lock()
-	defer ks.mutex.Unlock()
+// zaddCondition restricts the members ZADD adds or updates, depending on
+// whether they are already in the sorted set.
+type zaddCondition int
 
-	ke, ok := ks.keys[key]
-	if !ok {
-		tree := NewTree[float64, string]()
-		ks.sortedSetMap[key] = *tree
-		ke = keyspaceEntry{group: "sorted-set", expires: nil}
-		ks.keys[key] = ke
+const (
+	zaddAlways zaddCondition = iota
+
+	// zaddOnlyNew is NX: existing members are left as they are.
+	zaddOnlyNew
+
+	// zaddOnlyExisting is XX: members are never added.
+	zaddOnlyExisting
+)
+
+// allows reports whether the condition lets a member that exists, or not,
+// be written.
+func (c zaddCondition) allows(exists bool) bool {
+	switch c {
+	case zaddOnlyNew:
+		return !exists
+	case zaddOnlyExisting:
+		return exists
+	}
+	return true
+}
+
+// sortedSetForWrite returns the sorted set of key, or an empty one when the
+// key is missing, in which case the caller stores it with storeSortedSet. The
+// caller must hold the write lock.
+func (ks *keyspace) sortedSetForWrite(key string) (*sortedSet, error) {
+	ks.expireIfNeeded(key)
+	ke, err := ks.requireGroup(key, "sorted-set")
+	if err != nil {
+		return nil, err
 	}
 
-	if ke.group != "sorted-set" {
-		return 0, fmt.Errorf("key '%s' does not support this operation", key)
+	if ke.group == "" {
+		return newSortedSet(), nil
 	}
 
 	setVal, ok := ks.sortedSetMap[key]
 	if !ok {
-		return 0, fmt.Errorf("key '%s' not found", key)
+		return nil, fmt.Errorf("key '%s' not found", key)
+	}
+	return setVal, nil
+}
+
+// storeSortedSet stores the sorted set of key after it was changed, creating
+// the key if it is missing. The caller must hold the write lock.
+func (ks *keyspace) storeSortedSet(key string, setVal *sortedSet, changes int) {
+	if _, ok := ks.keys[key]; !ok {
+		ks.setEntry(key, keyspaceEntry{group: "sorted-set", expires: nil})
+	}
+
+	ks.sortedSetMap[key] = setVal
+	ks.modifications += changes
+	ks.touch(key)
+	ks.notifyEvent("zadd", key)
+}
+
+// PutInSortedSet adds the score and member pairs of values to the sorted set,
+// or updates the scores of the members it already has, as far as cond
+// allows. It returns how many members were added, or added and updated when
+// ch is set. A missing key is only created when a member is added.
+func (ks *keyspace) PutInSortedSet(key string, values []string, cond zaddCondition, ch bool) (int, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	setVal, err := ks.sortedSetForWrite(key)
+	if err != nil {
+		return 0, err
 	}
 
-	added := 0
+	added, changed := 0, 0
 	for i := 0; i < len(values); i += 2 {
 		rawScore := values[i]
 		member := values[i+1]
@@ -371,42 +811,614 @@ func (ks *keyspace) PutInSortedSet(key string, values []string) (int, error) {
 			continue
 		}
 
-		setVal.Put(score, member)
-		added++
+		old, exists := setVal.Score(member)
+		if !cond.allows(exists) || (exists && old == score) {
+			continue
+		}
+
+		if exists {
+			changed++
+		} else {
+			added++
+		}
+		setVal.Put(member, score)
 	}
 
-	ks.sortedSetMap[key] = setVal
-	ks.modifications += 1
+	if setVal.Len() > 0 {
+		ks.storeSortedSet(key, setVal, added+changed)
+	}
+	if ch {
+		return added + changed, nil
+	}
 	return added, nil
 }
 
-func (ks *keyspace) GetSortedSetValuesByRange(key string, start int64, stop int64) ([]string, error) {
+// IncrementSortedSetScore adds delta to the score of member, which counts as
+// 0 when it is missing, and returns the new score. It reports false, leaving
+// the sorted set as it is, when cond doesn't allow writing the member.
+func (ks *keyspace) IncrementSortedSetScore(key string, member string, delta float64, cond zaddCondition) (float64, bool, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	setVal, err := ks.sortedSetForWrite(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	old, exists := setVal.Score(member)
+	if !cond.allows(exists) {
+		return 0, false, nil
+	}
+
+	score := old + delta
+	if math.IsNaN(score) {
+		return 0, false, ErrNotFloat
+	}
+
+	setVal.Put(member, score)
+	ks.storeSortedSet(key, setVal, 1)
+	return score, true, nil
+}
+
+// GetSortedSetRange returns the members of the sorted set, with their scores,
+// from rank start to rank stop ordered by score. See rankWindow.
+func (ks *keyspace) GetSortedSetRange(key string, start int64, stop int64, touch bool) ([]scoredMember, error) {
+	result := make([]scoredMember, 0)
+	err := ks.ReadSortedSet(key, touch, func(setVal *sortedSet) error {
+		skip, n := rankWindow(setVal.Len(), start, stop)
+		if n == 0 {
+			return nil
+		}
+
+		setVal.Walk(false, func(m scoredMember) bool {
+			if skip > 0 {
+				skip--
+				return true
+			}
+
+			result = append(result, m)
+			return int64(len(result)) < n
+		})
+		return nil
+	})
+	return result, err
+}
+
+// ReadSortedSet calls read with the sorted set of key while holding the read
+// lock, so read can walk its members without copying them. A missing key is
+// an empty sorted set. read must not change the set, and the lock is held
+// until it returns.
+func (ks *keyspace) ReadSortedSet(key string, touch bool, read func(*sortedSet) error) error {
 	ks.mutex.RLock()
 	defer ks.mutex.RUnlock()
 
-	result := make([]string, 0)
-	ke, ok := ks.keys[key]
+	ke, err := ks.requireGroup(key, "sorted-set")
+	if err != nil {
+		return err
+	}
+	if ke.group == "" {
+		return read(newSortedSet())
+	}
+
+	setVal, ok := ks.sortedSetMap[key]
 	if !ok {
-		return result, fmt.Errorf("key '%s' does not support this operation", key)
+		return fmt.Errorf("key '%s' not found", key)
+	}
+
+	if touch {
+		ks.touch(key)
+	}
+	return read(setVal)
+}
+
+// rankWindow returns how many members of a sorted set of size members come
+// before rank start, and how many there are from rank start to rank stop,
+// both included. Negative ranks count from the end, -1 being the last member,
+// and ranks out of the range of members are clamped to it, like in redis.
+func rankWindow(size int64, start int64, stop int64) (int64, int64) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop - start + 1
+}
+
+// SortedSetScore returns the score of member in the sorted set. It reports
+// false when the key or the member don't exist.
+func (ks *keyspace) SortedSetScore(key string, member string, touch bool) (float64, bool, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	ke, err := ks.requireGroup(key, "sorted-set")
+	if err != nil || ke.group == "" {
+		return 0, false, err
+	}
+
+	score, found := ks.sortedSetMap[key].Score(member)
+	if found && touch {
+		ks.touch(key)
+	}
+	return score, found, nil
+}
+
+var groupEncodings = map[string]string{
+	"string":     "raw",
+	"list":       "linkedlist",
+	"sorted-set": "skiplist",
+}
+
+// encodingLimits holds the largest lists and sorted sets reported with the
+// compact listpack encoding instead of the one in groupEncodings.
+type encodingLimits struct {
+	listMaxListpackSize    int64
+	zsetMaxListpackEntries int64
+}
+
+var defaultEncodingLimits = encodingLimits{listMaxListpackSize: 128, zsetMaxListpackEntries: 128}
+
+// Encoding returns the encoding of the key reported by OBJECT ENCODING.
+func (ks *keyspace) Encoding(key string, limits encodingLimits) (string, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	ke, ok := ks.keys[key]
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return "", false
+	}
+
+	return ks.encoding(key, ke.group, limits), true
+}
+
+func (ks *keyspace) encoding(key string, group string, limits encodingLimits) string {
+	switch group {
+	case "string":
+		if ks.stringMap[key].isInt {
+			return "int"
+		}
+	case "list":
+		if int64(ks.listMap[key].size) <= limits.listMaxListpackSize {
+			return "listpack"
+		}
+	case "sorted-set":
+		if ks.sortedSetMap[key].Len() <= limits.zsetMaxListpackEntries {
+			return "listpack"
+		}
+	}
+
+	return groupEncodings[group]
+}
+
+// Dump lists every key sorted by name, one per line, with its group and its
+// expiry in unix milliseconds, or -1 when it has none. Keys that expired but
+// were not deleted yet are listed too.
+func (ks *keyspace) Dump() string {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	keys := make([]string, 0, len(ks.keys))
+	for key := range ks.keys {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		ke := ks.keys[key]
+		expires := int64(-1)
+		if ke.expires != nil {
+			expires = ke.expires.UnixMilli()
+		}
+		fmt.Fprintf(&b, "key:%s group:%s expires:%d\n", strconv.Quote(key), ke.group, expires)
+	}
+
+	return b.String()
+}
+
+// Describe returns a description of the internal representation of the key,
+// in the format used by DEBUG OBJECT.
+func (ks *keyspace) Describe(key string, limits encodingLimits) (string, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	ke, ok := ks.keys[key]
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return "", false
+	}
+
+	desc := fmt.Sprintf("encoding:%s serializedlength:%d", ks.encoding(key, ke.group, limits), len(ks.serializeEntry(key)))
+	if ke.group == "list" {
+		desc += fmt.Sprintf(" ql_nodes:%d", ks.listMap[key].size)
+	}
+
+	return desc, true
+}
+
+var (
+	entryOverhead    = int64(unsafe.Sizeof(keyspaceEntry{}))
+	listNodeOverhead = int64(unsafe.Sizeof(listnode{}))
+	treeNodeOverhead = int64(unsafe.Sizeof(node[float64, string]{}))
+	stringOverhead   = int64(unsafe.Sizeof(""))
+)
+
+// MemoryUsage estimates how many bytes the key and its value take. It does not
+// account for the overhead of the maps holding them.
+func (ks *keyspace) MemoryUsage(key string) (int64, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	ke, ok := ks.keys[key]
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return 0, false
+	}
+
+	usage := entryOverhead + stringOverhead + int64(len(key))
+	switch ke.group {
+	case "string":
+		usage += int64(ks.stringMap[key].Len())
+
+	case "list":
+		for n := ks.listMap[key].head; n != nil; n = n.next {
+			usage += listNodeOverhead + int64(len(n.value))
+		}
+
+	case "sorted-set":
+		setVal := ks.sortedSetMap[key]
+		setVal.tree.InOrderTraversal(func(score float64, members []string) {
+			usage += treeNodeOverhead
+			for _, m := range members {
+				usage += stringOverhead + int64(len(m))
+			}
+		})
 	}
 
-	if ke.group != "sorted-set" {
-		return result, fmt.Errorf("key '%s' does not support this operation", key)
+	return usage, true
+}
+
+type scoredMember struct {
+	member string
+	score  float64
+}
+
+// SortedSetCombine combines the sorted sets at keys the way ZUNION, ZINTER and
+// ZDIFF do, with op being "union", "inter" or "diff". The score of each member
+// in the i-th set is multiplied by weights[i], or 1 when weights is empty, and
+// the scores of a member found in several sets are combined by aggregate:
+// "sum", "min" or "max". Diff keeps the members of the first set missing from
+// all the others, with their original score. Missing keys are empty sets. The
+// result is ordered by score and then lexicographically.
+func (ks *keyspace) SortedSetCombine(op string, keys []string, weights []float64, aggregate string) ([]scoredMember, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	return ks.combineSortedSets(op, keys, weights, aggregate)
+}
+
+// SortedSetCombineStore stores the result of SortedSetCombine at dest,
+// replacing its value, and returns how many members it has. An empty result
+// deletes dest.
+func (ks *keyspace) SortedSetCombineStore(dest string, op string, keys []string, weights []float64, aggregate string) (int, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	members, err := ks.combineSortedSets(op, keys, weights, aggregate)
+	if err != nil {
+		return 0, err
+	}
+
+	ke, exists := ks.keys[dest]
+	if len(members) == 0 {
+		if exists {
+			ks.removeKey(dest, ke.group, "del")
+		}
+		return 0, nil
+	}
+
+	if exists {
+		ks.deleteValue(dest, ke.group)
+	}
+
+	setVal := newSortedSet()
+	for _, m := range members {
+		setVal.Put(m.member, m.score)
+	}
+	ks.sortedSetMap[dest] = setVal
+	ks.setEntry(dest, keyspaceEntry{group: "sorted-set", expires: nil})
+	ks.modifications += len(members)
+	ks.touch(dest)
+	ks.notifyEvent("z"+op+"store", dest)
+
+	return len(members), nil
+}
+
+// combineSortedSets implements SortedSetCombine. The caller must hold the
+// lock.
+func (ks *keyspace) combineSortedSets(op string, keys []string, weights []float64, aggregate string) ([]scoredMember, error) {
+	// the scores of the sets are read in place, and weighted as they are
+	// combined
+	sets := make([]map[string]float64, 0, len(keys))
+	for _, key := range keys {
+		ke, err := ks.requireGroup(key, "sorted-set")
+		if err != nil {
+			return nil, err
+		}
+
+		var scores map[string]float64
+		if ke.group != "" {
+			scores = ks.sortedSetMap[key].scores
+		}
+		sets = append(sets, scores)
+	}
+
+	weighted := func(i int, score float64) float64 {
+		if op == "diff" || len(weights) <= i {
+			return score
+		}
+		return weightScore(score, weights[i])
+	}
+
+	combined := make(map[string]float64)
+	switch op {
+	case "union":
+		for i, scores := range sets {
+			for member, score := range scores {
+				score = weighted(i, score)
+				if current, ok := combined[member]; ok {
+					score = aggregateScores(current, score, aggregate)
+				}
+				combined[member] = score
+			}
+		}
+
+	case "inter":
+		for member, score := range sets[0] {
+			score = weighted(0, score)
+			inAll := true
+			for i, scores := range sets[1:] {
+				other, ok := scores[member]
+				if !ok {
+					inAll = false
+					break
+				}
+				score = aggregateScores(score, weighted(i+1, other), aggregate)
+			}
+
+			if inAll {
+				combined[member] = score
+			}
+		}
+
+	case "diff":
+		for member, score := range sets[0] {
+			inOther := false
+			for _, scores := range sets[1:] {
+				if _, ok := scores[member]; ok {
+					inOther = true
+					break
+				}
+			}
+
+			if !inOther {
+				combined[member] = score
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid sorted set operation '%s'", op)
+	}
+
+	result := make([]scoredMember, 0, len(combined))
+	for member, score := range combined {
+		result = append(result, scoredMember{member: member, score: score})
+	}
+	slices.SortFunc(result, func(a, b scoredMember) int {
+		if a.score != b.score {
+			return cmp.Compare(a.score, b.score)
+		}
+		return strings.Compare(a.member, b.member)
+	})
+
+	return result, nil
+}
+
+// weightScore multiplies score by weight. Like in redis, an infinite score
+// weighted by 0 is 0 instead of NaN.
+func weightScore(score float64, weight float64) float64 {
+	weighted := score * weight
+	if math.IsNaN(weighted) {
+		return 0
+	}
+	return weighted
+}
+
+func aggregateScores(a float64, b float64, aggregate string) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	default:
+		// adding infinities of opposite signs is 0, like in redis
+		sum := a + b
+		if math.IsNaN(sum) {
+			return 0
+		}
+		return sum
+	}
+}
+
+// ScanSortedSet iterates over the members of a sorted set ordered by score.
+// The returned cursor is the position where the next call should resume, or
+// 0 when the iteration is complete.
+func (ks *keyspace) ScanSortedSet(key string, cursor int, pattern string, count int, touch bool) (int, []scoredMember, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	result := make([]scoredMember, 0)
+	ke, err := ks.requireGroup(key, "sorted-set")
+	if err != nil || ke.group == "" {
+		return 0, result, err
 	}
 
 	setVal, ok := ks.sortedSetMap[key]
 	if !ok {
-		return result, fmt.Errorf("key '%s' not found", key)
+		return 0, result, fmt.Errorf("key '%s' not found", key)
 	}
 
-	if stop < 0 {
-		stop = setVal.Size() + stop + 1
+	members := make([]scoredMember, 0, setVal.Len())
+	setVal.tree.InOrderTraversal(func(score float64, values []string) {
+		for _, v := range values {
+			members = append(members, scoredMember{member: v, score: score})
+		}
+	})
+
+	var matcher *globMatcher
+	if pattern != "" {
+		matcher = compileGlob(pattern)
+	}
+
+	// cursor+count would overflow for huge counts
+	i := cursor
+	for ; i < len(members) && i-cursor < count; i++ {
+		if matcher == nil || matcher.Match(members[i].member) {
+			result = append(result, members[i])
+		}
 	}
 
-	// FIXME: this takes O(N)
-	allValues := setVal.GetValueSet()
-	values := allValues[start:stop]
-	return values, nil
+	if i >= len(members) {
+		i = 0
+	}
+	if touch {
+		ks.touch(key)
+	}
+	return i, result, nil
+}
+
+// SampleExpired looks at up to n of the keys that have an expiry and returns
+// the ones that expired, along with how many keys it looked at. Map iteration
+// starts at a random position, so each call samples different keys.
+func (ks *keyspace) SampleExpired(n int) ([]string, int) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	expired := make([]string, 0)
+	sampled := 0
+	for key := range ks.volatile {
+		if sampled == n {
+			break
+		}
+		sampled++
+
+		if CheckIsExpired(ks.clock, ks.keys[key]) {
+			expired = append(expired, key)
+		}
+	}
+
+	return expired, sampled
+}
+
+// RandomKey returns a random key of group, or of any group when group is
+// empty. Keys that expired but were not deleted yet are never returned. It
+// reports false when no key matches.
+func (ks *keyspace) RandomKey(group string) (string, bool) {
+	candidates := make([]string, 0)
+	ks.ForEach(func(key string, ke keyspaceEntry) bool {
+		if group == "" || ke.group == group {
+			candidates = append(candidates, key)
+		}
+		return true
+	})
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// Export returns the commands that recreate the key and its expiry, the ones
+// written to RESP snapshots, and how many there are. It reports false when
+// the key is missing or expired, or when there is nothing to recreate.
+func (ks *keyspace) Export(key string) (string, int, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	ke, ok := ks.keys[key]
+	if !ok || CheckIsExpired(ks.clock, ke) {
+		return "", 0, false
+	}
+
+	payload := ks.serializeEntry(key)
+	if payload == "" {
+		return "", 0, false
+	}
+
+	commands := 1
+	if ke.expires != nil {
+		commands++
+	}
+	return payload, commands, true
+}
+
+// DeleteIfUnchanged deletes key when it still holds the value that payload,
+// returned by Export, recreates. It reports whether the key was deleted.
+func (ks *keyspace) DeleteIfUnchanged(key string, payload string) bool {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ke, ok := ks.keys[key]
+	if !ok || ks.serializeEntry(key) != payload {
+		return false
+	}
+
+	ks.removeKey(key, ke.group, "del")
+	return true
+}
+
+// ForEach calls fn for every key that did not expire, in no particular order,
+// until fn returns false. It holds the read lock while doing so, so fn must
+// not call other methods of the keyspace. Expired keys are skipped but not
+// deleted.
+func (ks *keyspace) ForEach(fn func(key string, entry keyspaceEntry) bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	for key, ke := range ks.keys {
+		if CheckIsExpired(ks.clock, ke) {
+			continue
+		}
+		if !fn(key, ke) {
+			return
+		}
+	}
+}
+
+// Stats returns how many keys there are and how many of them have an expiry.
+// Keys that expired but were not deleted yet are not counted. Only keys with
+// an expiry can be expired, so only those are checked.
+func (ks *keyspace) Stats() (keys int, expires int) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	expired := 0
+	for key := range ks.volatile {
+		if CheckIsExpired(ks.clock, ks.keys[key]) {
+			expired++
+		}
+	}
+
+	return len(ks.keys) - expired, len(ks.volatile) - expired
 }
 
 func CheckIsExpired(c ClockTimer, ke keyspaceEntry) bool {
@@ -417,3 +1429,61 @@ func CheckIsExpired(c ClockTimer, ke keyspaceEntry) bool {
 	expires := *ke.expires
 	return c.Now().After(expires)
 }
+
+// Equal reports whether both keyspaces hold the same keys, with the same
+// values and expiry. Members of a sorted set sharing a score may be in any
+// order. It does not lock either keyspace.
+func (ks keyspace) Equal(o keyspace) bool {
+	if len(ks.keys) != len(o.keys) {
+		return false
+	}
+
+	for k, e := range ks.keys {
+		oe, ok := o.keys[k]
+		if !ok || !e.Equal(oe) {
+			return false
+		}
+
+		switch e.group {
+		case "string":
+			ev, ok1 := ks.stringMap[k]
+			ov, ok2 := o.stringMap[k]
+			if !ok1 || !ok2 || !ev.Equal(ov) {
+				return false
+			}
+
+		case "list":
+			ev, ok1 := ks.listMap[k]
+			ov, ok2 := o.listMap[k]
+			if !ok1 || !ok2 || !slices.Equal(ev.ToSlice(), ov.ToSlice()) {
+				return false
+			}
+
+		case "sorted-set":
+			ev, ok1 := ks.sortedSetMap[k]
+			ov, ok2 := o.sortedSetMap[k]
+			if !ok1 || !ok2 || !slices.Equal(sortedSetMembers(ev.tree), sortedSetMembers(ov.tree)) {
+				return false
+			}
+
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortedSetMembers returns the members of the set ordered by score and then
+// lexicographically.
+func sortedSetMembers(set rbtree[float64, string]) []scoredMember {
+	members := make([]scoredMember, 0, set.Size())
+	set.InOrderTraversal(func(score float64, values []string) {
+		sorted := slices.Clone(values)
+		slices.Sort(sorted)
+		for _, v := range sorted {
+			members = append(members, scoredMember{member: v, score: score})
+		}
+	})
+	return members
+}
@@ -0,0 +1,639 @@
+package redis
+
+import (
+	"errors"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetTyped(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.PushToTail("Names", []string{"John"})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+
+	t.Run("should return value if group matches", func(t *testing.T) {
+		kr, err := ks.GetTyped("Name", "string", true)
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if !kr.IsString() || *kr.str != "John" {
+			t.Errorf("got: %#v. want: 'John'", kr)
+		}
+	})
+
+	t.Run("should return wrong type error if group does not match", func(t *testing.T) {
+		kr, err := ks.GetTyped("Names", "string", true)
+		if !errors.Is(err, ErrWrongType) {
+			t.Fatalf("expected wrong type error. got: %v", err)
+		}
+
+		if kr.IsValid() {
+			t.Errorf("expected invalid result. got: %#v", kr)
+		}
+
+		want := "key 'Names' does not support this operation"
+		if err.Error() != want {
+			t.Errorf("got: '%s'. want: '%s'", err.Error(), want)
+		}
+	})
+
+	t.Run("should return invalid result without error if key does not exist", func(t *testing.T) {
+		kr, err := ks.GetTyped("Missing", "list", true)
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if kr.IsValid() {
+			t.Errorf("expected invalid result. got: %#v", kr)
+		}
+	})
+
+	t.Run("should treat expired key as non existing", func(t *testing.T) {
+		kr, err := ks.GetTyped("Old", "list", true)
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if kr.IsValid() {
+			t.Errorf("expected invalid result. got: %#v", kr)
+		}
+
+		if _, ok := ks.keys["Old"]; ok {
+			t.Error("expected expired key to be deleted")
+		}
+	})
+
+	t.Run("should not read a value of another group when the key changes type", func(t *testing.T) {
+		// a second thread, so the writes land between the check and the read
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 2000; i++ {
+				ks.SetStringKey("Flip", "John", nil)
+				ks.SetListKey("Flip", []string{"John"}, nil)
+			}
+		}()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			kr, err := ks.GetTyped("Flip", "string", true)
+			if err == nil && kr.arr != nil {
+				t.Fatalf("expected a string or a wrong type error. got: %#v", kr)
+			}
+		}
+	})
+}
+
+func BenchmarkAppend(b *testing.B) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ks.Append("Name", "hello", defaultProtoMaxBulkLen)
+	}
+}
+
+func BenchmarkIncrement(b *testing.B) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Counter", "0", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ks.IncrementBy("Counter", 1)
+	}
+}
+
+func TestStringValue(t *testing.T) {
+	testCases := []struct {
+		value   string
+		wantInt bool
+	}{
+		{value: "10", wantInt: true},
+		{value: "-10", wantInt: true},
+		{value: "0", wantInt: true},
+		{value: "9223372036854775807", wantInt: true},
+		{value: "-9223372036854775808", wantInt: true},
+		{value: "9223372036854775808", wantInt: false},
+		{value: "007", wantInt: false},
+		{value: "+1", wantInt: false},
+		{value: "-0", wantInt: false},
+		{value: " 1", wantInt: false},
+		{value: "", wantInt: false},
+		{value: "John", wantInt: false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.value, func(t *testing.T) {
+			v := newStringValue([]byte(tC.value))
+			if v.isInt != tC.wantInt {
+				t.Errorf("got int: %v. want: %v", v.isInt, tC.wantInt)
+			}
+
+			if got := v.String(); got != tC.value {
+				t.Errorf("got: '%s'. want: '%s'", got, tC.value)
+			}
+			if got := string(v.Bytes()); got != tC.value {
+				t.Errorf("got bytes: '%s'. want: '%s'", got, tC.value)
+			}
+			if got := v.Len(); got != len(tC.value) {
+				t.Errorf("got length: %d. want: %d", got, len(tC.value))
+			}
+		})
+	}
+}
+
+func TestBulkExists(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.PushToTail("Names", []string{"John"})
+
+	orders := [][]string{
+		{"Name", "None", "Name", "Names", "None", "Name"},
+		{"None", "Name", "None", "Name", "Name", "Names"},
+		{"Names", "Name", "Name", "Name", "None", "None"},
+	}
+
+	want := map[string]int{"Name": 3, "Names": 1, "None": 0}
+	for _, keys := range orders {
+		got := ks.BulkExists(keys)
+		if !maps.Equal(got, want) {
+			t.Errorf("keys %v: got: %v. want: %v", keys, got, want)
+		}
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.PushToTail("Names", []string{"John", "Mary"})
+	ks.PutInSortedSet("Scores", []string{"1", "John", "1", "Mary", "2", "Ann"}, zaddAlways, false)
+
+	keyCost := func(key string) int64 { return entryOverhead + stringOverhead + int64(len(key)) }
+	testCases := []struct {
+		key  string
+		want int64
+	}{
+		{key: "Name", want: keyCost("Name") + 4},
+		{key: "Names", want: keyCost("Names") + 2*listNodeOverhead + 8},
+		{key: "Scores", want: keyCost("Scores") + 2*treeNodeOverhead + 3*stringOverhead + 11},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.key, func(t *testing.T) {
+			got, ok := ks.MemoryUsage(tC.key)
+			if !ok {
+				t.Fatal("expected key to exist")
+			}
+
+			if got != tC.want {
+				t.Errorf("got: %d. want: %d", got, tC.want)
+			}
+		})
+	}
+
+	t.Run("missing key", func(t *testing.T) {
+		if _, ok := ks.MemoryUsage("None"); ok {
+			t.Error("expected missing key to not be reported")
+		}
+	})
+}
+
+func TestFrequency(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+
+	before, ok := ks.Frequency("Name")
+	if !ok {
+		t.Fatal("expected key to exist")
+	}
+
+	for i := 0; i < 100; i++ {
+		ks.Get("Name")
+	}
+
+	after, _ := ks.Frequency("Name")
+	if after <= before {
+		t.Errorf("expected frequency to increase after access. before: %d. after: %d", before, after)
+	}
+
+	again, _ := ks.Frequency("Name")
+	if again != after {
+		t.Errorf("expected reading the frequency to not count as access. got: %d. want: %d", again, after)
+	}
+
+	ks.BulkDelete([]string{"Name"})
+	ks.SetStringKey("Name", "John", nil)
+	if got, _ := ks.Frequency("Name"); got >= after {
+		t.Errorf("expected frequency to reset after delete. got: %d", got)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	now := time.Now()
+	build := func(change func(ks *keyspace)) keyspace {
+		ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+		ks.SetStringKey("Name", "John", nil)
+		ks.PushToTail("Names", []string{"John", "Mary"})
+		ks.PutInSortedSet("Scores", []string{"1", "John", "1", "Mary", "2", "Ann"}, zaddAlways, false)
+		ks.ExpireAt("Names", now.Add(time.Hour))
+		if change != nil {
+			change(ks)
+		}
+		return *ks
+	}
+
+	base := build(nil)
+	t.Run("equal keyspaces", func(t *testing.T) {
+		if !base.Equal(build(nil)) {
+			t.Error("expected keyspaces to be equal")
+		}
+	})
+
+	t.Run("members sharing a score in another order", func(t *testing.T) {
+		other := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+		other.SetStringKey("Name", "John", nil)
+		other.PushToTail("Names", []string{"John", "Mary"})
+		other.PutInSortedSet("Scores", []string{"2", "Ann", "1", "Mary", "1", "John"}, zaddAlways, false)
+		other.ExpireAt("Names", now.Add(time.Hour))
+
+		if !base.Equal(*other) {
+			t.Error("expected keyspaces to be equal")
+		}
+	})
+
+	testCases := []struct {
+		desc   string
+		change func(ks *keyspace)
+	}{
+		{desc: "extra key", change: func(ks *keyspace) { ks.SetStringKey("Other", "value", nil) }},
+		{desc: "missing key", change: func(ks *keyspace) { ks.BulkDelete([]string{"Name"}) }},
+		{desc: "different string", change: func(ks *keyspace) { ks.Append("Name", "ny", defaultProtoMaxBulkLen) }},
+		{desc: "different list", change: func(ks *keyspace) { ks.PushToHead("Names", []string{"Ann"}) }},
+		{desc: "different sorted set member", change: func(ks *keyspace) { ks.PutInSortedSet("Scores", []string{"3", "Bob"}, zaddAlways, false) }},
+		{desc: "different type", change: func(ks *keyspace) {
+			ks.BulkDelete([]string{"Name"})
+			ks.PushToTail("Name", []string{"John"})
+		}},
+		{desc: "different expiry", change: func(ks *keyspace) { ks.ExpireAt("Names", now.Add(2*time.Hour)) }},
+		{desc: "expiry removed", change: func(ks *keyspace) { ks.Persist("Names") }},
+		{desc: "expiry added", change: func(ks *keyspace) { ks.ExpireAt("Name", now.Add(time.Hour)) }},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			other := build(tC.change)
+			if base.Equal(other) {
+				t.Error("expected keyspaces to differ")
+			}
+
+			if other.Equal(base) {
+				t.Error("expected comparison to be symmetric")
+			}
+		})
+	}
+}
+
+func TestRequireGroup(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.PushToTail("Names", []string{"John"})
+	ks.PutInSortedSet("Scores", []string{"1", "John"}, zaddAlways, false)
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+
+	operations := []struct {
+		desc string
+		key  string
+		run  func(key string) error
+	}{
+		{desc: "append", key: "Names", run: func(key string) error { _, err := ks.Append(key, "a", defaultProtoMaxBulkLen); return err }},
+		{desc: "increment", key: "Names", run: func(key string) error { _, err := ks.IncrementBy(key, 1); return err }},
+		{desc: "push to tail", key: "Name", run: func(key string) error { _, err := ks.PushToTail(key, []string{"a"}); return err }},
+		{desc: "push to head", key: "Scores", run: func(key string) error { _, err := ks.PushToHead(key, []string{"a"}); return err }},
+		{desc: "put in sorted set", key: "Names", run: func(key string) error {
+			_, err := ks.PutInSortedSet(key, []string{"1", "a"}, zaddAlways, false)
+			return err
+		}},
+		{desc: "sorted set range", key: "Name", run: func(key string) error { _, err := ks.GetSortedSetRange(key, 0, -1, true); return err }},
+		{desc: "sorted set score", key: "Name", run: func(key string) error { _, _, err := ks.SortedSetScore(key, "a", true); return err }},
+		{desc: "scan sorted set", key: "Names", run: func(key string) error { _, _, err := ks.ScanSortedSet(key, 0, "", 10, true); return err }},
+		{desc: "typed get", key: "Scores", run: func(key string) error { _, err := ks.GetTyped(key, "list", true); return err }},
+	}
+	for _, op := range operations {
+		t.Run(op.desc, func(t *testing.T) {
+			err := op.run(op.key)
+			if !errors.Is(err, ErrWrongType) {
+				t.Fatalf("expected wrong type error. got: %v", err)
+			}
+
+			want := "key '" + op.key + "' does not support this operation"
+			if err.Error() != want {
+				t.Errorf("got: '%s'. want: '%s'", err.Error(), want)
+			}
+		})
+	}
+
+	t.Run("expired key is reported as missing", func(t *testing.T) {
+		ke, err := ks.requireGroup("Old", "list")
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if ke.group != "" {
+			t.Errorf("expected zero entry. got: %#v", ke)
+		}
+	})
+
+	t.Run("writes to an expired key of another group start over", func(t *testing.T) {
+		length, err := ks.PushToTail("Old", []string{"a"})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if length != 1 {
+			t.Errorf("got length: %d. want: 1", length)
+		}
+
+		if _, ok := ks.stringMap["Old"]; ok {
+			t.Error("expected expired string value to be deleted")
+		}
+
+		if ke := ks.keys["Old"]; ke.group != "list" || ke.expires != nil {
+			t.Errorf("got: %#v. want a list without expiry", ke)
+		}
+	})
+}
+
+func TestExpireAtPastDeadline(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.PutInSortedSet("Scores", []string{"1", "John"}, zaddAlways, false)
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+
+	if !ks.ExpireAt("Scores", now) {
+		t.Fatal("expected existing key to be reported")
+	}
+	if _, ok := ks.keys["Scores"]; ok {
+		t.Error("expected key to be deleted right away")
+	}
+	if _, ok := ks.sortedSetMap["Scores"]; ok {
+		t.Error("expected sorted set to be deleted right away")
+	}
+
+	if ks.ExpireAt("Old", now.Add(time.Hour)) {
+		t.Error("expected expired key to be reported as missing")
+	}
+	if ks.Expire("Old", 10) {
+		t.Error("expected expired key to be reported as missing")
+	}
+}
+
+func TestStats(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Session", "abc", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+	ks.PushToTail("Names", []string{"John", "Jane"})
+	ks.PutInSortedSet("Scores", []string{"1", "John"}, zaddAlways, false)
+	ks.Expire("Scores", 60)
+
+	// Old expired but was not deleted yet
+	keys, expires := ks.Stats()
+	if keys != 4 || expires != 2 {
+		t.Errorf("got: keys=%d expires=%d. want: keys=4 expires=2", keys, expires)
+	}
+
+	ks.Persist("Session")
+	keys, expires = ks.Stats()
+	if keys != 4 || expires != 1 {
+		t.Errorf("after persist - got: keys=%d expires=%d. want: keys=4 expires=1", keys, expires)
+	}
+}
+
+func TestModificationsCounting(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Session", "abc", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+	ks.PutInSortedSet("Scores", []string{"1", "John"}, zaddAlways, false)
+
+	testCases := []struct {
+		desc  string
+		write func()
+		want  int
+	}{
+		{desc: "expire existing key", write: func() { ks.Expire("Name", 60) }, want: 1},
+		{desc: "expire missing key", write: func() { ks.Expire("Missing", 60) }, want: 0},
+		{desc: "persist volatile key", write: func() { ks.Persist("Name") }, want: 1},
+		{desc: "persist key without expiry", write: func() { ks.Persist("Name") }, want: 0},
+		{desc: "get lazily expired key", write: func() { ks.Get("Old") }, want: 1},
+		{desc: "get existing key", write: func() { ks.Get("Name") }, want: 0},
+		{desc: "get missing key", write: func() { ks.Get("Old") }, want: 0},
+		{desc: "push to new list", write: func() { ks.PushToTail("Names", []string{"John", "Jane"}) }, want: 2},
+		{desc: "push to existing list", write: func() { ks.PushToHead("Names", []string{"Mary"}) }, want: 1},
+		{desc: "zadd existing member with the same score", write: func() { ks.PutInSortedSet("Scores", []string{"1", "John"}, zaddAlways, false) }, want: 0},
+		{desc: "zadd new and updated members", write: func() { ks.PutInSortedSet("Scores", []string{"2", "John", "3", "Jane"}, zaddAlways, false) }, want: 2},
+		{desc: "zunionstore members", write: func() { ks.SortedSetCombineStore("Total", "union", []string{"Scores"}, nil, "sum") }, want: 2},
+		{desc: "zinterstore empty result", write: func() { ks.SortedSetCombineStore("Total", "inter", []string{"Scores", "Missing"}, nil, "sum") }, want: 1},
+		{desc: "del existing and missing keys", write: func() { ks.BulkDelete([]string{"Name", "Session", "Missing"}) }, want: 2},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			before := ks.modifications
+			tC.write()
+
+			if got := ks.modifications - before; got != tC.want {
+				t.Errorf("got: %d changes. want: %d", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestPersist(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Session", "abc", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+
+	testCases := []struct {
+		desc string
+		key  string
+		want bool
+	}{
+		{desc: "volatile key", key: "Session", want: true},
+		{desc: "already persisted", key: "Session", want: false},
+		{desc: "key without expiry", key: "Name", want: false},
+		{desc: "missing key", key: "Missing", want: false},
+		{desc: "expired key", key: "Old", want: false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := ks.Persist(tC.key); got != tC.want {
+				t.Errorf("got: %v. want: %v", got, tC.want)
+			}
+		})
+	}
+
+	if ks.keys["Session"].expires != nil {
+		t.Errorf("expected the expiry of Session to be removed")
+	}
+	if _, ok := ks.volatile["Session"]; ok {
+		t.Errorf("expected Session to no longer be volatile")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Counter", "10", nil)
+	ks.SetStringKey("Session", "abc", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+	ks.PushToTail("Names", []string{"John"})
+
+	testCases := []struct {
+		desc     string
+		key      string
+		expected string
+		value    string
+		want     bool
+		wantErr  error
+		final    string
+		exists   bool
+	}{
+		{desc: "match", key: "Name", expected: "John", value: "Jane", want: true, final: "Jane", exists: true},
+		{desc: "mismatch", key: "Name", expected: "John", value: "Mary", want: false, final: "Jane", exists: true},
+		{desc: "match integer", key: "Counter", expected: "10", value: "11", want: true, final: "11", exists: true},
+		{desc: "empty expected on existing key", key: "Counter", expected: "", value: "0", want: false, final: "11", exists: true},
+		{desc: "absent key with empty expected", key: "Missing", expected: "", value: "new", want: true, final: "new", exists: true},
+		{desc: "absent key with expected value", key: "Other", expected: "new", value: "newer", want: false, exists: false},
+		{desc: "expired key is absent", key: "Old", expected: "", value: "fresh", want: true, final: "fresh", exists: true},
+		{desc: "wrong type", key: "Names", expected: "John", value: "Jane", want: false, wantErr: ErrWrongType, exists: false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ks.CompareAndSet(tC.key, tC.expected, tC.value)
+			if !errors.Is(err, tC.wantErr) {
+				t.Fatalf("got error: %v. want: %v", err, tC.wantErr)
+			}
+			if got != tC.want {
+				t.Errorf("got: %t. want: %t", got, tC.want)
+			}
+
+			v, ok := ks.stringMap[tC.key]
+			if ok != tC.exists {
+				t.Fatalf("got string key present: %t. want: %t", ok, tC.exists)
+			}
+			if ok && v.String() != tC.final {
+				t.Errorf("got value: %q. want: %q", v.String(), tC.final)
+			}
+		})
+	}
+
+	ks.CompareAndSet("Session", "abc", "def")
+	if ks.keys["Session"].expires == nil {
+		t.Errorf("expected the expiry of the key to be kept")
+	}
+}
+
+func TestSortedSetScoreUpdate(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.PutInSortedSet("Scores", []string{"5", "John", "7", "Jane", "5", "Mary"}, zaddAlways, false)
+
+	changed, err := ks.PutInSortedSet("Scores", []string{"10", "John"}, zaddAlways, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("got: %d changed. want: 1", changed)
+	}
+
+	got, err := ks.GetSortedSetRange("Scores", 0, -1, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []scoredMember{{"Mary", 5}, {"Jane", 7}, {"John", 10}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got: %v. want: %v", got, want)
+	}
+
+	setVal := ks.sortedSetMap["Scores"]
+	if size := setVal.Len(); size != 3 {
+		t.Errorf("got cardinality: %d. want: 3", size)
+	}
+	if got := setVal.tree.Get(5); !slices.Equal(got, []string{"Mary"}) {
+		t.Errorf("got members at the old score: %v. want: [Mary]", got)
+	}
+	if score, ok := setVal.Score("Mary"); !ok || score != 5 {
+		t.Errorf("got indexed score: %v, %t. want: 5, true", score, ok)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	ks := newKeyspace(TestClockTimer{mockNow: time.Now()}, &sync.RWMutex{})
+	ks.SetStringKey("Name", "John", nil)
+	ks.SetStringKey("Session", "abc", &ExpiryDuration{magnitude: 10, resolution: time.Second})
+	ks.SetStringKey("Old", "value", &ExpiryDuration{magnitude: -1, resolution: time.Second})
+	ks.PushToTail("Names", []string{"John"})
+
+	visited := make([]string, 0)
+	ks.ForEach(func(key string, entry keyspaceEntry) bool {
+		visited = append(visited, key)
+		return true
+	})
+	slices.Sort(visited)
+	if want := []string{"Name", "Names", "Session"}; !slices.Equal(visited, want) {
+		t.Errorf("got: %v. want: %v", visited, want)
+	}
+	if _, ok := ks.keys["Old"]; !ok {
+		t.Errorf("expected the expired key to be skipped, not deleted")
+	}
+
+	calls := 0
+	ks.ForEach(func(key string, entry keyspaceEntry) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Errorf("got: %d calls. want: 2", calls)
+	}
+}
+
+func TestSetListKey(t *testing.T) {
+	now := time.Now()
+	ks := newKeyspace(TestClockTimer{mockNow: now}, &sync.RWMutex{})
+	ks.SetStringKey("Names", "John", nil)
+	ks.SetKey("Names", []string{"John", "Jane"}, &ExpiryDuration{magnitude: 10, resolution: time.Second})
+
+	entry := ks.keys["Names"]
+	if entry.group != "list" {
+		t.Errorf("got group: %s. want: list", entry.group)
+	}
+	if want := now.Add(10 * time.Second); entry.expires == nil || !entry.expires.Equal(want) {
+		t.Errorf("got expiry: %v. want: %v", entry.expires, want)
+	}
+	if _, ok := ks.stringMap["Names"]; ok {
+		t.Errorf("expected the string value to be deleted")
+	}
+
+	got := ks.Get("Names")
+	if want := []string{"John", "Jane"}; !slices.Equal(got.arr, want) {
+		t.Errorf("got: %v. want: %v", got.arr, want)
+	}
+}
@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+const (
+	// lfuInitValue is the frequency of keys that were never accessed. It is
+	// above zero so new keys get a chance to accumulate accesses before being
+	// considered for eviction.
+	lfuInitValue = 5
+	lfuLogFactor = 10
+	lfuMaxValue  = 255
+)
+
+// lfuCounter is a logarithmic access counter: the more accesses it already
+// counted, the less likely the next one is to increment it. With the default
+// log factor it takes around a million accesses to saturate.
+type lfuCounter struct {
+	value atomic.Uint32
+}
+
+func newLFUCounter() *lfuCounter {
+	c := &lfuCounter{}
+	c.value.Store(lfuInitValue)
+	return c
+}
+
+func (c *lfuCounter) Increment() {
+	for {
+		current := c.value.Load()
+		if current >= lfuMaxValue {
+			return
+		}
+
+		base := 0.0
+		if current > lfuInitValue {
+			base = float64(current - lfuInitValue)
+		}
+
+		if rand.Float64() >= 1.0/(base*lfuLogFactor+1) {
+			return
+		}
+
+		if c.value.CompareAndSwap(current, current+1) {
+			return
+		}
+	}
+}
+
+func (c *lfuCounter) Value() int {
+	return int(c.value.Load())
+}
@@ -1,7 +1,13 @@
 package redis
 
+import (
+	"errors"
+	"fmt"
+)
+
 type listnode struct {
 	value string
+	prev  *listnode
 	next  *listnode
 }
 
@@ -20,6 +26,7 @@ func (l *list) AppendToTail(value string) {
 	} else {
 		tail := l.tail
 		tail.next = node
+		node.prev = tail
 		l.tail = node
 	}
 
@@ -53,6 +60,7 @@ func (l *list) AppendToHead(value string) {
 	} else {
 		head := l.head
 		node.next = head
+		head.prev = node
 		l.head = node
 	}
 
@@ -70,3 +78,157 @@ func NewListFromSlice(values []string) list {
 	l.AppendSliceToTail(values)
 	return l
 }
+
+// PopHead removes the first value of the list and returns it. It reports
+// false when the list is empty.
+func (l *list) PopHead() (string, bool) {
+	if l.head == nil {
+		return "", false
+	}
+
+	node := l.head
+	l.unlink(node)
+	return node.value, true
+}
+
+// PopTail removes the last value of the list and returns it. It reports false
+// when the list is empty.
+func (l *list) PopTail() (string, bool) {
+	if l.tail == nil {
+		return "", false
+	}
+
+	node := l.tail
+	l.unlink(node)
+	return node.value, true
+}
+
+// Insert adds value right before or after the first occurrence of pivot, the
+// way LINSERT does. It reports false when pivot is not in the list.
+func (l *list) Insert(pivot string, value string, before bool) bool {
+	p := l.head
+	for p != nil && p.value != pivot {
+		p = p.next
+	}
+	if p == nil {
+		return false
+	}
+
+	node := &listnode{value: value}
+	if before {
+		node.prev, node.next = p.prev, p
+	} else {
+		node.prev, node.next = p, p.next
+	}
+
+	if node.prev == nil {
+		l.head = node
+	} else {
+		node.prev.next = node
+	}
+
+	if node.next == nil {
+		l.tail = node
+	} else {
+		node.next.prev = node
+	}
+
+	l.size += 1
+	return true
+}
+
+// Remove deletes the occurrences of value the way LREM does: the first count
+// of them from the head when count is positive, the last -count of them from
+// the tail when it is negative, and all of them when it is 0. It returns how
+// many were removed.
+func (l *list) Remove(value string, count int) int {
+	removed := 0
+	if count < 0 {
+		for p := l.tail; p != nil && removed < -count; {
+			prev := p.prev
+			if p.value == value {
+				l.unlink(p)
+				removed++
+			}
+			p = prev
+		}
+		return removed
+	}
+
+	for p := l.head; p != nil && (count == 0 || removed < count); {
+		next := p.next
+		if p.value == value {
+			l.unlink(p)
+			removed++
+		}
+		p = next
+	}
+	return removed
+}
+
+// unlink takes node out of the list.
+func (l *list) unlink(node *listnode) {
+	if node.prev == nil {
+		l.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+
+	if node.next == nil {
+		l.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+
+	node.prev, node.next = nil, nil
+	l.size -= 1
+}
+
+// validate checks that the links of the list agree with each other: walking
+// it from the head and from the tail visits the same size nodes, and every
+// node is the prev of its next.
+func (l *list) validate() error {
+	if l.head == nil || l.tail == nil {
+		if l.head != l.tail || l.size != 0 {
+			return fmt.Errorf("empty list with size %d", l.size)
+		}
+		return nil
+	}
+
+	if l.head.prev != nil {
+		return errors.New("head has a prev node")
+	}
+	if l.tail.next != nil {
+		return errors.New("tail has a next node")
+	}
+
+	forward := 0
+	var last *listnode
+	for p := l.head; p != nil; p = p.next {
+		if p.prev != last {
+			return fmt.Errorf("node %d is not the prev of its next", forward-1)
+		}
+		last = p
+		forward++
+		if forward > l.size {
+			return fmt.Errorf("more than %d nodes from the head", l.size)
+		}
+	}
+	if last != l.tail {
+		return errors.New("walking from the head does not end at the tail")
+	}
+
+	backward := 0
+	for p := l.tail; p != nil; p = p.prev {
+		backward++
+		if backward > l.size {
+			return fmt.Errorf("more than %d nodes from the tail", l.size)
+		}
+	}
+
+	if forward != l.size || backward != l.size {
+		return fmt.Errorf("size is %d, but there are %d nodes from the head and %d from the tail", l.size, forward, backward)
+	}
+
+	return nil
+}
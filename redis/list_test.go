@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestListMutations(t *testing.T) {
+	l := NewListFromSlice([]string{"b", "c"})
+
+	steps := []struct {
+		desc   string
+		mutate func(l *list)
+		want   []string
+	}{
+		{desc: "push to head", mutate: func(l *list) { l.AppendToHead("a") }, want: []string{"a", "b", "c"}},
+		{desc: "push to tail", mutate: func(l *list) { l.AppendSliceToTail([]string{"b", "d"}) }, want: []string{"a", "b", "c", "b", "d"}},
+		{desc: "insert before head", mutate: func(l *list) { l.Insert("a", "x", true) }, want: []string{"x", "a", "b", "c", "b", "d"}},
+		{desc: "insert after tail", mutate: func(l *list) { l.Insert("d", "y", false) }, want: []string{"x", "a", "b", "c", "b", "d", "y"}},
+		{desc: "insert after first pivot", mutate: func(l *list) { l.Insert("b", "z", false) }, want: []string{"x", "a", "b", "z", "c", "b", "d", "y"}},
+		{desc: "insert with missing pivot", mutate: func(l *list) { l.Insert("none", "z", true) }, want: []string{"x", "a", "b", "z", "c", "b", "d", "y"}},
+		{desc: "pop head", mutate: func(l *list) { l.PopHead() }, want: []string{"a", "b", "z", "c", "b", "d", "y"}},
+		{desc: "pop tail", mutate: func(l *list) { l.PopTail() }, want: []string{"a", "b", "z", "c", "b", "d"}},
+		{desc: "remove last occurrence", mutate: func(l *list) { l.Remove("b", -1) }, want: []string{"a", "b", "z", "c", "d"}},
+		{desc: "remove head and tail", mutate: func(l *list) { l.Remove("a", 1); l.Remove("d", 0) }, want: []string{"b", "z", "c"}},
+		{desc: "remove every value", mutate: func(l *list) { l.Remove("b", 0); l.Remove("z", 0); l.Remove("c", 0) }, want: []string{}},
+		{desc: "pop empty list", mutate: func(l *list) { l.PopHead(); l.PopTail() }, want: []string{}},
+		{desc: "push to empty list", mutate: func(l *list) { l.AppendToTail("a") }, want: []string{"a"}},
+	}
+	for _, step := range steps {
+		step.mutate(&l)
+
+		if err := l.validate(); err != nil {
+			t.Fatalf("%s - invalid list: %v", step.desc, err)
+		}
+		if got := l.ToSlice(); !slices.Equal(got, step.want) {
+			t.Errorf("%s - got: %v. want: %v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestListPopAndRemoveResults(t *testing.T) {
+	l := NewListFromSlice([]string{"a", "b", "a", "a"})
+
+	if v, ok := l.PopHead(); !ok || v != "a" {
+		t.Errorf("got: %s, %v. want: a, true", v, ok)
+	}
+	if v, ok := l.PopTail(); !ok || v != "a" {
+		t.Errorf("got: %s, %v. want: a, true", v, ok)
+	}
+	if got := l.Remove("a", 5); got != 1 {
+		t.Errorf("got: %d removed. want: 1", got)
+	}
+	if got := l.Remove("none", 0); got != 0 {
+		t.Errorf("got: %d removed. want: 0", got)
+	}
+	if _, ok := l.PopTail(); !ok {
+		t.Error("expected the last value to be popped")
+	}
+	if _, ok := l.PopHead(); ok {
+		t.Error("expected nothing to be popped from an empty list")
+	}
+}
+
+func TestListValidate(t *testing.T) {
+	t.Run("wrong size", func(t *testing.T) {
+		l := NewListFromSlice([]string{"a", "b"})
+		l.size = 3
+		if l.validate() == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("broken prev link", func(t *testing.T) {
+		l := NewListFromSlice([]string{"a", "b", "c"})
+		l.tail.prev = l.head
+		if l.validate() == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("stale tail", func(t *testing.T) {
+		l := NewListFromSlice([]string{"a", "b"})
+		l.tail = l.head
+		if l.validate() == nil {
+			t.Error("expected an error")
+		}
+	})
+}
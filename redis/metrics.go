@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// commandStats counts the commands processed for clients, by command.
+type commandStats struct {
+	mutex  sync.Mutex
+	counts map[Command]int64
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{counts: make(map[Command]int64)}
+}
+
+func (s *commandStats) Count(cmd Command) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counts[cmd] += 1
+}
+
+// Snapshot returns a copy of the counts, so they can be read without holding
+// the lock.
+func (s *commandStats) Snapshot() map[Command]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counts := make(map[Command]int64, len(s.counts))
+	for cmd, n := range s.counts {
+		counts[cmd] = n
+	}
+	return counts
+}
+
+// WriteMetrics writes the command counters and the keyspace size to w in the
+// plain text format scraped by Prometheus.
+func (app *Application) WriteMetrics(w io.Writer) error {
+	counts := app.stats.Snapshot()
+	commands := make([]string, 0, len(counts))
+	for cmd := range counts {
+		commands = append(commands, string(cmd))
+	}
+	slices.Sort(commands)
+
+	var b strings.Builder
+	b.WriteString("# TYPE redis_commands_processed_total counter\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "redis_commands_processed_total{command=\"%s\"} %d\n", strings.ToLower(cmd), counts[Command(cmd)])
+	}
+
+	app.state.mutex.RLock()
+	keys := len(app.state.keyspace.keys)
+	clients := len(app.clients)
+	app.state.mutex.RUnlock()
+
+	b.WriteString("# TYPE redis_keys gauge\n")
+	fmt.Fprintf(&b, "redis_keys %d\n", keys)
+	b.WriteString("# TYPE redis_connected_clients gauge\n")
+	fmt.Fprintf(&b, "redis_connected_clients %d\n", clients)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processMigrate moves the key to another instance of this server. The key
+// is sent as the commands that recreate it, the same ones written to RESP
+// snapshots, and deleted here once the target ran all of them, unless it
+// changed in the meantime. It replies NOKEY when there is no key to move.
+// Only the database 0 exists, on both sides.
+func processMigrate(ctx context.Context, args []string, app *Application) (string, error) {
+	host, rawPort, key := args[0], args[1], args[2]
+
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port < 1 || port > 65535 {
+		return "", errors.New("Invalid target port")
+	}
+
+	if args[3] != "0" {
+		return "", errors.New("DB index is out of range")
+	}
+
+	timeout, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || timeout < 0 {
+		return "", ErrNotInteger
+	}
+
+	payload, commands, ok := app.state.keyspace.Export(key)
+	if !ok {
+		return SerializeSimpleString("NOKEY"), nil
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if err := sendToTarget(ctx, addr, key, payload, commands, time.Duration(timeout)*time.Millisecond); err != nil {
+		return "", err
+	}
+
+	// the key was unlocked while it was sent, so a write in the meantime
+	// would be lost by deleting it
+	if !app.state.keyspace.DeleteIfUnchanged(key, payload) {
+		return "", errors.New("Key changed while it was migrated and was kept. The target holds its previous value")
+	}
+	return OK_SIMPLE_STRING, nil
+}
+
+// sendToTarget runs the commands of payload, which recreate key, on the
+// server at addr. It fails with ErrBusyKey when the target already has the
+// key. A timeout of 0 waits on the target for as long as it takes.
+func sendToTarget(ctx context.Context, addr string, key string, payload string, commands int, timeout time.Duration) error {
+	dialer := net.Dialer{Timeout: replicationDialTimeout}
+	if timeout > 0 {
+		dialer.Timeout = timeout
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%w connecting to %s: %v", ErrMigrateIO, addr, err)
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	reader := bufio.NewReader(conn)
+	readReply := func() (string, error) {
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("%w reading from %s: %v", ErrMigrateIO, addr, err)
+		}
+		return strings.TrimRight(reply, "\r\n"), nil
+	}
+
+	if err := WriteBulkStringArray(conn, []string{"EXISTS", key}); err != nil {
+		return fmt.Errorf("%w writing to %s: %v", ErrMigrateIO, addr, err)
+	}
+	reply, err := readReply()
+	if err != nil {
+		return err
+	}
+	if reply != ":0" {
+		return ErrBusyKey
+	}
+
+	// the commands are sent at once and their replies read in order
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return fmt.Errorf("%w writing to %s: %v", ErrMigrateIO, addr, err)
+	}
+	for i := 0; i < commands; i++ {
+		reply, err := readReply()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(reply, "-") {
+			return fmt.Errorf("Target instance replied with error: %s", reply[1:])
+		}
+	}
+
+	return nil
+}
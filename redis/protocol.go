@@ -1,8 +1,12 @@
 package redis
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -17,6 +21,15 @@ const (
 const NIL_BULK_STRING = "$-1\r\n"
 const OK_SIMPLE_STRING = "+OK\r\n"
 
+// ErrProtocol is returned when a frame can not be decoded because it does not
+// follow the RESP format.
+var ErrProtocol = errors.New("Protocol error")
+
+// ErrEmptyCommand is returned when a message holds nothing but white space,
+// like the stray newlines sent by interactive clients, or decodes to no
+// elements, like the empty array and the null bulk string. It gets no reply.
+var ErrEmptyCommand = errors.New("empty command")
+
 func getFirstCRIndex(raw []byte) int64 {
 	crIndex := int64(0)
 	for i, c := range raw {
@@ -41,6 +54,10 @@ func decodeBulkString(raw []byte) ([]string, error) {
 	}
 
 	rawString := string(rawLength)
+	if len(rawString) == 0 || dataStartIndex == 0 {
+		return nil, fmt.Errorf("%w: missing bulk length", ErrProtocol)
+	}
+
 	if rawString[0] == '-' && rawString != "-1" {
 		return nil, errors.New("invalid null string")
 	}
@@ -58,6 +75,10 @@ func decodeBulkString(raw []byte) ([]string, error) {
 		return []string{""}, nil
 	}
 
+	if length < 0 || dataStartIndex+length >= int64(len(raw)) {
+		return nil, fmt.Errorf("%w: bulk data shorter than length %d", ErrProtocol, length)
+	}
+
 	if raw[len(raw)-2] != raw[dataStartIndex+length] {
 		return nil, errors.New("data does not match length")
 	}
@@ -66,45 +87,71 @@ func decodeBulkString(raw []byte) ([]string, error) {
 	return []string{dataChunk}, nil
 }
 
+// decodeArray decodes an array of bulk strings. Each element is read up to
+// the length in its header, so the data of an element can hold CRLF.
 func decodeArray(raw []byte) ([]string, error) {
 	crIndex := getFirstCRIndex(raw)
+	if crIndex == 0 || int(crIndex)+1 >= len(raw) || raw[crIndex+1] != '\n' {
+		return nil, fmt.Errorf("%w: missing array length terminator", ErrProtocol)
+	}
 
-	s := string(raw)
-	numOfElements, err := strconv.ParseUint(string(s[:crIndex]), 10, 0)
+	numOfElements, err := strconv.ParseUint(string(raw[:crIndex]), 10, 0)
 	if err != nil {
-		return nil, errors.New("failed to parse number of elements to unsigned int")
+		return nil, fmt.Errorf("%w: invalid array length '%s'", ErrProtocol, raw[:crIndex])
 	}
 
-	parsed := make([]string, 0)
 	if numOfElements == 0 {
-		return parsed, nil
+		return make([]string, 0), nil
 	}
 
-	split := strings.Split(s[crIndex+2:], "\r\n")
-	if split[len(split)-1] == "" {
-		split = split[:len(split)-1]
+	// the count is checked against the frame before allocating for it, so a
+	// bogus header can't trigger a huge allocation. The shortest element is
+	// the empty bulk string, "$0\r\n\r\n"
+	rest := raw[crIndex+2:]
+	if numOfElements > uint64(len(rest)/6) {
+		return nil, fmt.Errorf("%w: expected %d elements", ErrProtocol, numOfElements)
 	}
 
-	for i := 0; i < len(split); i += 2 {
-		rawLength := split[i][1:]
+	parsed := make([]string, 0, numOfElements)
+	for i := uint64(0); i < numOfElements; i++ {
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("%w: expected %d elements", ErrProtocol, numOfElements)
+		}
+		if rest[0] != byte(BulkString) {
+			return nil, fmt.Errorf("%w: expected '$' at element %d", ErrProtocol, i)
+		}
+
+		headerEnd := bytes.Index(rest, []byte("\r\n"))
+		if headerEnd < 0 {
+			return nil, fmt.Errorf("%w: expected %d elements", ErrProtocol, numOfElements)
+		}
+
+		rawLength := string(rest[1:headerEnd])
 		length, err := strconv.ParseInt(rawLength, 10, 0)
-		if err != nil {
-			return nil, err
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length '%s'", ErrProtocol, rawLength)
 		}
 
-		data := split[i+1]
-		if int64(len(data)) != length {
-			return nil, fmt.Errorf("length and data mismatch. received length: %d. data length: %d", length, len(data))
+		data := rest[headerEnd+2:]
+		if length > int64(len(data))-2 || data[length] != '\r' || data[length+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk length %d does not match its data", ErrProtocol, length)
 		}
 
-		parsed = append(parsed, data)
+		parsed = append(parsed, string(data[:length]))
+		rest = data[length+2:]
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: expected %d elements", ErrProtocol, numOfElements)
 	}
 	return parsed, nil
 }
 
 func DecodeMessage(rawMessage []byte, app *Application) (*Cmd, error) {
+	// blank lines before a command are ignored, like redis does
+	rawMessage = bytes.TrimLeft(rawMessage, " \t\r\n")
 	if len(rawMessage) == 0 {
-		return nil, errors.New("Got an empty message")
+		return nil, ErrEmptyCommand
 	}
 	firstByte := rawMessage[0]
 	remaining := rawMessage[1:]
@@ -126,12 +173,141 @@ func DecodeMessage(rawMessage []byte, app *Application) (*Cmd, error) {
 		}
 		cmd.processed = parsed
 	default:
-		err = errors.New("invalid first byte")
+		parsed, err := decodeInline(rawMessage)
+		if err != nil {
+			return nil, err
+		}
+		cmd.processed = parsed
+	}
+
+	// the empty array and the null bulk string decode to nothing to run
+	if len(cmd.processed) == 0 {
+		return nil, ErrEmptyCommand
 	}
 
 	return &cmd, err
 }
 
+// decodeInline decodes an inline command, the plain text line interactive
+// clients like telnet send, splitting it at white space. Quoted arguments are
+// not supported.
+func decodeInline(raw []byte) ([]string, error) {
+	parsed := strings.Fields(string(raw))
+	if len(parsed) == 0 {
+		return nil, ErrEmptyCommand
+	}
+
+	return parsed, nil
+}
+
+// splitCommands is a bufio.SplitFunc that returns one command per token:
+// either a frame, an array made of its header line and a bulk string per
+// element or a lone bulk string, or an inline command line. Bulk strings are
+// read up to their length, so their data can hold newlines. Frames with
+// broken lengths fall back to ending at the line their data would, so the
+// commands after them are not lost.
+func splitCommands(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	more := func() (int, []byte, error) {
+		if atEOF {
+			return len(data), data, nil
+		}
+
+		// request more data
+		return 0, nil, nil
+	}
+
+	end := lineEnd(data, 0)
+	if end < 0 {
+		return more()
+	}
+
+	switch data[0] {
+	case byte(BulkString):
+		end = bulkEnd(data, 0, end)
+
+	case byte(Array):
+		header := strings.TrimSuffix(string(data[1:end-1]), "\r")
+		n, err := strconv.Atoi(header)
+		if err != nil {
+			n = 0
+		}
+
+		for i := 0; i < n && end >= 0; i++ {
+			headerEnd := lineEnd(data, end)
+			if headerEnd < 0 {
+				return more()
+			}
+
+			if data[end] == byte(BulkString) {
+				end = bulkEnd(data, end, headerEnd)
+			} else {
+				end = lineEnd(data, headerEnd)
+			}
+		}
+	}
+
+	if end < 0 {
+		return more()
+	}
+	return end, data[:end], nil
+}
+
+// lineEnd returns the index right after the next '\n' of data from start, or
+// -1 when there is none.
+func lineEnd(data []byte, start int) int {
+	i := bytes.IndexByte(data[start:], '\n')
+	if i < 0 {
+		return -1
+	}
+	return start + i + 1
+}
+
+// bulkEnd returns the index right after the bulk string with its header line
+// from start to headerEnd, or -1 when data ends before it does. A bulk string
+// with a length that doesn't match its data ends at the line after the
+// header.
+func bulkEnd(data []byte, start int, headerEnd int) int {
+	header := strings.TrimSuffix(string(data[start+1:headerEnd-1]), "\r")
+	if n, err := strconv.Atoi(header); err == nil && n >= 0 {
+		if n > len(data)-headerEnd-2 {
+			return -1
+		}
+		end := headerEnd + n + 2
+		if data[end-2] == '\r' && data[end-1] == '\n' {
+			return end
+		}
+	}
+
+	return lineEnd(data, headerEnd)
+}
+
+// InspectMessages decodes every command read from r and calls visit
+// with the decoded elements of each one, or with the error that prevented it
+// from being decoded.
+func InspectMessages(r io.Reader, visit func([]string, error)) error {
+	s := bufio.NewScanner(r)
+	s.Split(splitCommands)
+
+	for s.Scan() {
+		cmd, err := DecodeMessage(s.Bytes(), nil)
+		if errors.Is(err, ErrEmptyCommand) {
+			continue
+		}
+		if err != nil {
+			visit(nil, err)
+			continue
+		}
+
+		visit(cmd.processed, nil)
+	}
+
+	return s.Err()
+}
+
 func SerializeBulkString(data string) string {
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(data), data)
 }
@@ -172,12 +348,85 @@ func SerializeArray(data []any) string {
 			result += SerializeInteger(t)
 		case int64:
 			result += SerializeInteger(t)
+		case []any:
+			result += SerializeArray(t)
 		}
 	}
 
 	return result
 }
 
+// WriteBulkStringArray serializes data as an array of bulk strings straight
+// into w, element by element, without building the whole reply in memory.
+func WriteBulkStringArray(w io.Writer, data []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(data)); err != nil {
+		return err
+	}
+
+	for _, v := range data {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeScoredMembers serializes members as an array of bulk strings straight
+// into w, followed each by its formatted score when withScores is set.
+func writeScoredMembers(w io.Writer, members []scoredMember, withScores bool) error {
+	length := len(members)
+	if withScores {
+		length *= 2
+	}
+	if _, err := fmt.Fprintf(w, "*%d\r\n", length); err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if err := writeScoredMember(w, m, withScores); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeScoredMember writes the member of m as a bulk string into w, followed
+// by its formatted score when withScores is set.
+func writeScoredMember(w io.Writer, m scoredMember, withScores bool) error {
+	if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(m.member), m.member); err != nil {
+		return err
+	}
+	if !withScores {
+		return nil
+	}
+
+	score := formatScore(m.score)
+	_, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(score), score)
+	return err
+}
+
+// formatScore formats a sorted set score like redis does: the shortest
+// representation that parses back to the same float, without trailing zeros,
+// and inf, -inf or nan for the special values.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case math.IsNaN(score):
+		return "nan"
+	}
+
+	abs := math.Abs(score)
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
 func SerializeInteger[T integer](data T) string {
 	return fmt.Sprintf(":%d\r\n", data)
 }
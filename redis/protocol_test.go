@@ -1,7 +1,11 @@
 package redis
 
 import (
+	"bytes"
+	"errors"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,10 +19,10 @@ func TestBulkStringsDeserialization(t *testing.T) {
 		wantError bool
 	}{
 		{
-			"should return nil if null bulk string is received",
+			"should return error if null bulk string is received",
 			[]byte("$-1\r\n"),
-			&Cmd{processed: nil},
-			false,
+			nil,
+			true,
 		},
 		{
 			"should return error if null bulk has no number",
@@ -106,10 +110,10 @@ func TestArrayDeserialization(t *testing.T) {
 		wantError bool
 	}{
 		{
-			desc:      "should return empty array",
+			desc:      "should return error if empty array is received",
 			raw:       []byte("*0\r\n"),
-			want:      &Cmd{processed: []string{}},
-			wantError: false,
+			want:      nil,
+			wantError: true,
 		},
 		{
 			desc:      "should return string array (hello)",
@@ -135,6 +139,18 @@ func TestArrayDeserialization(t *testing.T) {
 			want:      &Cmd{processed: []string{"get", "key"}},
 			wantError: false,
 		},
+		{
+			desc:      "should return string array with CRLF in a value",
+			raw:       []byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$8\r\na\r\nb\r\n\r\n\r\n"),
+			want:      &Cmd{processed: []string{"set", "key", "a\r\nb\r\n\r\n"}},
+			wantError: false,
+		},
+		{
+			desc:      "should return string array with an empty value",
+			raw:       []byte("*2\r\n$4\r\necho\r\n$0\r\n\r\n"),
+			want:      &Cmd{processed: []string{"echo", ""}},
+			wantError: false,
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -163,3 +179,275 @@ func TestArrayDeserialization(t *testing.T) {
 		})
 	}
 }
+
+func TestEmptyCommands(t *testing.T) {
+	testCases := []struct {
+		desc string
+		raw  string
+	}{
+		{desc: "no data", raw: ""},
+		{desc: "blank line", raw: "\r\n"},
+		{desc: "white space", raw: " \t \r\n"},
+		{desc: "empty array", raw: "*0\r\n"},
+		{desc: "null bulk string", raw: "$-1\r\n"},
+		{desc: "empty array after blank lines", raw: "\r\n*0\r\n"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := DecodeMessage([]byte(tC.raw), nil)
+			if !errors.Is(err, ErrEmptyCommand) {
+				t.Errorf("expected an empty command error. got: %v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestMalformedArrayDeserialization(t *testing.T) {
+	testCases := []struct {
+		desc string
+		raw  string
+	}{
+		{desc: "missing length terminator", raw: "*2"},
+		{desc: "length line without line feed", raw: "*2\r"},
+		{desc: "missing data line", raw: "*1\r\n$4\r\n"},
+		{desc: "odd element count", raw: "*2\r\n$3\r\nget\r\n$3\r\n"},
+		{desc: "fewer elements than declared", raw: "*3\r\n$3\r\nget\r\n$3\r\nkey\r\n"},
+		{desc: "more elements than declared", raw: "*1\r\n$3\r\nget\r\n$3\r\nkey\r\n"},
+		{desc: "huge declared count", raw: "*18446744073709551615\r\n$4\r\nping\r\n"},
+		{desc: "invalid array length", raw: "*x\r\n$4\r\nping\r\n"},
+		{desc: "data longer than bulk length", raw: "*1\r\n$3\r\nping\r\n"},
+		{desc: "missing bulk prefix", raw: "*1\r\n4\r\nping\r\n"},
+		{desc: "empty bulk header", raw: "*1\r\n\r\nping\r\n"},
+		{desc: "invalid bulk length", raw: "*1\r\n$x\r\nping\r\n"},
+		{desc: "negative bulk length", raw: "*1\r\n$-1\r\nping\r\n"},
+		{desc: "huge bulk length", raw: "*1\r\n$9223372036854775807\r\nping\r\n"},
+		{desc: "data shorter than bulk length", raw: "*1\r\n$6\r\nping\r\n"},
+		{desc: "bulk string without length", raw: "$"},
+		{desc: "bulk string shorter than length", raw: "$10\r\nping\r\n"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := DecodeMessage([]byte(tC.raw), nil)
+			if err == nil {
+				t.Fatalf("expected an error. got: %v", got.processed)
+			}
+
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("expected a protocol error. got: %v", err)
+			}
+		})
+	}
+
+	t.Run("truncated and corrupt frames should not panic", func(t *testing.T) {
+		frames := []string{
+			"*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n",
+			"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+			"$5\r\nhello\r\n",
+		}
+		for _, frame := range frames {
+			for i := 0; i <= len(frame); i++ {
+				DecodeMessage([]byte(frame[:i]), nil)
+			}
+
+			for i := 0; i < len(frame); i++ {
+				for _, c := range []byte{'*', '$', '\r', '\n', '-', '0', 'x'} {
+					corrupt := []byte(frame)
+					corrupt[i] = c
+					DecodeMessage(corrupt, nil)
+				}
+			}
+		}
+	})
+}
+
+func TestInlineDeserialization(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		raw       string
+		want      []string
+		wantError bool
+	}{
+		{desc: "single word", raw: "PING\r\n", want: []string{"PING"}},
+		{desc: "arguments", raw: "set Name John\r\n", want: []string{"set", "Name", "John"}},
+		{desc: "repeated white space", raw: "  get \t Name  \n", want: []string{"get", "Name"}},
+		{desc: "without line terminator", raw: "echo hi", want: []string{"echo", "hi"}},
+		{desc: "white space only", raw: "  \r\n", wantError: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := DecodeMessage([]byte(tC.raw), nil)
+			if tC.wantError {
+				if err == nil {
+					t.Errorf("expected an error. got: %v", got.processed)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if !reflect.DeepEqual(got.processed, tC.want) {
+				t.Errorf("got: %#v. want: %#v", got.processed, tC.want)
+			}
+		})
+	}
+}
+
+func TestInspectMessages(t *testing.T) {
+	data := "*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n" +
+		"*1\r\n$4\r\nping\r\n" +
+		"*2\r\n$3\r\nget\r\n$5\r\nName\r\n" +
+		"*2\r\n$4\r\necho\r\n$11\r\nhello world\r\n"
+
+	want := [][]string{
+		{"set", "Name", "John"},
+		{"ping"},
+		nil,
+		{"echo", "hello world"},
+	}
+
+	got := make([][]string, 0)
+	errCount := 0
+	err := InspectMessages(strings.NewReader(data), func(elements []string, err error) {
+		if err != nil {
+			errCount++
+		}
+		got = append(got, elements)
+	})
+	if err != nil {
+		t.Fatalf("Should not throw an error. err: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %#v. want: %#v", got, want)
+	}
+
+	if errCount != 1 {
+		t.Errorf("expected a single decoding error. got: %d", errCount)
+	}
+}
+
+func TestWriteBulkStringArray(t *testing.T) {
+	cases := []struct {
+		desc string
+		data []string
+	}{
+		{desc: "empty array", data: []string{}},
+		{desc: "array of strings", data: []string{"hello", "", "world"}},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			elements := make([]any, 0, len(c.data))
+			for _, v := range c.data {
+				elements = append(elements, v)
+			}
+			want := SerializeArray(elements)
+
+			var got strings.Builder
+			if err := WriteBulkStringArray(&got, c.data); err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if got.String() != want {
+				t.Errorf("got: %#v. want: %#v", got.String(), want)
+			}
+		})
+	}
+}
+
+func TestWriteScoredMembers(t *testing.T) {
+	members := []scoredMember{{member: "a", score: 1}, {member: "b", score: 2.5}}
+	cases := []struct {
+		desc       string
+		members    []scoredMember
+		withScores bool
+		want       []string
+	}{
+		{desc: "no members", members: []scoredMember{}, withScores: true, want: []string{}},
+		{desc: "members only", members: members, want: []string{"a", "b"}},
+		{desc: "members with scores", members: members, withScores: true, want: []string{"a", "1", "b", "2.5"}},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var want strings.Builder
+			if err := WriteBulkStringArray(&want, c.want); err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			var got strings.Builder
+			if err := writeScoredMembers(&got, c.members, c.withScores); err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if got.String() != want.String() {
+				t.Errorf("got: %#v. want: %#v", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	cases := []struct {
+		score float64
+		want  string
+	}{
+		{score: 3, want: "3"},
+		{score: -3, want: "-3"},
+		{score: 0, want: "0"},
+		{score: 3.14, want: "3.14"},
+		{score: 2.5, want: "2.5"},
+		{score: 0.0015, want: "0.0015"},
+		{score: 1000000, want: "1000000"},
+		{score: 1e21, want: "1e+21"},
+		{score: 1e-7, want: "1e-07"},
+		{score: math.Inf(1), want: "inf"},
+		{score: math.Inf(-1), want: "-inf"},
+		{score: math.NaN(), want: "nan"},
+	}
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			got := formatScore(c.score)
+			if got != c.want {
+				t.Errorf("got: %s. want: %s", got, c.want)
+			}
+		})
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	seeds := []string{
+		"$-1\r\n",
+		"$0\r\n\r\n",
+		"$5\r\nhello\r\n",
+		"*0\r\n",
+		"*1\r\n$4\r\nping\r\n",
+		"*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n",
+		"*2\r\n$4\r\necho\r\n$11\r\nhello world\r\n",
+		"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+		"*1\r\n$3\r\nping\r\n",
+		"*18446744073709551615\r\n$4\r\nping\r\n",
+		"PING\r\n",
+		"set Name John\r\n",
+		"\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		cmd, err := DecodeMessage(raw, nil)
+		if (cmd == nil) == (err == nil) {
+			t.Fatalf("expected either a command or an error. got: %v, %v", cmd, err)
+		}
+
+		if cmd != nil {
+			if len(cmd.processed) == 0 {
+				t.Fatalf("expected a decoded command to have elements. got: %q", raw)
+			}
+			cmd.Parse()
+		}
+
+		InspectMessages(bytes.NewReader(raw), func([]string, error) {})
+	})
+}
@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// defaultSubscriberBuffer is how many messages can wait to be written to a
+// subscriber when the configuration doesn't say otherwise.
+const defaultSubscriberBuffer = 1024
+
+// subscriber delivers the messages published to a connection in subscribe
+// mode. Publishers only enqueue messages to out, which a goroutine writes to
+// the connection, so a slow subscriber never blocks them.
+type subscriber struct {
+	conn   net.Conn
+	out    chan []byte
+	done   chan struct{}
+	drop   sync.Once
+	logger *slog.Logger
+}
+
+func newSubscriber(conn net.Conn, buffer int, logger *slog.Logger) *subscriber {
+	s := &subscriber{
+		conn:   conn,
+		out:    make(chan []byte, buffer),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	go s.write()
+	return s
+}
+
+func (s *subscriber) write() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.out:
+			if _, err := s.conn.Write(msg); err != nil {
+				s.logger.Error(fmt.Sprintf("failed to write to subscriber %s: %v", s.conn.RemoteAddr(), err))
+				s.disconnect()
+				return
+			}
+		}
+	}
+}
+
+// send enqueues msg without blocking. A subscriber whose buffer is full can't
+// keep up with the publishers, so it is disconnected the way the
+// client-output-buffer-limit of redis does, and msg is dropped.
+func (s *subscriber) send(msg []byte) bool {
+	select {
+	case <-s.done:
+		return false
+	case s.out <- msg:
+		return true
+	default:
+		s.logger.Warn(fmt.Sprintf("subscriber %s output buffer is full. Disconnecting it", s.conn.RemoteAddr()))
+		s.disconnect()
+		return false
+	}
+}
+
+// disconnect closes the connection, which makes its handler remove the
+// client and stop the subscriber.
+func (s *subscriber) disconnect() {
+	s.drop.Do(func() { s.conn.Close() })
+}
+
+// stop ends the goroutine writing to the connection. It must be called once.
+func (s *subscriber) stop() {
+	close(s.done)
+}
@@ -1,7 +1,10 @@
 package redis
 
 import (
+	"net"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -343,3 +346,490 @@ func TestPublishCommandToMultipleSubscriber(t *testing.T) {
 		t.Errorf("got: %#v. want: %#v", string(got3), string(wantSub3))
 	}
 }
+
+func TestPublishCommandWithPerConnectionProcessing(t *testing.T) {
+	now := time.Now()
+	tC := pubsubTestCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	app.config.Store(&ApplicationConfiguration{ProcessPerConnection: true})
+	go func() { Listen(srv, app, logger) }()
+
+	subs := make([]net.Conn, 0)
+	for i := 0; i < 5; i++ {
+		conn := makeRequestToServer("*2\r\n$9\r\nsubscribe\r\n$4\r\ntest\r\n", srv, t)
+		defer conn.Close()
+
+		_, err := conn.Read(make([]byte, 4096))
+		if err != nil {
+			t.Fatalf("failed to read from subscriber connection: %s", err)
+		}
+		subs = append(subs, conn)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pubConn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("could not establish connection: %v", err)
+				return
+			}
+			defer pubConn.Close()
+
+			_, err = pubConn.Write([]byte("*3\r\n$7\r\npublish\r\n$4\r\ntest\r\n$5\r\nhello\r\n"))
+			if err != nil {
+				t.Errorf("failed to write publish. err: %v", err)
+				return
+			}
+
+			buf := make([]byte, 4096)
+			n, err := pubConn.Read(buf)
+			if err != nil {
+				t.Errorf("failed to read from publisher connection: %s", err)
+				return
+			}
+
+			if got := string(buf[:n]); got != ":5\r\n" {
+				t.Errorf("got from publisher connection: %#v. want: %#v", got, ":5\r\n")
+			}
+		}()
+	}
+	wg.Wait()
+
+	message := "*3\r\n$7\r\nmessage\r\n$4\r\ntest\r\n$5\r\nhello\r\n"
+	want := strings.Repeat(message, 5)
+	for _, conn := range subs {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		got := ""
+		buf := make([]byte, 4096)
+		for len(got) < len(want) {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read publications from subscriber connection: %s", err)
+			}
+			got += string(buf[:n])
+		}
+
+		if got != want {
+			t.Errorf("got: %#v. want: %#v", got, want)
+		}
+	}
+}
+
+func TestSubscribeConfirmationPrecedesMessages(t *testing.T) {
+	now := time.Now()
+	tC := pubsubTestCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	app.config.Store(&ApplicationConfiguration{ProcessPerConnection: true})
+	go func() { Listen(srv, app, logger) }()
+
+	channels := []string{"ch0", "ch1", "ch2", "ch3", "ch4", "ch5", "ch6", "ch7"}
+	confirmation := ""
+	subscribe := []any{"subscribe"}
+	for i, ch := range channels {
+		subscribe = append(subscribe, ch)
+		confirmation += SerializeArray([]any{"subscribe", ch, i + 1})
+	}
+	request := SerializeArray(subscribe)
+
+	// keep publishing to all the channels while the subscribers come in
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pubConn, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Errorf("could not establish connection: %v", err)
+			return
+		}
+		defer pubConn.Close()
+
+		buf := make([]byte, 4096)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			ch := channels[i%len(channels)]
+			if _, err := pubConn.Write([]byte(SerializeArray([]any{"publish", ch, "hello"}))); err != nil {
+				t.Errorf("failed to write publish. err: %v", err)
+				return
+			}
+
+			if _, err := pubConn.Read(buf); err != nil {
+				t.Errorf("failed to read from publisher connection: %s", err)
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	for i := 0; i < 20; i++ {
+		conn := makeRequestToServer(request, srv, t)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		got := ""
+		buf := make([]byte, 4096)
+		for len(got) < len(confirmation) {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read from subscriber connection: %s", err)
+			}
+			got += string(buf[:n])
+		}
+		conn.Close()
+
+		if !strings.HasPrefix(got, confirmation) {
+			t.Fatalf("expected confirmation before any message. got: %#v", got)
+		}
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	now := time.Now()
+	tC := pubsubTestCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config := &ApplicationConfiguration{}
+	if err := config.SetNotifyKeyspaceEvents("KEg"); err != nil {
+		t.Fatalf("failed to set notify-keyspace-events: %v", err)
+	}
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	eventSub := makeRequestToServer("*2\r\n$9\r\nsubscribe\r\n$18\r\n__keyevent@0__:del\r\n", srv, t)
+	defer eventSub.Close()
+
+	keySub := makeRequestToServer("*2\r\n$9\r\nsubscribe\r\n$19\r\n__keyspace@0__:Name\r\n", srv, t)
+	defer keySub.Close()
+
+	_, err1 := eventSub.Read(make([]byte, 4096))
+	_, err2 := keySub.Read(make([]byte, 4096))
+	if err1 != nil || err2 != nil {
+		t.Fatal("failed to read from subscribers connections")
+	}
+
+	conn := makeRequestToServer("*2\r\n$3\r\ndel\r\n$4\r\nName\r\n", srv, t)
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read from connection: %s", err)
+	}
+	if got := string(buf[:n]); got != ":1\r\n" {
+		t.Fatalf("got: %#v. want: %#v", got, ":1\r\n")
+	}
+
+	n, err = eventSub.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read event from subscriber connection: %s", err)
+	}
+
+	want := "*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$4\r\nName\r\n"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got: %#v. want: %#v", got, want)
+	}
+
+	n, err = keySub.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read event from subscriber connection: %s", err)
+	}
+
+	want = "*3\r\n$7\r\nmessage\r\n$19\r\n__keyspace@0__:Name\r\n$3\r\ndel\r\n"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got: %#v. want: %#v", got, want)
+	}
+}
+
+func TestDisconnectedSubscriberIsRemoved(t *testing.T) {
+	tC := pubsubTestCase{
+		now:  time.Now(),
+		data: "*2\r\n$9\r\nsubscribe\r\n$4\r\ntest\r\n",
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn := makeRequestToServer(tC.data, srv, t)
+	buf := make([]byte, 4096)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("failed to read from connection: %s", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		app.state.mutex.RLock()
+		clients := len(app.clients)
+		app.state.mutex.RUnlock()
+
+		app.pubsubMutex.RLock()
+		channels := len(app.pubsubChannels)
+		app.pubsubMutex.RUnlock()
+
+		if clients == 0 && channels == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected client and its channels to be removed. clients: %d. channels: %d", clients, channels)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	app := NewApplication(&ApplicationConfiguration{SubscriberBuffer: 2}, TestClockTimer{mockNow: time.Now()}, NewTestLogger())
+
+	// writes to a pipe block until the other end reads, which this
+	// subscriber never does
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	if err := app.SubscribeConnection([]string{"news"}, serverSide, []byte("confirmation")); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	published := make(chan []int)
+	go func() {
+		receivers := make([]int, 0)
+		for i := 0; i < 10; i++ {
+			receivers = append(receivers, app.Publish("news", "hello", nil))
+		}
+		published <- receivers
+	}()
+
+	select {
+	case receivers := <-published:
+		// the confirmation may or may not have left the buffer yet, but the
+		// buffer can't hold more than 2 messages before the drop
+		if receivers[0] != 1 || receivers[len(receivers)-1] != 0 {
+			t.Errorf("expected the first messages to be queued and the last ones dropped. got: %v", receivers)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publisher was blocked by the slow subscriber")
+	}
+
+	// the confirmation can still be read, then the connection is closed
+	buf := make([]byte, 4096)
+	clientSide.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, err := clientSide.Read(buf)
+		if err == nil {
+			continue
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("expected the slow subscriber to be disconnected")
+		}
+		break
+	}
+}
+
+func TestSlowSubscriberDoesNotBlockPublisher(t *testing.T) {
+	tC := pubsubTestCase{
+		now:  time.Now(),
+		data: "*2\r\n$9\r\nsubscribe\r\n$4\r\ntest\r\n",
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	app.config.Store(&ApplicationConfiguration{SubscriberBuffer: 1})
+	go func() { Listen(srv, app, logger) }()
+
+	// the subscriber never reads past the confirmation
+	conn := makeRequestToServer(tC.data, srv, t)
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("failed to read from subscriber connection: %s", err)
+	}
+
+	pubConn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer pubConn.Close()
+
+	// enough data to fill the socket buffers between server and subscriber
+	message := strings.Repeat("x", 3000)
+	request := "*3\r\n$7\r\npublish\r\n$4\r\ntest\r\n$3000\r\n" + message + "\r\n"
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pubConn.SetDeadline(time.Now().Add(2 * time.Second))
+		got := writeAndRead(t, pubConn, request)
+		if got == ":0\r\n" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the slow subscriber to be dropped")
+		}
+	}
+
+	app.pubsubMutex.RLock()
+	subscribers := len(app.subscribers)
+	app.pubsubMutex.RUnlock()
+	for subscribers != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		app.pubsubMutex.RLock()
+		subscribers = len(app.subscribers)
+		app.pubsubMutex.RUnlock()
+	}
+
+	if subscribers != 0 {
+		t.Errorf("expected the slow subscriber to be removed. got %d subscribers", subscribers)
+	}
+}
+
+func TestPubSubNumSub(t *testing.T) {
+	tC := pubsubTestCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	subscribers := make([]net.Conn, 0, 3)
+	for _, data := range []string{
+		// subscribing twice to a channel still counts once
+		encodeRequest(t, "subscribe", "news", "news"),
+		encodeRequest(t, "subscribe", "news", "sports"),
+		encodeRequest(t, "subscribe", "news"),
+	} {
+		conn := makeRequestToServer(data, srv, t)
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+		subscribers = append(subscribers, conn)
+	}
+
+	conn := makeRequestToServer("", srv, t)
+	defer conn.Close()
+
+	numSub := encodeRequest(t, "pubsub", "numsub", "news", "sports", "other")
+	want := "*6\r\n$4\r\nnews\r\n:3\r\n$6\r\nsports\r\n:1\r\n$5\r\nother\r\n:0\r\n"
+	if got := writeAndRead(t, conn, numSub); got != want {
+		t.Fatalf("got: %#v. want: %#v", got, want)
+	}
+
+	subscribers[1].Close()
+
+	want = "*6\r\n$4\r\nnews\r\n:2\r\n$6\r\nsports\r\n:0\r\n$5\r\nother\r\n:0\r\n"
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := writeAndRead(t, conn, numSub)
+		if got == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected disconnected subscriber to be removed. got: %#v. want: %#v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientsFromTheSameHost(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	first := makeRequestToServer("*2\r\n$9\r\nsubscribe\r\n$5\r\nfirst\r\n", srv, t)
+	defer first.Close()
+	second := makeRequestToServer("*2\r\n$9\r\nsubscribe\r\n$6\r\nsecond\r\n", srv, t)
+	defer second.Close()
+
+	buf := make([]byte, 4096)
+	for _, conn := range []net.Conn{first, second} {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("failed to read from subscriber connection: %s", err)
+		}
+	}
+
+	firstHost, _, _ := net.SplitHostPort(first.LocalAddr().String())
+	secondHost, _, _ := net.SplitHostPort(second.LocalAddr().String())
+	if firstHost != secondHost {
+		t.Fatalf("expected both connections to come from the same host. got: %s and %s", firstHost, secondHost)
+	}
+
+	wantSubscriptions := map[string]string{
+		first.LocalAddr().String():  "first",
+		second.LocalAddr().String(): "second",
+	}
+	app.state.mutex.RLock()
+	for addr, channel := range wantSubscriptions {
+		client, ok := app.clients[addr]
+		if !ok {
+			t.Errorf("client %s is not tracked", addr)
+			continue
+		}
+		if !reflect.DeepEqual(client.subscribedTo, map[string]bool{channel: true}) {
+			t.Errorf("got subscriptions of %s: %v. want: [%s]", addr, client.subscribedTo, channel)
+		}
+	}
+	app.state.mutex.RUnlock()
+
+	for _, channel := range []string{"first", "second"} {
+		if got := app.NumSubscribers(channel); got != 1 {
+			t.Errorf("got %d subscribers of %s. want: 1", got, channel)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"cmp"
+	"slices"
 	"sort"
 )
 
@@ -144,22 +145,24 @@ func (t *rbtree[k, v]) Put(key k, val v) {
 			}
 		}
 
+		if p != nil {
+			// the key already has a node, which keeps its place and color
+			p.value.entries = append(p.value.entries, val)
+			sort.Sort(p.value)
+			t.size++
+			return
+		}
+
 		newNode = &node[k, v]{
 			key:    key,
 			value:  nodevalue[v]{entries: []v{val}},
 			parent: y,
 			color:  RED,
 		}
-		if y != nil {
-			if key > y.key {
-				y.right = newNode
-			} else if key < y.key {
-				y.left = newNode
-			} else {
-				y.value.entries = append(y.value.entries, val)
-				sort.Sort(y.value)
-				y.color = RED
-			}
+		if key > y.key {
+			y.right = newNode
+		} else {
+			y.left = newNode
 		}
 	}
 
@@ -248,6 +251,29 @@ func (t *rbtree[k, v]) Remove(key k) {
 	t.remove(n)
 }
 
+// RemoveValue removes val from the node of key, and the node itself when val
+// was its last value. It reports whether val was found.
+func (t *rbtree[k, v]) RemoveValue(key k, val v) bool {
+	n := t.get(key)
+	if n == nil {
+		return false
+	}
+
+	i, found := slices.BinarySearch(n.value.entries, val)
+	if !found {
+		return false
+	}
+
+	if len(n.value.entries) == 1 {
+		t.remove(n)
+		return true
+	}
+
+	n.value.entries = slices.Delete(n.value.entries, i, i+1)
+	t.size--
+	return true
+}
+
 func (t *rbtree[k, v]) remove(n *node[k, v]) {
 	if n == nil {
 		return
@@ -390,6 +416,24 @@ func (t rbtree[k, v]) inOrderTraversal(n *node[k, v], visitor func(k, []v)) {
 	t.inOrderTraversal(n.right, visitor)
 }
 
+// Walk visits the nodes in order, or in reverse order when reverse is set,
+// until visitor returns false. It reports whether every node was visited.
+func (t rbtree[k, v]) Walk(reverse bool, visitor func(k, []v) bool) bool {
+	return t.walk(t.root, reverse, visitor)
+}
+
+func (t rbtree[k, v]) walk(n *node[k, v], reverse bool, visitor func(k, []v) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	first, last := n.left, n.right
+	if reverse {
+		first, last = last, first
+	}
+	return t.walk(first, reverse, visitor) && visitor(n.key, n.value.entries) && t.walk(last, reverse, visitor)
+}
+
 func (t rbtree[k, v]) PreOrderTraversal(visitor func(k, []v)) {
 	t.preOrderTraversal(t.root, visitor)
 }
@@ -390,3 +390,77 @@ func BenchmarkDeletion(b *testing.B) {
 		})
 	}
 }
+
+func TestRemoveValue(t *testing.T) {
+	tree := NewTree[int, string]()
+	tree.Put(50, "a")
+	tree.Put(25, "b")
+	tree.Put(75, "c")
+	tree.Put(25, "d")
+	tree.Put(10, "e")
+
+	if tree.RemoveValue(25, "x") {
+		t.Errorf("removed a value that is not in the node")
+	}
+	if tree.RemoveValue(30, "b") {
+		t.Errorf("removed a value from a missing node")
+	}
+
+	if !tree.RemoveValue(25, "b") {
+		t.Fatalf("could not remove value from node with two values")
+	}
+	if got := tree.Get(25); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Errorf("got node values %v | want [d]", got)
+	}
+
+	if !tree.RemoveValue(25, "d") {
+		t.Fatalf("could not remove the last value of the node")
+	}
+
+	wantSize := int64(3)
+	if gotSize := tree.Size(); gotSize != wantSize {
+		t.Fatalf("got %d - want %d", gotSize, wantSize)
+	}
+
+	want := []int{10, 50, 75}
+	if got := tree.GetKeySet(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got keyset %v | want keyset %v", got, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree := NewTree[int, string]()
+	for _, k := range []int{50, 25, 75, 10, 30, 60, 90} {
+		tree.Put(k, fmt.Sprint(k))
+	}
+
+	walked := func(reverse bool, limit int) ([]int, bool) {
+		keys := make([]int, 0)
+		all := tree.Walk(reverse, func(k int, _ []string) bool {
+			keys = append(keys, k)
+			return len(keys) < limit
+		})
+		return keys, all
+	}
+
+	testCases := []struct {
+		desc    string
+		reverse bool
+		limit   int
+		want    []int
+		wantAll bool
+	}{
+		{desc: "in order", limit: 10, want: []int{10, 25, 30, 50, 60, 75, 90}, wantAll: true},
+		{desc: "in reverse order", reverse: true, limit: 10, want: []int{90, 75, 60, 50, 30, 25, 10}, wantAll: true},
+		{desc: "stopped early", limit: 3, want: []int{10, 25, 30}},
+		{desc: "stopped early in reverse order", reverse: true, limit: 3, want: []int{90, 75, 60}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, all := walked(tC.reverse, tC.limit)
+			if !reflect.DeepEqual(got, tC.want) || all != tC.wantAll {
+				t.Errorf("got %v, %t | want %v, %t", got, all, tC.want, tC.wantAll)
+			}
+		})
+	}
+}
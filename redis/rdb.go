@@ -0,0 +1,303 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// SnapshotFormat selects how snapshots are saved. Loading tells the formats
+// apart on its own, so a snapshot saved in either format can be loaded
+// whatever the configured one is.
+type SnapshotFormat string
+
+const (
+	// SnapshotRESP saves the snapshot as the RESP commands that recreate the
+	// dataset. It is the default, since it can be read and edited by hand.
+	SnapshotRESP SnapshotFormat = "resp"
+
+	// SnapshotBinary saves the snapshot in a compact binary format, which is
+	// smaller and faster to load.
+	SnapshotBinary SnapshotFormat = "binary"
+)
+
+// binarySnapshotMagic starts every snapshot in the binary format.
+var binarySnapshotMagic = []byte("REDISGO\x01")
+
+// The binary format is the magic followed by one entry per key and an EOF
+// byte. Each entry is a type byte, the key, an expiry flag byte followed by
+// the varint expiry in unix milliseconds when the flag is 1, and the value.
+// Strings, keys and members are a uvarint length followed by their bytes,
+// lists and sorted sets a uvarint count followed by their elements, and
+// scores the IEEE 754 bits of the float in little endian, so snapshots
+// don't depend on the byte order of the machine that saved them.
+const (
+	binaryString    byte = 1
+	binaryList      byte = 2
+	binarySortedSet byte = 3
+	binaryEOF       byte = 0xff
+)
+
+var errBinaryTruncated = errors.New("truncated binary entry")
+
+// writeBinary writes the snapshot of the dataset to out in the binary format,
+// without counting it as a save. It returns the count of changes the snapshot
+// holds.
+func (as *ApplicationState) writeBinary(out io.Writer) (int, error) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	ks := &as.keyspace
+	w := bufio.NewWriter(out)
+	w.Write(binarySnapshotMagic)
+
+	// keys are sorted so snapshots of the same state are always identical
+	keys := GetKeys(ks.keys, func(keyspaceEntry) bool { return true })
+	slices.Sort(keys)
+
+	var buf []byte
+	for _, key := range keys {
+		e := ks.keys[key]
+
+		buf = buf[:0]
+		switch e.group {
+		default:
+			continue
+
+		case "string":
+			buf = append(buf, binaryString)
+			buf = appendBinaryHeader(buf, key, e)
+			buf = appendBinaryString(buf, ks.stringMap[key].Bytes())
+
+		case "list":
+			v := ks.listMap[key]
+			if v.size == 0 {
+				continue
+			}
+
+			buf = append(buf, binaryList)
+			buf = appendBinaryHeader(buf, key, e)
+			buf = binary.AppendUvarint(buf, uint64(v.size))
+			for n := v.head; n != nil; n = n.next {
+				buf = appendBinaryString(buf, []byte(n.value))
+			}
+
+		case "sorted-set":
+			v := ks.sortedSetMap[key]
+			if v.Len() == 0 {
+				continue
+			}
+
+			buf = append(buf, binarySortedSet)
+			buf = appendBinaryHeader(buf, key, e)
+			buf = binary.AppendUvarint(buf, uint64(v.Len()))
+			v.tree.InOrderTraversal(func(score float64, members []string) {
+				for _, m := range members {
+					buf = appendBinaryString(buf, []byte(m))
+					buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(score))
+				}
+			})
+		}
+
+		w.Write(buf)
+	}
+
+	w.WriteByte(binaryEOF)
+	return ks.modifications, w.Flush()
+}
+
+func appendBinaryHeader(buf []byte, key string, e keyspaceEntry) []byte {
+	buf = appendBinaryString(buf, []byte(key))
+	if e.expires == nil {
+		return append(buf, 0)
+	}
+
+	buf = append(buf, 1)
+	return binary.AppendVarint(buf, e.expires.UnixMilli())
+}
+
+func appendBinaryString(buf []byte, s []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// isBinarySnapshot reports whether the snapshot read by r is in the binary
+// format, without consuming any of it.
+func isBinarySnapshot(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(binarySnapshotMagic))
+	return err == nil && bytes.Equal(magic, binarySnapshotMagic)
+}
+
+// loadBinary loads the snapshot in the binary format read from r, magic
+// included, straight into the keyspace. Keys that already expired are
+// skipped.
+func (as *ApplicationState) loadBinary(r *bufio.Reader, a *Application) error {
+	br := &binaryReader{r: r}
+	if _, err := br.readN(len(binarySnapshotMagic)); err != nil {
+		return err
+	}
+
+	for {
+		start := br.n
+		err := as.loadBinaryEntry(br)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errBinaryTruncated) {
+			return a.truncatedSnapshot(br.n - start)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// loadBinaryEntry loads the next entry of br. It returns io.EOF once the EOF
+// byte is read.
+func (as *ApplicationState) loadBinaryEntry(br *binaryReader) error {
+	kind, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if kind == binaryEOF {
+		return io.EOF
+	}
+
+	key, err := br.readString()
+	if err != nil {
+		return err
+	}
+
+	e := keyspaceEntry{}
+	flag, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if flag == 1 {
+		ms, err := binary.ReadVarint(br)
+		if err != nil {
+			return err
+		}
+		expires := time.UnixMilli(ms)
+		e.expires = &expires
+	}
+
+	var restore func(ks *keyspace)
+	switch kind {
+	default:
+		return fmt.Errorf("unknown entry type %d of key '%s'", kind, key)
+
+	case binaryString:
+		e.group = "string"
+		value, err := br.readString()
+		if err != nil {
+			return err
+		}
+		restore = func(ks *keyspace) { ks.stringMap[key] = newStringValue([]byte(value)) }
+
+	case binaryList:
+		e.group = "list"
+		count, err := br.readUvarint()
+		if err != nil {
+			return err
+		}
+
+		l := list{}
+		for i := uint64(0); i < count; i++ {
+			value, err := br.readString()
+			if err != nil {
+				return err
+			}
+			l.AppendToTail(value)
+		}
+		restore = func(ks *keyspace) { ks.listMap[key] = l }
+
+	case binarySortedSet:
+		e.group = "sorted-set"
+		count, err := br.readUvarint()
+		if err != nil {
+			return err
+		}
+
+		setVal := newSortedSet()
+		for i := uint64(0); i < count; i++ {
+			member, err := br.readString()
+			if err != nil {
+				return err
+			}
+			bits, err := br.readN(8)
+			if err != nil {
+				return err
+			}
+			setVal.Put(member, math.Float64frombits(binary.LittleEndian.Uint64(bits)))
+		}
+		restore = func(ks *keyspace) { ks.sortedSetMap[key] = setVal }
+	}
+
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	ks := &as.keyspace
+	if CheckIsExpired(ks.clock, e) {
+		return nil
+	}
+
+	if old, ok := ks.keys[key]; ok {
+		ks.deleteValue(key, old.group)
+	}
+	restore(ks)
+	ks.setEntry(key, e)
+	return nil
+}
+
+// binaryReader reads the fields of the binary format, counting the bytes
+// read. Running out of data in the middle of a field is errBinaryTruncated.
+type binaryReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (br *binaryReader) ReadByte() (byte, error) {
+	b, err := br.r.ReadByte()
+	if err != nil {
+		return 0, errBinaryTruncated
+	}
+	br.n++
+	return b, nil
+}
+
+func (br *binaryReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(br)
+}
+
+func (br *binaryReader) readN(n int) ([]byte, error) {
+	b := make([]byte, n)
+	read, err := io.ReadFull(br.r, b)
+	br.n += read
+	if err != nil {
+		return nil, errBinaryTruncated
+	}
+	return b, nil
+}
+
+func (br *binaryReader) readString() (string, error) {
+	length, err := br.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	if length > math.MaxInt32 {
+		return "", fmt.Errorf("string length %d is too long", length)
+	}
+
+	b, err := br.readN(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
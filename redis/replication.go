@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const replicationDialTimeout = 5 * time.Second
+
+// replication tracks the master this server was told to replicate from. Only
+// the handshake is performed: the replica connects, sends PING, REPLCONF and
+// PSYNC and logs the replies, but it never applies the master's data.
+type replication struct {
+	mutex  sync.Mutex
+	master string
+	cancel context.CancelFunc
+
+	// handshake is closed once the handshake with the current master finishes.
+	// err holds the reason it failed, if it did.
+	handshake chan struct{}
+	err       error
+}
+
+func newReplication() *replication {
+	return &replication{}
+}
+
+// Master returns the address of the master, or an empty string when this
+// server is not a replica.
+func (r *replication) Master() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.master
+}
+
+// HandshakeDone returns a channel closed once the handshake with the current
+// master finishes. It is nil when this server is not a replica.
+func (r *replication) HandshakeDone() <-chan struct{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.handshake
+}
+
+// HandshakeErr returns why the last finished handshake failed, if it did.
+func (r *replication) HandshakeErr() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.err
+}
+
+// ReplicateFrom drops the link to the current master, if any, and starts the
+// handshake with the master at addr in the background. An empty addr turns
+// this server back into a master.
+func (r *replication) ReplicateFrom(addr string, app *Application) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+
+	r.master = addr
+	r.handshake = nil
+	r.err = nil
+	if addr == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r.cancel = cancel
+	r.handshake = done
+
+	go func() {
+		err := handshakeWithMaster(ctx, addr, app)
+		if err != nil {
+			app.logger.Error(fmt.Sprintf("replication handshake with %s failed: %v", addr, err))
+		}
+
+		r.mutex.Lock()
+		if r.handshake == done {
+			r.err = err
+		}
+		r.mutex.Unlock()
+		close(done)
+	}()
+}
+
+func handshakeWithMaster(ctx context.Context, addr string, app *Application) error {
+	dialer := net.Dialer{Timeout: replicationDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	// the link stays open until this server stops replicating from addr
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	steps := [][]string{
+		{"PING"},
+		{"REPLCONF", "capa", "eof", "capa", "psync2"},
+		{"PSYNC", "?", "-1"},
+	}
+	for i, step := range steps {
+		if err := WriteBulkStringArray(conn, step); err != nil {
+			return err
+		}
+
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		reply = strings.TrimRight(reply, "\r\n")
+		app.logger.Info(fmt.Sprintf("replication handshake with %s. sent: %s. got: %s", addr, step[0], reply))
+
+		// the master is unusable if it does not answer PING. The remaining
+		// steps are only logged, since this server can't sync anyway.
+		if i == 0 && reply != "+PONG" {
+			return fmt.Errorf("unexpected reply to PING: '%s'", reply)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// scriptDeniedCommands can't run inside an EVAL script: they either write to
+// other connections, block or would wait on the script itself.
+var scriptDeniedCommands = map[Command]bool{
+	EVAL:      true,
+	SUBSCRIBE: true,
+	PUBLISH:   true,
+	DEBUG:     true,
+	REPLICAOF: true,
+	MIGRATE:   true,
+}
+
+// parseScript turns an EVAL script into the commands it runs. A script is not
+// Lua but a newline separated list of this server's commands, one per line,
+// with their arguments separated by spaces. Blank lines are skipped.
+//
+// An argument that is exactly KEYS[n] or ARGV[n] is replaced by the n-th key
+// or argument given to EVAL, counting from 1. Placeholders are only replaced
+// as whole arguments, so 'KEYS[1]:suffix' is passed as is.
+func parseScript(script string, keys []string, argv []string) ([][]string, error) {
+	commands := make([][]string, 0)
+	for _, line := range strings.Split(script, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		for i, field := range fields {
+			bound, err := bindScriptArgument(field, keys, argv)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = bound
+		}
+		commands = append(commands, fields)
+	}
+
+	if len(commands) == 0 {
+		return nil, errors.New("empty script")
+	}
+
+	return commands, nil
+}
+
+func bindScriptArgument(field string, keys []string, argv []string) (string, error) {
+	var name string
+	var values []string
+	switch {
+	default:
+		return field, nil
+	case strings.HasPrefix(field, "KEYS[") && strings.HasSuffix(field, "]"):
+		name, values = "KEYS", keys
+	case strings.HasPrefix(field, "ARGV[") && strings.HasSuffix(field, "]"):
+		name, values = "ARGV", argv
+	}
+
+	n, err := strconv.Atoi(field[len(name)+1 : len(field)-1])
+	if err != nil {
+		return field, nil
+	}
+
+	if n < 1 || n > len(values) {
+		return "", fmt.Errorf("script references %s but %s has %d elements", field, name, len(values))
+	}
+
+	return values[n-1], nil
+}
+
+// processEval runs the commands of the script in order and replies with the
+// reply of the last one. No other command runs while the script does, see
+// Cmd.Process. The script stops at the first command that fails and replies
+// with its error, the commands run before it are not undone.
+func processEval(ctx context.Context, args []string, sender net.Conn, app *Application) (string, error) {
+	script := args[0]
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 {
+		return "", errors.New("Number of keys can't be negative or not an integer")
+	}
+
+	bindings := args[2:]
+	if numKeys > len(bindings) {
+		return "", errors.New("Number of keys can't be greater than number of args")
+	}
+
+	commands, err := parseScript(script, bindings[:numKeys], bindings[numKeys:])
+	if err != nil {
+		return "", err
+	}
+
+	var reply string
+	for _, processed := range commands {
+		cmd := Cmd{app: app, processed: processed, sender: sender, inScript: true}
+		if err := cmd.Parse(); err != nil {
+			return "", err
+		}
+		if scriptDeniedCommands[cmd.cmd] {
+			return "", fmt.Errorf("command '%s' is not allowed from scripts", strings.ToLower(processed[0]))
+		}
+
+		result := cmd.Process(ctx)
+		reply = string(result.message)
+		if result.stream != nil {
+			var buf bytes.Buffer
+			if err := result.stream(&buf); err != nil {
+				return "", err
+			}
+			reply = buf.String()
+		}
+
+		if strings.HasPrefix(reply, "-") {
+			return reply, nil
+		}
+	}
+
+	return reply, nil
+}
@@ -2,46 +2,97 @@ package redis
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log/slog"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// defaultTCPKeepAlive is the tcp-keepalive default of redis.
+const defaultTCPKeepAlive = 300 * time.Second
+
 // Creates a net.Listener on success. You are responsible for closing
 // this Listener.
 func NewServer(host string, port int, l *slog.Logger) (net.Listener, error) {
-	p := fmt.Sprintf("%04d", port)
-	server, err := net.Listen("tcp", host+":"+p)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	server, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	l.Info("Initialized server " + host + ":" + p)
+	l.Info("Initialized server " + addr)
 	return server, err
 }
 
+// setSocketOptions applies the TCP options of config to conn. Connections
+// that are not TCP, and every connection when there is no config, keep the
+// options they were created with.
+func setSocketOptions(conn net.Conn, config *ApplicationConfiguration) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || config == nil {
+		return nil
+	}
+
+	if err := tcp.SetNoDelay(config.TCPNoDelay); err != nil {
+		return err
+	}
+
+	if config.TCPKeepAlive <= 0 {
+		return tcp.SetKeepAlive(false)
+	}
+
+	if err := tcp.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcp.SetKeepAlivePeriod(config.TCPKeepAlive)
+}
+
 type ConnectionHandler func(Message) ([]byte, error)
 
 func Listen(server net.Listener, app *Application, l *slog.Logger) {
+	config := app.configuration()
 	messenger := &messenger{
-		app:  app,
-		in:   make(chan Message),
-		done: make(chan struct{}),
+		app:           app,
+		done:          make(chan struct{}),
+		perConnection: config != nil && config.ProcessPerConnection,
+	}
+	if !messenger.perConnection {
+		workers := 1
+		if config != nil && config.Workers > 1 {
+			workers = config.Workers
+		}
+
+		messenger.in = make([]chan Message, workers)
+		for i := range messenger.in {
+			messenger.in[i] = make(chan Message)
+			go messenger.handleRequests(messenger.in[i])
+		}
 	}
-	go messenger.handleRequests()
 
 	for {
 		conn, err := server.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			l.Error("failed to accept connection")
 			continue
 		}
 
+		if err := setSocketOptions(conn, app.configuration()); err != nil {
+			l.Warn(fmt.Sprintf("failed to set socket options of %s: %v", conn.RemoteAddr(), err))
+		}
+
 		err = app.AddClient(conn, true)
 		if err != nil {
 			l.Error(fmt.Sprintf("failed to add client connection: %v", err))
-			conn.Write([]byte(SerializeSimpleError(err.Error())))
+			conn.Write([]byte(SerializeError(err)))
 			continue
 		}
 
@@ -49,67 +100,282 @@ func Listen(server net.Listener, app *Application, l *slog.Logger) {
 	}
 }
 
-var errorResponse []byte = []byte("-couldn't process request\r\n")
-
+// messenger routes requests to the application. By default every request
+// goes through one of the in channels, each drained by its own worker
+// goroutine. A connection always sends to the same worker, so its commands
+// run in order; with a single worker the commands of all clients are
+// serialized. With perConnection set, each connection processes its own
+// requests in its own goroutine instead. Either way, shared state relies on
+// the application locks.
 type messenger struct {
-	app  *Application
-	in   chan Message
-	done chan struct{}
+	app           *Application
+	in            []chan Message
+	done          chan struct{}
+	perConnection bool
+}
+
+// workerFor returns the channel of the worker that processes the requests of
+// conn.
+func (m *messenger) workerFor(conn net.Conn) chan Message {
+	if len(m.in) == 1 {
+		return m.in[0]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(conn.RemoteAddr().String()))
+	return m.in[h.Sum32()%uint32(len(m.in))]
 }
 
 func (m *messenger) Cancel() func() {
 	return func() { close(m.done) }
 }
 
-func (messenger *messenger) handleRequests() {
-	l := messenger.app.logger
+func (messenger *messenger) handleRequests(in chan Message) {
 	for {
 		select {
 		case <-messenger.done:
-			break
-		case m := <-messenger.in:
-			response, err := messenger.app.ProcessRequest(m)
-			if err != nil {
-				l.Error(fmt.Sprintf("%v", err))
-
-				_, err = m.conn.Write([]byte(SerializeSimpleError(err.Error())))
-				if err != nil {
-					l.Error(fmt.Sprintf("%v", err))
-				}
-				continue
-			}
+			return
+		case m := <-in:
+			messenger.handle(m)
+		}
+	}
+}
 
-			if response == nil {
-				l.Error("got nil response struct")
-				continue
-			}
+// repliesOnItsOwn holds the commands that write their reply to the
+// connection themselves, instead of returning it.
+var repliesOnItsOwn = map[Command]bool{
+	SUBSCRIBE: true,
+}
 
-			for _, c := range response.targets {
-				if c == nil {
-					l.Error("got a nil connection object")
-					continue
-				}
-				_, err = c.Write(response.message)
-				if err != nil {
-					l.Error("failed to write error response")
+// handle processes every command of m in order. The replies to the sender
+// are buffered and written together once all of them are processed, so a
+// pipeline of commands read at once gets its replies in a single write.
+func (messenger *messenger) handle(m Message) {
+	l := messenger.app.logger
+
+	w := bufio.NewWriterSize(m.conn, replyChunkSize)
+	raw := m.raw
+	for len(raw) > 0 {
+		advance, frame, _ := splitCommands(raw, true)
+		raw = raw[advance:]
+		messenger.handleCommand(Message{ctx: m.ctx, raw: frame, conn: m.conn}, w)
+	}
+
+	if err := w.Flush(); err != nil {
+		l.Error(fmt.Sprintf("failed to write replies: %v", err))
+	}
+}
+
+// handleCommand processes the single command of m and writes its reply to
+// w, the buffered writer of the sender.
+func (messenger *messenger) handleCommand(m Message, w *bufio.Writer) {
+	l := messenger.app.logger
+
+	command, err := messenger.app.decodeRequest(m)
+	if errors.Is(err, ErrEmptyCommand) {
+		return
+	}
+	if err != nil {
+		l.Error(fmt.Sprintf("%v", err))
+		w.WriteString(SerializeError(err))
+		return
+	}
+
+	// the replies buffered so far must reach the client before the ones the
+	// command writes on its own
+	if command.Parse() == nil && repliesOnItsOwn[command.cmd] {
+		if err := w.Flush(); err != nil {
+			l.Error(fmt.Sprintf("failed to write replies: %v", err))
+		}
+	}
+
+	response := messenger.app.processCommand(command, m)
+	if response == nil {
+		l.Error("got nil response struct")
+		return
+	}
+
+	for _, c := range response.targets {
+		if c == nil {
+			l.Error("got a nil connection object")
+			continue
+		}
+
+		switch {
+		case c == m.conn && response.stream != nil:
+			err = response.stream(w)
+		case c == m.conn:
+			_, err = w.Write(response.message)
+		case response.stream != nil:
+			err = writeStream(c, response.stream)
+		default:
+			_, err = c.Write(response.message)
+		}
+		if err != nil {
+			l.Error("failed to write error response")
+			continue
+		}
+	}
+}
+
+// replyChunkSize is how much of a streamed reply is buffered before being
+// written to the connection.
+const replyChunkSize = 16 * 1024
+
+func writeStream(c net.Conn, stream func(io.Writer) error) error {
+	w := bufio.NewWriterSize(c, replyChunkSize)
+	if err := stream(w); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// maxQueryBuffer is how long an incomplete command can grow before its client
+// is disconnected. It is the client-query-buffer-limit default of redis.
+const maxQueryBuffer = 1024 * 1024 * 1024
+
+// commandScanner finds where the commands read from a connection end, with
+// the same framing as splitCommands. It keeps its place between reads, so a
+// command spanning many reads, like one with a large bulk argument, has each
+// of its bytes scanned once.
+type commandScanner struct {
+	// pos is where scanning resumes, at the start of a command or of one of
+	// its elements
+	pos int
+
+	// elements is how many elements of the current command are left to scan,
+	// or -1 when pos is at the start of a command
+	elements int
+
+	// searchedFrom and searched tell that no line ends between them, so
+	// looking for the end of the line at searchedFrom resumes at searched
+	searchedFrom int
+	searched     int
+}
+
+func newCommandScanner() *commandScanner {
+	return &commandScanner{elements: -1}
+}
+
+// scan returns how many bytes of data hold whole commands. The ones after
+// them are the start of a command still being read. data must start with the
+// bytes given to the last scan, less the ones given to consume.
+func (s *commandScanner) scan(data []byte) int {
+	complete := 0
+	for s.pos < len(data) {
+		eol := s.lineEnd(data, s.pos)
+		if eol < 0 {
+			break
+		}
+
+		if s.elements < 0 {
+			switch data[s.pos] {
+			default:
+				// an inline command
+				s.pos = eol
+				complete = s.pos
+			case byte(BulkString):
+				// a bulk string is a command of a single element
+				s.elements = 1
+			case byte(Array):
+				header := strings.TrimSuffix(string(data[s.pos+1:eol-1]), "\r")
+				n, err := strconv.Atoi(header)
+				if err != nil || n < 1 {
+					s.pos = eol
+					complete = s.pos
 					continue
 				}
+				s.pos = eol
+				s.elements = n
 			}
+			continue
+		}
+
+		// like splitCommands, an element missing its '$' is taken as a
+		// header line followed by a data line
+		var end int
+		if data[s.pos] == byte(BulkString) {
+			end = bulkEnd(data, s.pos, eol)
+		} else {
+			end = s.lineEnd(data, eol)
+		}
+		if end < 0 {
+			break
+		}
+
+		s.pos = end
+		s.elements--
+		if s.elements == 0 {
+			s.elements = -1
+			complete = s.pos
 		}
 	}
+
+	return complete
+}
+
+// lineEnd works like the lineEnd function, but skips the part of data that
+// an earlier call already searched for the same line.
+func (s *commandScanner) lineEnd(data []byte, start int) int {
+	from := start
+	if s.searchedFrom == start && s.searched > start {
+		from = s.searched
+	}
+
+	end := lineEnd(data, from)
+	if end < 0 {
+		s.searchedFrom, s.searched = start, len(data)
+	}
+	return end
+}
+
+// consume tells the scanner the first n bytes of the data it scans were
+// handed over, so the next scan gets the data after them.
+func (s *commandScanner) consume(n int) {
+	s.pos -= n
+	s.searchedFrom -= n
+	s.searched -= n
 }
 
 type Message struct {
+	ctx  context.Context
 	raw  []byte
 	conn net.Conn
 }
 
 func HandleConnection(conn net.Conn, m *messenger, l *slog.Logger) {
 	defer conn.Close()
+	defer m.app.RemoveClient(conn)
+
+	// ctx is cancelled when the client disconnects or the server shuts down,
+	// so commands still running for this connection can abort. Shutting down
+	// also closes the connection, which wakes up the read waiting for the
+	// next command.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+			conn.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	var in chan Message
+	if !m.perConnection {
+		in = m.workerFor(conn)
+	}
 
 	reader := bufio.NewReader(conn)
 	buf := make([]byte, reader.Size())
 
+	// pending holds the start of a command cut by the end of the last read,
+	// until the rest of it is read
+	var pending []byte
+	scanner := newCommandScanner()
+
 	for {
 		n, err := reader.Read(buf)
 		if err != nil {
@@ -118,22 +384,44 @@ func HandleConnection(conn net.Conn, m *messenger, l *slog.Logger) {
 				break
 			}
 
-			l.Error("failed to read bytes: " + fmt.Sprintf("%v", err))
-			_, err = conn.Write(errorResponse)
-			if err != nil {
-				l.Error("failed to write error response")
+			// the connection was closed on this side, e.g. to drop a slow
+			// subscriber or on shutdown
+			if errors.Is(err, net.ErrClosed) {
+				break
 			}
 
+			// any other error, like a reset connection, leaves nothing more
+			// to read either
+			l.Error(fmt.Sprintf("failed to read bytes, disconnecting the client: %v", err))
+			break
+		}
+
+		// the messenger may still be decoding this message when the buffer
+		// is reused by the next read, so it gets its own copy. Appending
+		// never writes to the bytes handed to the messenger, which come
+		// before pending
+		data := append(pending, buf[:n]...)
+		complete := scanner.scan(data)
+		scanner.consume(complete)
+		pending = data[complete:]
+		if len(pending) > maxQueryBuffer {
+			l.Warn(fmt.Sprintf("client %s exceeded the query buffer limit. Disconnecting it", conn.RemoteAddr()))
+			break
+		}
+		if complete == 0 {
 			continue
 		}
 
-		read := buf[:n]
-		l.Debug("received: " + string(read))
+		msg := Message{ctx: ctx, raw: data[:complete:complete], conn: conn}
+		if m.perConnection {
+			m.handle(msg)
+			continue
+		}
 
 		select {
 		case <-m.done:
-			break
-		case m.in <- Message{raw: read, conn: conn}:
+			return
+		case in <- msg:
 		}
 	}
 }
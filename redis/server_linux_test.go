@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+func TestSetSocketOptions(t *testing.T) {
+	getsockopt := func(t *testing.T, conn *net.TCPConn, level int, opt int) int {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var value int
+		var sockErr error
+		err = raw.Control(func(fd uintptr) {
+			value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sockErr != nil {
+			t.Fatal(sockErr)
+		}
+		return value
+	}
+
+	accept := func(t *testing.T) *net.TCPConn {
+		srv, err := nettest.NewLocalListener("tcp")
+		if err != nil {
+			t.Fatalf("failed to setup listener: %v", err)
+		}
+		t.Cleanup(func() { srv.Close() })
+
+		client, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		conn, err := srv.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		return conn.(*net.TCPConn)
+	}
+
+	t.Run("options are applied to tcp connections", func(t *testing.T) {
+		conn := accept(t)
+		config := &ApplicationConfiguration{TCPNoDelay: true, TCPKeepAlive: 42 * time.Second}
+		if err := setSocketOptions(conn, config); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if got := getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got == 0 {
+			t.Error("expected TCP_NODELAY to be set")
+		}
+		if got := getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got == 0 {
+			t.Error("expected SO_KEEPALIVE to be set")
+		}
+		if got := getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 42 {
+			t.Errorf("got keepalive idle time: %d. want: 42", got)
+		}
+	})
+
+	t.Run("options can be disabled", func(t *testing.T) {
+		conn := accept(t)
+		config := &ApplicationConfiguration{TCPNoDelay: false, TCPKeepAlive: 0}
+		if err := setSocketOptions(conn, config); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if got := getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+			t.Error("expected TCP_NODELAY to be unset")
+		}
+		if got := getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got != 0 {
+			t.Error("expected SO_KEEPALIVE to be unset")
+		}
+	})
+
+	t.Run("non tcp connections are left alone", func(t *testing.T) {
+		serverSide, clientSide := net.Pipe()
+		defer serverSide.Close()
+		defer clientSide.Close()
+
+		config := &ApplicationConfiguration{TCPNoDelay: true, TCPKeepAlive: time.Second}
+		if err := setSocketOptions(serverSide, config); err != nil {
+			t.Errorf("expected no error. got: %v", err)
+		}
+	})
+}
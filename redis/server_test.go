@@ -2,9 +2,19 @@ package redis
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -23,12 +33,22 @@ func NewTestLogger() *slog.Logger {
 
 type TestClockTimer struct {
 	mockNow time.Time
+	// fire, when set, replaces real waiting: every value sent on it ends one
+	// of the pending waits started by After.
+	fire chan time.Time
 }
 
 func (c TestClockTimer) Now() time.Time {
 	return c.mockNow
 }
 
+func (c TestClockTimer) After(d time.Duration) <-chan time.Time {
+	if c.fire == nil {
+		return time.After(d)
+	}
+	return c.fire
+}
+
 func getFuture(now time.Time, delta int) *time.Time {
 	future := now.Add(time.Duration(delta) * time.Second)
 	return &future
@@ -47,6 +67,41 @@ type mapState struct {
 	tm map[string]rbtState
 }
 
+func toStringValueMap(m map[string]string) map[string]stringValue {
+	if m == nil {
+		return nil
+	}
+
+	result := make(map[string]stringValue, len(m))
+	for k, v := range m {
+		result[k] = newStringValue([]byte(v))
+	}
+	return result
+}
+
+func toStringMap(m map[string]stringValue) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v.String()
+	}
+	return result
+}
+
+// toSortedSet indexes the members of tree into a sorted set value.
+func toSortedSet(tree rbtree[float64, string]) *sortedSet {
+	s := &sortedSet{tree: tree, scores: make(map[string]float64, tree.Size())}
+	tree.InOrderTraversal(func(score float64, members []string) {
+		for _, m := range members {
+			s.scores[m] = score
+		}
+	})
+	return s
+}
+
 type caseTesterSetup interface {
 	Now() time.Time
 	InitialState() mapState
@@ -75,12 +130,17 @@ func setupApplication(tC caseTesterSetup, t *testing.T) (*Application, net.Liste
 	app := NewApplication(nil, timer, logger)
 	initialState := tC.InitialState()
 	app.state.keyspace.keys = initialState.ks
-	app.state.keyspace.stringMap = initialState.sm
+	for k, ke := range initialState.ks {
+		if ke.expires != nil {
+			app.state.keyspace.volatile[k] = struct{}{}
+		}
+	}
+	app.state.keyspace.stringMap = toStringValueMap(initialState.sm)
 	app.state.keyspace.listMap = initialState.lm
-	app.state.keyspace.sortedSetMap = func() map[string]rbtree[float64, string] {
-		m := make(map[string]rbtree[float64, string], 0)
+	app.state.keyspace.sortedSetMap = func() map[string]*sortedSet {
+		m := make(map[string]*sortedSet, 0)
 		for k, v := range initialState.tm {
-			m[k] = v.tree
+			m[k] = toSortedSet(v.tree)
 		}
 		return m
 	}()
@@ -119,7 +179,7 @@ func assertConnectionAndAppState(t *testing.T, tC testCase, connection net.Conn,
 
 	gotState := app.state
 	gotKs := gotState.keyspace
-	gotSmap := gotKs.stringMap
+	gotSmap := toStringMap(gotKs.stringMap)
 	gotLmap := gotKs.listMap
 	gotSSmap := gotKs.sortedSetMap
 
@@ -139,15 +199,20 @@ func assertConnectionAndAppState(t *testing.T, tC testCase, connection net.Conn,
 		gotSSet, ok := gotSSmap[k]
 		if !ok {
 			t.Errorf("sorted key '%s' not found", k)
+			continue
+		}
+
+		if index := toSortedSet(gotSSet.tree).scores; !reflect.DeepEqual(gotSSet.scores, index) {
+			t.Errorf("scores index - got: %#v. want: %#v", gotSSet.scores, index)
 		}
 
-		gotSSetKs := gotSSet.GetKeySet()
+		gotSSetKs := gotSSet.tree.GetKeySet()
 		wantSSetKs := wantSSet.keys
 		if !reflect.DeepEqual(gotSSetKs, wantSSetKs) {
 			t.Errorf("keys set - got: %#v. want: %#v", gotSSetKs, wantSSetKs)
 		}
 
-		gotSSetVs := gotSSet.GetValueSet()
+		gotSSetVs := gotSSet.tree.GetValueSet()
 		wantSSetVs := wantSSet.values
 		if !reflect.DeepEqual(gotSSetVs, wantSSetVs) {
 			t.Errorf("values set - got: %#v. want: %#v", gotSSetVs, wantSSetVs)
@@ -178,11 +243,27 @@ func TestReadonlyCommands(t *testing.T) {
 			initialState: initialState,
 			wantState:    wantState,
 		},
+		{
+			now:          now,
+			desc:         "inline ping command",
+			data:         "PING\r\n",
+			want:         []byte("+PONG\r\n"),
+			initialState: initialState,
+			wantState:    wantState,
+		},
+		{
+			now:          now,
+			desc:         "ping command after blank lines",
+			data:         "\r\n  \r\n*1\r\n$4\r\nping\r\n",
+			want:         []byte("+PONG\r\n"),
+			initialState: initialState,
+			wantState:    wantState,
+		},
 		{
 			now:          now,
 			desc:         "invalid ping command",
 			data:         "*1\r\n$4\r\npang\r\n",
-			want:         []byte("-invalid command: 'pang'\r\n"),
+			want:         []byte("-ERR invalid command: 'pang'\r\n"),
 			initialState: initialState,
 			wantState:    wantState,
 		},
@@ -206,7 +287,7 @@ func TestReadonlyCommands(t *testing.T) {
 			now:          now,
 			desc:         "invalid echo command",
 			data:         "*1\r\n$4\r\necho\r\n",
-			want:         []byte("-wrong number of arguments.\r\n"),
+			want:         []byte("-ERR wrong number of arguments for 'echo' command\r\n"),
 			initialState: initialState,
 			wantState:    wantState,
 		},
@@ -225,6 +306,122 @@ func TestReadonlyCommands(t *testing.T) {
 	}
 }
 
+func TestBlankLinesAreIgnored(t *testing.T) {
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	tC := testCase{now: time.Now(), initialState: emptyState, wantState: emptyState}
+	app, srv, logger := setupApplication(tC, t)
+
+	go func() { Listen(srv, app, logger) }()
+
+	conn := makeRequestToServer("\r\n", srv, t)
+	defer conn.Close()
+
+	for _, line := range []string{" \t \r\n", "\n", "*0\r\n", "$-1\r\n"} {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			t.Fatalf("could not write payload to server: %v", err)
+		}
+	}
+
+	// a reply to any of the blank lines would be read before the PONG
+	if got := writeAndRead(t, conn, "*1\r\n$4\r\nping\r\n"); got != "+PONG\r\n" {
+		t.Errorf("got: %q. want: %q", got, "+PONG\r\n")
+	}
+}
+
+func TestMalformedFramesKeepTheServerUp(t *testing.T) {
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	tC := testCase{now: time.Now(), initialState: emptyState, wantState: emptyState}
+	app, srv, logger := setupApplication(tC, t)
+
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"invalid bulk length", "*1\r\n$x\r\nping\r\n", "-ERR Protocol error: invalid bulk length 'x'\r\n"},
+		{"empty array", "*0\r\n*1\r\n$4\r\nping\r\n", "+PONG\r\n"},
+		{"null bulk string", "$-1\r\n*1\r\n$4\r\nping\r\n", "+PONG\r\n"},
+		{"value with CRLF", encodeRequest(t, "echo", "a\r\nb"), "$4\r\na\r\nb\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+
+	// Parse doesn't rely on DecodeMessage to reject empty commands
+	if err := (&Cmd{app: app}).Parse(); !errors.Is(err, ErrEmptyCommand) {
+		t.Errorf("expected an empty command error. got: %v", err)
+	}
+}
+
+func TestCommandArity(t *testing.T) {
+	for name, cmd := range cmdParseTable {
+		if _, ok := commandArity[cmd]; !ok {
+			t.Errorf("command '%s' has no arity", name)
+		}
+		if _, ok := commandWrites[cmd]; !ok {
+			t.Errorf("command '%s' is not classified as read or write", name)
+		}
+	}
+
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	testCases := []struct {
+		desc string
+		args []string
+		want string
+	}{
+		{desc: "missing argument", args: []string{"get"}, want: "-ERR wrong number of arguments for 'get' command\r\n"},
+		{desc: "extra argument", args: []string{"GET", "a", "b"}, want: "-ERR wrong number of arguments for 'get' command\r\n"},
+		{desc: "too few for variadic command", args: []string{"zadd", "k", "1"}, want: "-ERR wrong number of arguments for 'zadd' command\r\n"},
+		{desc: "alias is named as called", args: []string{"unlink"}, want: "-ERR wrong number of arguments for 'unlink' command\r\n"},
+		{desc: "variadic command with many arguments", args: []string{"del", "a", "b", "c"}, want: ":0\r\n"},
+		{desc: "semantic check in command", args: []string{"set", "a", "b", "EX"}, want: "-ERR wrong number of arguments for 'set' command\r\n"},
+		{desc: "inside a script", args: []string{"eval", "GET", "0"}, want: "-ERR wrong number of arguments for 'get' command\r\n"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(testCase{now: time.Now(), initialState: emptyState}, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(encodeRequest(t, tC.args...), srv, t)
+			defer conn.Close()
+
+			buf := make([]byte, 4096)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read from connection: %s", err)
+			}
+
+			if got := string(buf[:n]); got != tC.want {
+				t.Errorf("got: %q. want: %q", got, tC.want)
+			}
+		})
+	}
+}
+
 func TestSetCommand(t *testing.T) {
 	now := time.Now()
 	testCases := []testCase{
@@ -232,7 +429,7 @@ func TestSetCommand(t *testing.T) {
 			now:  now,
 			desc: "invalid set command",
 			data: "*3\r\n$2\r\nst\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
-			want: []byte("-invalid command: 'st'\r\n"),
+			want: []byte("-ERR invalid command: 'st'\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{},
 				sm: map[string]string{},
@@ -497,6 +694,38 @@ func TestExpireCommand(t *testing.T) {
 				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
 			},
 		},
+		{
+			now:  now,
+			desc: "negative expire deletes the key right away",
+			data: "*3\r\n$6\r\nexpire\r\n$4\r\nName\r\n$2\r\n-1\r\n",
+			want: []byte(":1\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "zero expire on volatile key deletes the key right away",
+			data: "*3\r\n$6\r\nexpire\r\n$4\r\nName\r\n$1\r\n0\r\n",
+			want: []byte(":1\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: getFuture(now, 10)}},
+				sm: map[string]string{},
+				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
 		{
 			now:  now,
 			desc: "expire on non-existant key should do nothing",
@@ -528,6 +757,74 @@ func TestExpireCommand(t *testing.T) {
 	}
 }
 
+func TestPersistCommand(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "persist key with expiry",
+			data: "*2\r\n$7\r\npersist\r\n$4\r\nName\r\n",
+			want: []byte(":1\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: &later}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "persist key without expiry",
+			data: "*2\r\n$7\r\npersist\r\n$4\r\nName\r\n",
+			want: []byte(":0\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "persist non existing key",
+			data: "*2\r\n$7\r\npersist\r\n$4\r\nNone\r\n",
+			want: []byte(":0\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
 func TestExistsCommand(t *testing.T) {
 	now := time.Now()
 
@@ -612,6 +909,22 @@ func TestExistsCommand(t *testing.T) {
 				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
 			},
 		},
+		{
+			now:  now,
+			desc: "existing and non existing keys interleaved repeats",
+			data: "*6\r\n$6\r\nexists\r\n$4\r\nNone\r\n$4\r\nName\r\n$4\r\nNone\r\n$4\r\nName\r\n$4\r\nNone\r\n",
+			want: []byte(":2\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
+			},
+		},
 		{
 			now:  now,
 			desc: "existing single time and non existing repeated",
@@ -813,6 +1126,67 @@ func TestDeleteCommand(t *testing.T) {
 	}
 }
 
+func TestDeleteMixedTypes(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	// the application takes the maps over, so each run gets its own
+	initialState := func() mapState {
+		return mapState{
+			ks: map[string]keyspaceEntry{
+				"k1": {group: "string", expires: nil},
+				"k2": {group: "list", expires: nil},
+				"k3": {group: "sorted-set", expires: nil},
+				"k4": {group: "string", expires: &later},
+			},
+			sm: map[string]string{"k1": "John", "k4": "Mary"},
+			lm: map[string]list{"k2": NewListFromSlice([]string{"a", "b"})},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(1, "Norem")
+				return map[string]rbtState{"k3": {tree: *tree}}
+			}(),
+		}
+	}
+
+	for _, command := range []string{"del", "unlink"} {
+		t.Run(command, func(t *testing.T) {
+			app, srv, logger := setupApplication(testCase{now: now, initialState: initialState()}, t)
+			app.state.keyspace.volatile = map[string]struct{}{"k4": {}}
+			go func() { Listen(srv, app, logger) }()
+
+			data := fmt.Sprintf("*5\r\n$%d\r\n%s\r\n$2\r\nk1\r\n$2\r\nk2\r\n$2\r\nk3\r\n$2\r\nk4\r\n", len(command), command)
+			conn := makeRequestToServer(data, srv, t)
+			defer conn.Close()
+
+			buf := make([]byte, 4096)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read from connection: %s", err)
+			}
+			if got := string(buf[:n]); got != ":4\r\n" {
+				t.Errorf("got: %#v. want: %#v", got, ":4\r\n")
+			}
+
+			ks := &app.state.keyspace
+			ks.mutex.RLock()
+			defer ks.mutex.RUnlock()
+			sizes := map[string]int{
+				"keys":        len(ks.keys),
+				"strings":     len(ks.stringMap),
+				"lists":       len(ks.listMap),
+				"sorted sets": len(ks.sortedSetMap),
+				"volatile":    len(ks.volatile),
+			}
+			for name, size := range sizes {
+				if size != 0 {
+					t.Errorf("expected %s to be emptied. got %d entries", name, size)
+				}
+			}
+		})
+	}
+}
+
 func TestIncrementCommand(t *testing.T) {
 	now := time.Now()
 	testCases := []testCase{
@@ -836,7 +1210,7 @@ func TestIncrementCommand(t *testing.T) {
 			now:  now,
 			desc: "increment non existing integer key",
 			data: "*2\r\n$4\r\nincr\r\n$4\r\nName\r\n",
-			want: []byte(":0\r\n"),
+			want: []byte(":1\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Some": {group: "list", expires: nil}},
 				sm: map[string]string{},
@@ -847,7 +1221,7 @@ func TestIncrementCommand(t *testing.T) {
 					"Some": {group: "list", expires: nil},
 					"Name": {group: "string", expires: nil},
 				},
-				sm: map[string]string{"Name": "0"},
+				sm: map[string]string{"Name": "1"},
 				lm: map[string]list{"Some": NewListFromSlice([]string{"John"})},
 			},
 		},
@@ -855,7 +1229,7 @@ func TestIncrementCommand(t *testing.T) {
 			now:  now,
 			desc: "increment non parseable string key",
 			data: "*2\r\n$4\r\nincr\r\n$4\r\nName\r\n",
-			want: []byte("-key 'Name' cannot be parsed to integer\r\n"),
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
 				sm: map[string]string{"Name": "John"},
@@ -871,7 +1245,7 @@ func TestIncrementCommand(t *testing.T) {
 			now:  now,
 			desc: "increment non integer key",
 			data: "*2\r\n$4\r\nincr\r\n$4\r\nName\r\n",
-			want: []byte("-key 'Name' does not support this operation\r\n"),
+			want: []byte("-WRONGTYPE key 'Name' does not support this operation\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
 				sm: map[string]string{},
@@ -921,7 +1295,7 @@ func TestDecrementCommand(t *testing.T) {
 			now:  now,
 			desc: "decrement non existing integer key",
 			data: "*2\r\n$4\r\ndecr\r\n$4\r\nName\r\n",
-			want: []byte(":0\r\n"),
+			want: []byte(":-1\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Some": {group: "list", expires: nil}},
 				sm: map[string]string{},
@@ -932,7 +1306,7 @@ func TestDecrementCommand(t *testing.T) {
 					"Some": {group: "list", expires: nil},
 					"Name": {group: "string", expires: nil},
 				},
-				sm: map[string]string{"Name": "0"},
+				sm: map[string]string{"Name": "-1"},
 				lm: map[string]list{"Some": NewListFromSlice([]string{"John"})},
 			},
 		},
@@ -940,7 +1314,7 @@ func TestDecrementCommand(t *testing.T) {
 			now:  now,
 			desc: "decrement non parseable string key",
 			data: "*2\r\n$4\r\ndecr\r\n$4\r\nName\r\n",
-			want: []byte("-key 'Name' cannot be parsed to integer\r\n"),
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
 				sm: map[string]string{"Name": "John"},
@@ -956,7 +1330,7 @@ func TestDecrementCommand(t *testing.T) {
 			now:  now,
 			desc: "decrement non integer key",
 			data: "*2\r\n$4\r\ndecr\r\n$4\r\nName\r\n",
-			want: []byte("-key 'Name' does not support this operation\r\n"),
+			want: []byte("-WRONGTYPE key 'Name' does not support this operation\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
 				sm: map[string]string{},
@@ -983,29 +1357,303 @@ func TestDecrementCommand(t *testing.T) {
 	}
 }
 
-func TestRPushCommand(t *testing.T) {
+func TestIncrementKeepsExpiry(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	withValue := func(value string) mapState {
+		return mapState{
+			ks: map[string]keyspaceEntry{"Counter": {group: "string", expires: &later}},
+			sm: map[string]string{"Counter": value},
+			lm: map[string]list{},
+		}
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "incr",
+			data:         "*2\r\n$4\r\nincr\r\n$7\r\nCounter\r\n",
+			want:         []byte(":11\r\n"),
+			initialState: withValue("10"),
+			wantState:    withValue("11"),
+		},
+		{
+			now:          now,
+			desc:         "decr",
+			data:         "*2\r\n$4\r\ndecr\r\n$7\r\nCounter\r\n",
+			want:         []byte(":9\r\n"),
+			initialState: withValue("10"),
+			wantState:    withValue("9"),
+		},
+		{
+			now:          now,
+			desc:         "incrby",
+			data:         "*3\r\n$6\r\nincrby\r\n$7\r\nCounter\r\n$1\r\n5\r\n",
+			want:         []byte(":15\r\n"),
+			initialState: withValue("10"),
+			wantState:    withValue("15"),
+		},
+		{
+			now:          now,
+			desc:         "decrby",
+			data:         "*3\r\n$6\r\ndecrby\r\n$7\r\nCounter\r\n$1\r\n5\r\n",
+			want:         []byte(":5\r\n"),
+			initialState: withValue("10"),
+			wantState:    withValue("5"),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestIncrementByCommand(t *testing.T) {
 	now := time.Now()
 
 	testCases := []testCase{
 		{
 			now:  now,
-			desc: "push to non-existing key",
-			data: "*3\r\n$5\r\nrpush\r\n$6\r\nmylist\r\n$5\r\nhello\r\n",
-			want: []byte(":1\r\n"),
+			desc: "increment existing integer key",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$1\r\n5\r\n",
+			want: []byte(":15\r\n"),
 			initialState: mapState{
-				ks: map[string]keyspaceEntry{},
-				sm: map[string]string{},
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
 				lm: map[string]list{},
 			},
 			wantState: mapState{
-				ks: map[string]keyspaceEntry{"mylist": {group: "list", expires: nil}},
-				sm: map[string]string{},
-				lm: map[string]list{"mylist": NewListFromSlice([]string{"hello"})},
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "15"},
+				lm: map[string]list{},
 			},
 		},
 		{
 			now:  now,
-			desc: "push to key keeps order",
+			desc: "increment by negative amount",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$3\r\n-15\r\n",
+			want: []byte(":-5\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "-5"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "increment by non integer amount",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$4\r\nfive\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "increment by float amount",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$3\r\n1.5\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "increment non parseable string key",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$1\r\n5\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "increment overflow",
+			data: "*3\r\n$6\r\nincrby\r\n$4\r\nName\r\n$1\r\n1\r\n",
+			want: []byte("-ERR increment or decrement would overflow\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "9223372036854775807"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "9223372036854775807"},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestDecrementByCommand(t *testing.T) {
+	now := time.Now()
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "decrement existing integer key",
+			data: "*3\r\n$6\r\ndecrby\r\n$4\r\nName\r\n$1\r\n5\r\n",
+			want: []byte(":5\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "5"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "decrement by non integer amount",
+			data: "*3\r\n$6\r\ndecrby\r\n$4\r\nName\r\n$4\r\nfive\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "decrement by out of range amount",
+			data: "*3\r\n$6\r\ndecrby\r\n$4\r\nName\r\n$19\r\n9223372036854775808\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "decrement non parseable string key",
+			data: "*3\r\n$6\r\ndecrby\r\n$4\r\nName\r\n$1\r\n5\r\n",
+			want: []byte("-ERR value is not an integer or out of range\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "decrement overflow",
+			data: "*3\r\n$6\r\ndecrby\r\n$4\r\nName\r\n$20\r\n-9223372036854775808\r\n",
+			want: []byte("-ERR increment or decrement would overflow\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "10"},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestRPushCommand(t *testing.T) {
+	now := time.Now()
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "push to non-existing key",
+			data: "*3\r\n$5\r\nrpush\r\n$6\r\nmylist\r\n$5\r\nhello\r\n",
+			want: []byte(":1\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"mylist": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"mylist": NewListFromSlice([]string{"hello"})},
+			},
+		},
+		{
+			now:  now,
+			desc: "push to key keeps order",
 			data: "*5\r\n$5\r\nrpush\r\n$6\r\nmylist\r\n$5\r\nhello\r\n$5\r\nworld\r\n$4\r\ntest\r\n",
 			want: []byte(":4\r\n"),
 			initialState: mapState{
@@ -1023,7 +1671,7 @@ func TestRPushCommand(t *testing.T) {
 			now:  now,
 			desc: "push to invalid existing key returns error",
 			data: "*3\r\n$5\r\nrpush\r\n$6\r\nmylist\r\n$5\r\nhello\r\n",
-			want: []byte("-key 'mylist' does not support this operation\r\n"),
+			want: []byte("-WRONGTYPE key 'mylist' does not support this operation\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"mylist": {group: "string", expires: nil}},
 				sm: map[string]string{"mylist": "hi"},
@@ -1090,7 +1738,7 @@ func TestLPushCommand(t *testing.T) {
 			now:  now,
 			desc: "push to invalid existing key returns error",
 			data: "*3\r\n$5\r\nlpush\r\n$6\r\nmylist\r\n$5\r\nhello\r\n",
-			want: []byte("-key 'mylist' does not support this operation\r\n"),
+			want: []byte("-WRONGTYPE key 'mylist' does not support this operation\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"mylist": {group: "string", expires: nil}},
 				sm: map[string]string{"mylist": "hi"},
@@ -1194,7 +1842,7 @@ func TestZAddCommand(t *testing.T) {
 			now:  now,
 			desc: "push to invalid existing key returns error",
 			data: "*4\r\n$4\r\nzadd\r\n$6\r\nmylist\r\n$1\r\n1\r\n$5\r\nNorem\r\n",
-			want: []byte("-key 'mylist' does not support this operation\r\n"),
+			want: []byte("-WRONGTYPE key 'mylist' does not support this operation\r\n"),
 			initialState: mapState{
 				ks: map[string]keyspaceEntry{"mylist": {group: "string", expires: nil}},
 				sm: map[string]string{"mylist": "hi"},
@@ -1242,6 +1890,110 @@ func TestZAddCommand(t *testing.T) {
 				}(),
 			},
 		},
+		{
+			now:  now,
+			desc: "re-adding a member with the same score counts nothing",
+			data: "*4\r\n$4\r\nzadd\r\n$5\r\nmyset\r\n$1\r\n1\r\n$5\r\nNorem\r\n",
+			want: []byte(":0\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: func() map[string]rbtState {
+					tree := NewTree[float64, string]()
+					tree.Put(1, "Norem")
+					tree.Put(2, "Royce")
+
+					sset := make(map[string]rbtState)
+					sset["myset"] = rbtState{tree: *tree}
+					return sset
+				}(),
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: map[string]rbtState{"myset": {keys: []float64{1, 2}, values: []string{"Norem", "Royce"}}},
+			},
+		},
+		{
+			now:  now,
+			desc: "re-adding a member with another score moves it without counting it",
+			data: "*6\r\n$4\r\nzadd\r\n$5\r\nmyset\r\n$1\r\n3\r\n$5\r\nNorem\r\n$1\r\n1\r\n$4\r\nFord\r\n",
+			want: []byte(":1\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: func() map[string]rbtState {
+					tree := NewTree[float64, string]()
+					tree.Put(1, "Norem")
+					tree.Put(2, "Royce")
+
+					sset := make(map[string]rbtState)
+					sset["myset"] = rbtState{tree: *tree}
+					return sset
+				}(),
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: map[string]rbtState{"myset": {keys: []float64{1, 2, 3}, values: []string{"Ford", "Royce", "Norem"}}},
+			},
+		},
+		{
+			now:  now,
+			desc: "CH counts members whose score changed",
+			data: "*9\r\n$4\r\nzadd\r\n$5\r\nmyset\r\n$2\r\nch\r\n$1\r\n3\r\n$5\r\nNorem\r\n$1\r\n2\r\n$5\r\nRoyce\r\n$1\r\n1\r\n$4\r\nFord\r\n",
+			want: []byte(":2\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: func() map[string]rbtState {
+					tree := NewTree[float64, string]()
+					tree.Put(1, "Norem")
+					tree.Put(2, "Royce")
+
+					sset := make(map[string]rbtState)
+					sset["myset"] = rbtState{tree: *tree}
+					return sset
+				}(),
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: map[string]rbtState{"myset": {keys: []float64{1, 2, 3}, values: []string{"Ford", "Royce", "Norem"}}},
+			},
+		},
+		{
+			now:  now,
+			desc: "CH without score member pairs returns error",
+			data: "*3\r\n$4\r\nzadd\r\n$5\r\nmyset\r\n$2\r\nCH\r\n",
+			want: []byte("-ERR wrong number of arguments for 'zadd' command\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: func() map[string]rbtState {
+					tree := NewTree[float64, string]()
+					tree.Put(1, "Norem")
+					tree.Put(2, "Royce")
+
+					sset := make(map[string]rbtState)
+					sset["myset"] = rbtState{tree: *tree}
+					return sset
+				}(),
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{},
+				tm: map[string]rbtState{"myset": {keys: []float64{1, 2}, values: []string{"Norem", "Royce"}}},
+			},
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -1375,3 +2127,2911 @@ func TestZRangeCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestZScoreAndZRangeWithScoresCommands(t *testing.T) {
+	now := time.Now()
+	state := mapState{
+		ks: map[string]keyspaceEntry{
+			"myset": {group: "sorted-set", expires: nil},
+			"Name":  {group: "string", expires: nil},
+		},
+		sm: map[string]string{"Name": "John"},
+		lm: map[string]list{},
+		tm: func() map[string]rbtState {
+			tree := NewTree[float64, string]()
+			tree.Put(3, "Norem")
+			tree.Put(3.14, "Castilla")
+			tree.Put(-2.5, "Ford")
+
+			return map[string]rbtState{"myset": {tree: *tree, keys: []float64{-2.5, 3, 3.14}, values: []string{"Ford", "Norem", "Castilla"}}}
+		}(),
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "zscore of integer valued score",
+			data:         "*3\r\n$6\r\nzscore\r\n$5\r\nmyset\r\n$5\r\nNorem\r\n",
+			want:         []byte("$1\r\n3\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zscore of fractional score",
+			data:         "*3\r\n$6\r\nzscore\r\n$5\r\nmyset\r\n$8\r\nCastilla\r\n",
+			want:         []byte("$4\r\n3.14\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zscore of non existing member",
+			data:         "*3\r\n$6\r\nzscore\r\n$5\r\nmyset\r\n$4\r\nNone\r\n",
+			want:         []byte(NIL_BULK_STRING),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zscore of non existing key",
+			data:         "*3\r\n$6\r\nzscore\r\n$4\r\nNone\r\n$5\r\nNorem\r\n",
+			want:         []byte(NIL_BULK_STRING),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zscore of wrong type key",
+			data:         "*3\r\n$6\r\nzscore\r\n$4\r\nName\r\n$5\r\nNorem\r\n",
+			want:         []byte("-WRONGTYPE key 'Name' does not support this operation\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zrange with scores",
+			data:         "*5\r\n$6\r\nzrange\r\n$5\r\nmyset\r\n$1\r\n0\r\n$2\r\n-1\r\n$10\r\nwithscores\r\n",
+			want:         []byte("*6\r\n$4\r\nFord\r\n$4\r\n-2.5\r\n$5\r\nNorem\r\n$1\r\n3\r\n$8\r\nCastilla\r\n$4\r\n3.14\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "zrange with invalid option",
+			data:         "*5\r\n$6\r\nzrange\r\n$5\r\nmyset\r\n$1\r\n0\r\n$2\r\n-1\r\n$5\r\nscore\r\n",
+			want:         []byte("-ERR syntax error, invalid option 'score'\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestZRangeCommandLargeReply(t *testing.T) {
+	const members = 5000
+	tree := NewTree[float64, string]()
+	values := make([]any, 0, members)
+	for i := 0; i < members; i++ {
+		member := fmt.Sprintf("member:%05d", i)
+		tree.Put(float64(i), member)
+		values = append(values, member)
+	}
+
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"myset": {group: "sorted-set", expires: nil}},
+			sm: map[string]string{},
+			lm: map[string]list{},
+			tm: map[string]rbtState{"myset": {tree: *tree}},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn := makeRequestToServer("*4\r\n$6\r\nzrange\r\n$5\r\nmyset\r\n$1\r\n0\r\n$2\r\n-1\r\n", srv, t)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	want := SerializeArray(values)
+	got := make([]byte, 0, len(want))
+	buf := make([]byte, 4096)
+	for len(got) < len(want) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read from connection after %d bytes: %s", len(got), err)
+		}
+		got = append(got, buf[:n]...)
+	}
+
+	if string(got) != want {
+		t.Errorf("got a reply of %d bytes different from the expected %d bytes", len(got), len(want))
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	now := time.Now()
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "append to non-existing key",
+			data: "*3\r\n$6\r\nappend\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+			want: []byte(":4\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "append to existing key",
+			data: "*3\r\n$6\r\nappend\r\n$4\r\nName\r\n$4\r\n Doe\r\n",
+			want: []byte(":8\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John Doe"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "append to invalid existing key returns error",
+			data: "*3\r\n$6\r\nappend\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+			want: []byte("-WRONGTYPE key 'Name' does not support this operation\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"Name": NewListFromSlice([]string{"John"})},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func writeAndRead(t *testing.T, conn net.Conn, data string) string {
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatalf("could not write payload to server: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read from connection: %s", err)
+	}
+	return string(buf[:n])
+}
+
+func TestZScanCommand(t *testing.T) {
+	now := time.Now()
+	tC := testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"myset":  {group: "sorted-set", expires: nil},
+				"mylist": {group: "list", expires: nil},
+			},
+			sm: map[string]string{},
+			lm: map[string]list{"mylist": NewListFromSlice([]string{"hi"})},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(10, "Norem")
+				tree.Put(12, "Castilla")
+				tree.Put(8.5, "Sam-Bodden")
+				tree.Put(10, "Royce")
+				tree.Put(6, "Ford")
+
+				return map[string]rbtState{"myset": {tree: *tree}}
+			}(),
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not establish connection: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{
+			"first page",
+			"*5\r\n$5\r\nzscan\r\n$5\r\nmyset\r\n$1\r\n0\r\n$5\r\ncount\r\n$1\r\n2\r\n",
+			"*2\r\n$1\r\n2\r\n*4\r\n$4\r\nFord\r\n$1\r\n6\r\n$10\r\nSam-Bodden\r\n$3\r\n8.5\r\n",
+		},
+		{
+			"second page",
+			"*5\r\n$5\r\nzscan\r\n$5\r\nmyset\r\n$1\r\n2\r\n$5\r\ncount\r\n$1\r\n2\r\n",
+			"*2\r\n$1\r\n4\r\n*4\r\n$5\r\nNorem\r\n$2\r\n10\r\n$5\r\nRoyce\r\n$2\r\n10\r\n",
+		},
+		{
+			"last page",
+			"*5\r\n$5\r\nzscan\r\n$5\r\nmyset\r\n$1\r\n4\r\n$5\r\ncount\r\n$1\r\n2\r\n",
+			"*2\r\n$1\r\n0\r\n*2\r\n$8\r\nCastilla\r\n$2\r\n12\r\n",
+		},
+		{
+			"match pattern",
+			"*5\r\n$5\r\nzscan\r\n$5\r\nmyset\r\n$1\r\n0\r\n$5\r\nmatch\r\n$5\r\n[FR]*\r\n",
+			"*2\r\n$1\r\n0\r\n*4\r\n$4\r\nFord\r\n$1\r\n6\r\n$5\r\nRoyce\r\n$2\r\n10\r\n",
+		},
+		{
+			"missing key",
+			"*3\r\n$5\r\nzscan\r\n$7\r\nmissing\r\n$1\r\n0\r\n",
+			"*2\r\n$1\r\n0\r\n*0\r\n",
+		},
+		{
+			"cursor past the end",
+			encodeRequest(t, "zscan", "myset", "9223372036854775807"),
+			"*2\r\n$1\r\n0\r\n*0\r\n",
+		},
+		{
+			"huge count",
+			encodeRequest(t, "zscan", "myset", "4", "count", "9223372036854775807"),
+			"*2\r\n$1\r\n0\r\n*2\r\n$8\r\nCastilla\r\n$2\r\n12\r\n",
+		},
+		{
+			"cursor out of range",
+			encodeRequest(t, "zscan", "myset", "18446744073709551615"),
+			"-ERR invalid cursor '18446744073709551615'\r\n",
+		},
+		{
+			"negative cursor",
+			encodeRequest(t, "zscan", "myset", "-1"),
+			"-ERR invalid cursor '-1'\r\n",
+		},
+		{
+			"wrong type key",
+			"*3\r\n$5\r\nzscan\r\n$6\r\nmylist\r\n$1\r\n0\r\n",
+			"-WRONGTYPE key 'mylist' does not support this operation\r\n",
+		},
+	}
+	for _, s := range steps {
+		got := writeAndRead(t, conn, s.data)
+		if got != s.want {
+			t.Errorf("%s - got: %#v. want: %#v", s.desc, got, s.want)
+		}
+	}
+}
+
+// resetConn is a connection whose reads fail as if the client reset it.
+type resetConn struct {
+	net.Conn
+}
+
+func (c resetConn) Read([]byte) (int, error) {
+	return 0, syscall.ECONNRESET
+}
+
+func TestConnectionHandlingEnds(t *testing.T) {
+	// handled runs HandleConnection on conn and fails the test when it is
+	// still running once stop was called
+	handled := func(t *testing.T, conn net.Conn, m *messenger, stop func()) {
+		t.Helper()
+		ended := make(chan struct{})
+		go func() {
+			HandleConnection(conn, m, NewTestLogger())
+			close(ended)
+		}()
+
+		stop()
+		select {
+		case <-ended:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the connection handling to end")
+		}
+	}
+	newMessenger := func() *messenger {
+		app := NewApplication(nil, TestClockTimer{mockNow: time.Now()}, NewTestLogger())
+		// nothing drains the channel, like a worker that already stopped
+		return &messenger{app: app, done: make(chan struct{}), in: []chan Message{make(chan Message)}}
+	}
+
+	t.Run("on a read error", func(t *testing.T) {
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+
+		handled(t, resetConn{serverSide}, newMessenger(), func() {})
+	})
+
+	t.Run("on shutdown while waiting for a command", func(t *testing.T) {
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+
+		m := newMessenger()
+		handled(t, serverSide, m, func() {
+			time.Sleep(50 * time.Millisecond)
+			close(m.done)
+		})
+	})
+
+	t.Run("on shutdown while queueing a command", func(t *testing.T) {
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+
+		m := newMessenger()
+		handled(t, serverSide, m, func() {
+			if _, err := clientSide.Write([]byte(encodeRequest(t, "ping"))); err != nil {
+				t.Fatalf("could not write payload to server: %v", err)
+			}
+			close(m.done)
+		})
+	})
+}
+
+func TestDebugSleepCommand(t *testing.T) {
+	now := time.Now()
+	tC := testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	t.Run("should reply after sleeping", func(t *testing.T) {
+		app, srv, logger := setupApplication(tC, t)
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer("*3\r\n$5\r\ndebug\r\n$5\r\nsleep\r\n$4\r\n0.01\r\n", srv, t)
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+
+		got := string(buf[:n])
+		if got != OK_SIMPLE_STRING {
+			t.Errorf("got: %#v. want: %#v", got, OK_SIMPLE_STRING)
+		}
+	})
+
+	t.Run("should reply when the timeout fires", func(t *testing.T) {
+		app, srv, logger := setupApplication(tC, t)
+		fire := make(chan time.Time)
+		app.clock = TestClockTimer{mockNow: now, fire: fire}
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer("*3\r\n$5\r\ndebug\r\n$5\r\nsleep\r\n$4\r\n3600\r\n", srv, t)
+		defer conn.Close()
+
+		select {
+		case fire <- now:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the sleep to wait on the clock")
+		}
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+
+		got := string(buf[:n])
+		if got != OK_SIMPLE_STRING {
+			t.Errorf("got: %#v. want: %#v", got, OK_SIMPLE_STRING)
+		}
+	})
+
+	t.Run("should abort when the client disconnects", func(t *testing.T) {
+		app, srv, logger := setupApplication(tC, t)
+		go func() { Listen(srv, app, logger) }()
+
+		sleeper := makeRequestToServer("*3\r\n$5\r\ndebug\r\n$5\r\nsleep\r\n$2\r\n10\r\n", srv, t)
+		time.Sleep(50 * time.Millisecond)
+		sleeper.Close()
+
+		conn := makeRequestToServer("*1\r\n$4\r\nping\r\n", srv, t)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("server did not reply promptly after client disconnected: %s", err)
+		}
+
+		got := string(buf[:n])
+		if got != "+PONG\r\n" {
+			t.Errorf("got: %#v. want: %#v", got, "+PONG\r\n")
+		}
+	})
+}
+
+func TestWorkersKeepConnectionOrder(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	app.config.Store(&ApplicationConfiguration{Workers: 4})
+	go func() { Listen(srv, app, logger) }()
+
+	const clients, pushes = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("could not establish connection: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 64)
+			for j := 0; j < pushes; j++ {
+				data := SerializeArray([]any{"rpush", key, strconv.Itoa(j)})
+				if _, err := conn.Write([]byte(data)); err != nil {
+					t.Errorf("could not write payload to server: %v", err)
+					return
+				}
+				if _, err := conn.Read(buf); err != nil {
+					t.Errorf("failed to read from connection: %s", err)
+					return
+				}
+			}
+		}(fmt.Sprintf("list%d", i))
+	}
+	wg.Wait()
+
+	want := make([]string, pushes)
+	for j := range want {
+		want[j] = strconv.Itoa(j)
+	}
+
+	for i := 0; i < clients; i++ {
+		key := fmt.Sprintf("list%d", i)
+		got := app.state.keyspace.listMap[key]
+		if !reflect.DeepEqual(got.ToSlice(), want) {
+			t.Errorf("%s: got: %v. want: %v", key, got.ToSlice(), want)
+		}
+	}
+}
+
+func BenchmarkConcurrentClients(b *testing.B) {
+	modes := []struct {
+		desc          string
+		perConnection bool
+		workers       int
+	}{
+		{"single messenger", false, 1},
+		{"4 workers", false, 4},
+		{"per connection", true, 0},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.desc, func(b *testing.B) {
+			timer := TestClockTimer{mockNow: time.Now()}
+			config := &ApplicationConfiguration{ProcessPerConnection: mode.perConnection, Workers: mode.workers}
+			app := NewApplication(config, timer, NewTestLogger())
+
+			srv, err := nettest.NewLocalListener("tcp")
+			if err != nil {
+				b.Fatalf("failed to setup listener: %v", err)
+			}
+			defer srv.Close()
+			go func() { Listen(srv, app, app.logger) }()
+
+			data := []byte("*3\r\n$3\r\nset\r\n$4\r\nName\r\n$4\r\nJohn\r\n")
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := net.Dial("tcp", srv.Addr().String())
+				if err != nil {
+					b.Errorf("could not establish connection: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				buf := make([]byte, 64)
+				for pb.Next() {
+					if _, err := conn.Write(data); err != nil {
+						b.Errorf("could not write payload to server: %v", err)
+						return
+					}
+					if _, err := conn.Read(buf); err != nil {
+						b.Errorf("failed to read from connection: %s", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestDebugObjectCommand(t *testing.T) {
+	now := time.Now()
+	state := mapState{
+		ks: map[string]keyspaceEntry{
+			"Name":   {group: "string", expires: nil},
+			"mylist": {group: "list", expires: nil},
+			"myset":  {group: "sorted-set", expires: nil},
+		},
+		sm: map[string]string{"Name": "John"},
+		lm: map[string]list{"mylist": NewListFromSlice([]string{"hi", "there"})},
+		tm: func() map[string]rbtState {
+			tree := NewTree[float64, string]()
+			tree.Put(1, "Norem")
+			tree.Put(2.5, "Ford")
+
+			return map[string]rbtState{"myset": {tree: *tree, keys: []float64{1, 2.5}, values: []string{"Norem", "Ford"}}}
+		}(),
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "debug object on string key",
+			data:         "*3\r\n$5\r\ndebug\r\n$6\r\nobject\r\n$4\r\nName\r\n",
+			want:         []byte("$32\r\nencoding:raw serializedlength:33\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "debug object on list key",
+			data:         "*3\r\n$5\r\ndebug\r\n$6\r\nobject\r\n$6\r\nmylist\r\n",
+			want:         []byte("$48\r\nencoding:listpack serializedlength:46 ql_nodes:2\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "debug object on sorted set key",
+			data:         "*3\r\n$5\r\ndebug\r\n$6\r\nobject\r\n$5\r\nmyset\r\n",
+			want:         []byte("$37\r\nencoding:listpack serializedlength:62\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "debug object on non existing key",
+			data:         "*3\r\n$5\r\ndebug\r\n$6\r\nobject\r\n$7\r\nmissing\r\n",
+			want:         []byte("-ERR no such key\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestDebugKeyspaceCommand(t *testing.T) {
+	now := time.Now()
+	later := time.UnixMilli(now.UnixMilli() + 3600_000)
+	state := mapState{
+		ks: map[string]keyspaceEntry{
+			"Name":   {group: "string", expires: &later},
+			"mylist": {group: "list", expires: nil},
+			"A key":  {group: "string", expires: nil},
+		},
+		sm: map[string]string{"Name": "John", "A key": "value"},
+		lm: map[string]list{"mylist": NewListFromSlice([]string{"hi"})},
+	}
+	data := "*2\r\n$5\r\ndebug\r\n$8\r\nkeyspace\r\n"
+
+	testCases := []struct {
+		desc    string
+		enabled bool
+		want    string
+	}{
+		{
+			desc:    "lists keys sorted by name",
+			enabled: true,
+			want: SerializeBulkString("key:\"A key\" group:string expires:-1\n" +
+				fmt.Sprintf("key:\"Name\" group:string expires:%d\n", later.UnixMilli()) +
+				"key:\"mylist\" group:list expires:-1\n"),
+		},
+		{
+			desc:    "disabled by default",
+			enabled: false,
+			want:    "-ERR DEBUG KEYSPACE is disabled\r\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(testCase{now: now, initialState: state}, t)
+			app.config.Store(&ApplicationConfiguration{DebugKeyspace: tC.enabled})
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(data, srv, t)
+			defer conn.Close()
+
+			buf := make([]byte, 4096)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read from connection: %s", err)
+			}
+
+			if got := string(buf[:n]); got != tC.want {
+				t.Errorf("got: %#v. want: %#v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestDebugReloadCommand(t *testing.T) {
+	now := time.Now()
+	later := time.Unix(now.Unix()+3600, 0)
+	state := mapState{
+		ks: map[string]keyspaceEntry{
+			"Name":   {group: "string", expires: nil},
+			"Later":  {group: "string", expires: &later},
+			"mylist": {group: "list", expires: nil},
+			"myset":  {group: "sorted-set", expires: nil},
+		},
+		sm: map[string]string{"Name": "John", "Later": "Mary"},
+		lm: map[string]list{"mylist": NewListFromSlice([]string{"hi", "there"})},
+		tm: func() map[string]rbtState {
+			tree := NewTree[float64, string]()
+			tree.Put(1, "Norem")
+			tree.Put(2.5, "Ford")
+
+			return map[string]rbtState{"myset": {tree: *tree, keys: []float64{1, 2.5}, values: []string{"Norem", "Ford"}}}
+		}(),
+	}
+
+	tC := testCase{
+		now:          now,
+		desc:         "reload keeps the dataset",
+		data:         "*2\r\n$5\r\ndebug\r\n$6\r\nreload\r\n",
+		want:         []byte(OK_SIMPLE_STRING),
+		initialState: state,
+		wantState:    state,
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn := makeRequestToServer(tC.data, srv, t)
+	defer conn.Close()
+
+	assertConnectionAndAppState(t, tC, conn, app)
+}
+
+func TestTimeCommand(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "current time in seconds and microseconds",
+			data: "*1\r\n$4\r\ntime\r\n",
+			want: []byte("*2\r\n$10\r\n1700000000\r\n$6\r\n123456\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "wrong number of arguments",
+			data: "*2\r\n$4\r\ntime\r\n$3\r\nnow\r\n",
+			want: []byte("-ERR wrong number of arguments for 'time' command\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestMemoryUsageCommand(t *testing.T) {
+	now := time.Now()
+	stringUsage := entryOverhead + stringOverhead + int64(len("Name")) + int64(len("John"))
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "existing key",
+			data: "*3\r\n$6\r\nmemory\r\n$5\r\nusage\r\n$4\r\nName\r\n",
+			want: []byte(SerializeInteger(stringUsage)),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "existing key with samples",
+			data: "*5\r\n$6\r\nmemory\r\n$5\r\nusage\r\n$4\r\nName\r\n$7\r\nsamples\r\n$1\r\n5\r\n",
+			want: []byte(SerializeInteger(stringUsage)),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "non existing key",
+			data: "*3\r\n$6\r\nmemory\r\n$5\r\nusage\r\n$4\r\nNone\r\n",
+			want: []byte(NIL_BULK_STRING),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestObjectFreqCommand(t *testing.T) {
+	now := time.Now()
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "key never accessed",
+			data: "*3\r\n$6\r\nobject\r\n$4\r\nfreq\r\n$4\r\nName\r\n",
+			want: []byte(":5\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "non existing key",
+			data: "*3\r\n$6\r\nobject\r\n$4\r\nfreq\r\n$4\r\nNone\r\n",
+			want: []byte(NIL_BULK_STRING),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+
+	t.Run("repeated access raises frequency", func(t *testing.T) {
+		tC := testCases[0]
+		app, srv, logger := setupApplication(tC, t)
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer("*2\r\n$3\r\nget\r\n$4\r\nName\r\n", srv, t)
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+
+		for i := 0; i < 20; i++ {
+			writeAndRead(t, conn, "*2\r\n$3\r\nget\r\n$4\r\nName\r\n")
+		}
+
+		got := writeAndRead(t, conn, tC.data)
+		freq, err := strconv.Atoi(strings.Trim(got, ":\r\n"))
+		if err != nil {
+			t.Fatalf("expected integer reply. got: %#v", got)
+		}
+
+		if freq <= lfuInitValue {
+			t.Errorf("got: %d. want more than %d", freq, lfuInitValue)
+		}
+	})
+}
+
+func TestConfigGetCommand(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "3600 1")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"single parameter", encodeRequest(t, "config", "get", "appendonly"), "*2\r\n$10\r\nappendonly\r\n$2\r\nno\r\n"},
+		{"multiple parameters", encodeRequest(t, "config", "get", "appendonly", "save"), "*4\r\n$10\r\nappendonly\r\n$2\r\nno\r\n$4\r\nsave\r\n$6\r\n3600 1\r\n"},
+		{"invalid parameter", encodeRequest(t, "config", "get", "appendonly", "maxmemory"), "-ERR invalid parameter 'maxmemory'\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestConfigSetWhileServing(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "3600 1")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	config.ProcessPerConnection = true
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	// run with -race: the writers read the configuration while the setters
+	// replace it
+	const rounds = 50
+	clients := [][]string{
+		{"config", "set", "notify-keyspace-events", "KEg"},
+		{"config", "set", "list-max-listpack-size", "7"},
+		{"config", "set", "proto-max-bulk-len", "1048576"},
+		{"set", "Name", "John"},
+		{"rpush", "Names", "John"},
+	}
+	var wg sync.WaitGroup
+	for _, request := range clients {
+		wg.Add(1)
+		go func(data string) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("could not establish connection: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 64)
+			for i := 0; i < rounds; i++ {
+				if _, err := conn.Write([]byte(data)); err != nil {
+					t.Errorf("could not write payload to server: %v", err)
+					return
+				}
+				n, err := conn.Read(buf)
+				if err != nil {
+					t.Errorf("failed to read from connection: %s", err)
+					return
+				}
+				if buf[0] == '-' {
+					t.Errorf("got an error reply: %q", buf[:n])
+					return
+				}
+			}
+		}(encodeRequest(t, request...))
+	}
+	wg.Wait()
+
+	// no CONFIG SET undid the parameters set by the others
+	got := app.configuration()
+	if !strings.ContainsRune(got.notifyKeyspaceEvents, 'g') {
+		t.Errorf("got notify-keyspace-events: %q. want it to hold 'g'", got.notifyKeyspaceEvents)
+	}
+	if got.listMaxListpackSize != 7 {
+		t.Errorf("got list-max-listpack-size: %d. want: 7", got.listMaxListpackSize)
+	}
+	if got.protoMaxBulkLen != 1048576 {
+		t.Errorf("got proto-max-bulk-len: %d. want: 1048576", got.protoMaxBulkLen)
+	}
+}
+
+func TestReloadWhileServing(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "3600 1")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	config.ProcessPerConnection = true
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	const writers = 4
+	// the writers must be able to run while a reload is in progress, even
+	// on a single CPU
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(writers + 1))
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("could not establish connection: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 64)
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if _, err := conn.Write([]byte(encodeRequest(t, "set", key, "value"))); err != nil {
+					t.Errorf("could not write payload to server: %v", err)
+					return
+				}
+				n, err := conn.Read(buf)
+				if err != nil {
+					t.Errorf("failed to read from connection: %s", err)
+					return
+				}
+				if got := string(buf[:n]); got != OK_SIMPLE_STRING {
+					t.Errorf("set %s - got: %#v. want: %#v", key, got, OK_SIMPLE_STRING)
+					return
+				}
+			}
+		}(w)
+	}
+
+	written := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(written)
+	}()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not establish connection: %v", err)
+	}
+	defer conn.Close()
+	for reloading := true; reloading; {
+		select {
+		case <-written:
+			reloading = false
+		default:
+			if got := writeAndRead(t, conn, "*2\r\n$5\r\ndebug\r\n$6\r\nreload\r\n"); got != OK_SIMPLE_STRING {
+				t.Fatalf("reload - got: %#v. want: %#v", got, OK_SIMPLE_STRING)
+			}
+		}
+	}
+
+	// every acknowledged write survived the reloads around it
+	for w := 0; w < writers; w++ {
+		for i := 0; i < rounds; i++ {
+			key := fmt.Sprintf("key-%d-%d", w, i)
+			if !app.state.keyspace.Get(key).IsValid() {
+				t.Errorf("expected %s to survive the reloads", key)
+			}
+		}
+	}
+}
+
+func TestObjectEncodingCommand(t *testing.T) {
+	now := time.Now()
+	tC := testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"Name":   {group: "string", expires: nil},
+				"mylist": {group: "list", expires: nil},
+				"myset":  {group: "sorted-set", expires: nil},
+			},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{"mylist": NewListFromSlice([]string{"a", "b"})},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(1, "Norem")
+				tree.Put(2, "Ford")
+				return map[string]rbtState{"myset": {tree: *tree}}
+			}(),
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"string", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$4\r\nName\r\n", "$3\r\nraw\r\n"},
+		{"small list", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$6\r\nmylist\r\n", "$8\r\nlistpack\r\n"},
+		{"small sorted set", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$5\r\nmyset\r\n", "$8\r\nlistpack\r\n"},
+		{"missing key", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$4\r\nNone\r\n", NIL_BULK_STRING},
+		{"set invalid threshold", "*4\r\n$6\r\nconfig\r\n$3\r\nset\r\n$22\r\nlist-max-listpack-size\r\n$2\r\n-1\r\n", "-ERR invalid value '-1' for 'list-max-listpack-size'. Must be a non-negative integer.\r\n"},
+		{"lower thresholds", "*6\r\n$6\r\nconfig\r\n$3\r\nset\r\n$22\r\nlist-max-listpack-size\r\n$1\r\n2\r\n$25\r\nzset-max-listpack-entries\r\n$1\r\n1\r\n", OK_SIMPLE_STRING},
+		{"list at threshold", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$6\r\nmylist\r\n", "$8\r\nlistpack\r\n"},
+		{"sorted set above threshold", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$5\r\nmyset\r\n", "$8\r\nskiplist\r\n"},
+		{"push over list threshold", "*3\r\n$5\r\nrpush\r\n$6\r\nmylist\r\n$1\r\nc\r\n", ":3\r\n"},
+		{"list above threshold", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$6\r\nmylist\r\n", "$10\r\nlinkedlist\r\n"},
+		{"set integer", "*3\r\n$3\r\nset\r\n$7\r\nCounter\r\n$2\r\n10\r\n", OK_SIMPLE_STRING},
+		{"integer string", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$7\r\nCounter\r\n", "$3\r\nint\r\n"},
+		{"incr integer string", "*2\r\n$4\r\nincr\r\n$7\r\nCounter\r\n", ":11\r\n"},
+		{"append to integer string", "*3\r\n$6\r\nappend\r\n$7\r\nCounter\r\n$1\r\n5\r\n", ":3\r\n"},
+		{"appended integer string", "*3\r\n$6\r\nobject\r\n$8\r\nencoding\r\n$7\r\nCounter\r\n", "$3\r\nraw\r\n"},
+		{"get appended integer string", "*2\r\n$3\r\nget\r\n$7\r\nCounter\r\n", "$3\r\n115\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestClientCommand(t *testing.T) {
+	now := time.Now()
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "no-evict is accepted",
+			data: "*3\r\n$6\r\nclient\r\n$8\r\nno-evict\r\n$2\r\non\r\n",
+			want: []byte(OK_SIMPLE_STRING),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "no-touch with invalid option",
+			data: "*3\r\n$6\r\nclient\r\n$8\r\nno-touch\r\n$3\r\nyes\r\n",
+			want: []byte("-ERR syntax error, invalid option 'yes'. Only 'on' or 'off' allowed.\r\n"),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{},
+				sm: map[string]string{},
+				lm: map[string]list{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+
+	t.Run("no-touch keeps reads from raising the frequency", func(t *testing.T) {
+		tC := testCase{
+			now: now,
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+				sm: map[string]string{"Name": "John"},
+				lm: map[string]list{},
+			},
+		}
+		app, srv, logger := setupApplication(tC, t)
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer("*3\r\n$6\r\nclient\r\n$8\r\nno-touch\r\n$2\r\non\r\n", srv, t)
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+		if got := string(buf[:n]); got != OK_SIMPLE_STRING {
+			t.Fatalf("got: %#v. want: %#v", got, OK_SIMPLE_STRING)
+		}
+
+		freq := "*3\r\n$6\r\nobject\r\n$4\r\nfreq\r\n$4\r\nName\r\n"
+		for i := 0; i < 20; i++ {
+			writeAndRead(t, conn, "*2\r\n$3\r\nget\r\n$4\r\nName\r\n")
+		}
+		if got := writeAndRead(t, conn, freq); got != ":5\r\n" {
+			t.Errorf("expected frequency to be unchanged. got: %#v", got)
+		}
+
+		writeAndRead(t, conn, "*3\r\n$6\r\nclient\r\n$8\r\nno-touch\r\n$3\r\noff\r\n")
+		for i := 0; i < 20; i++ {
+			writeAndRead(t, conn, "*2\r\n$3\r\nget\r\n$4\r\nName\r\n")
+		}
+		if got := writeAndRead(t, conn, freq); got == ":5\r\n" {
+			t.Errorf("expected frequency to increase after no-touch is off. got: %#v", got)
+		}
+	})
+}
+
+func TestHelpSubcommands(t *testing.T) {
+	now := time.Now()
+	state := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+
+	testCases := []testCase{
+		{
+			now:  now,
+			desc: "object help",
+			data: "*2\r\n$6\r\nobject\r\n$4\r\nhelp\r\n",
+			want: []byte("*7\r\n" +
+				"+OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:\r\n" +
+				"+ENCODING <key>\r\n" +
+				"+    Return the kind of internal representation used in order to store the value associated with a <key>.\r\n" +
+				"+FREQ <key>\r\n" +
+				"+    Return the logarithmic access frequency counter of <key>.\r\n" +
+				"+HELP\r\n" +
+				"+    Print this help.\r\n"),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "client help",
+			data:         "*2\r\n$6\r\nclient\r\n$4\r\nhelp\r\n",
+			want:         []byte(serializeHelp(commandHelp[CLIENT])),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "pubsub help",
+			data:         "*2\r\n$6\r\npubsub\r\n$4\r\nhelp\r\n",
+			want:         []byte(serializeHelp(commandHelp[PUBSUB])),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "config help",
+			data:         "*2\r\n$6\r\nconfig\r\n$4\r\nHELP\r\n",
+			want:         []byte(serializeHelp(commandHelp[CONFIG])),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "debug help",
+			data:         "*2\r\n$5\r\ndebug\r\n$4\r\nhelp\r\n",
+			want:         []byte(serializeHelp(commandHelp[DEBUG])),
+			initialState: state,
+			wantState:    state,
+		},
+		{
+			now:          now,
+			desc:         "memory help",
+			data:         "*2\r\n$6\r\nmemory\r\n$4\r\nhelp\r\n",
+			want:         []byte(serializeHelp(commandHelp[MEMORY])),
+			initialState: state,
+			wantState:    state,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestReplicaOfCommand(t *testing.T) {
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	tC := testCase{now: time.Now(), initialState: emptyState, wantState: emptyState}
+
+	master, masterSrv, masterLogger := setupApplication(tC, t)
+	go func() { Listen(masterSrv, master, masterLogger) }()
+
+	replica, replicaSrv, replicaLogger := setupApplication(tC, t)
+	go func() { Listen(replicaSrv, replica, replicaLogger) }()
+
+	conn, err := net.Dial("tcp", replicaSrv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not establish connection: %v", err)
+	}
+	defer conn.Close()
+
+	host, port, err := net.SplitHostPort(masterSrv.Addr().String())
+	if err != nil {
+		t.Fatalf("invalid master address: %v", err)
+	}
+
+	t.Run("handshake with master", func(t *testing.T) {
+		request := fmt.Sprintf("*3\r\n$9\r\nreplicaof\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		if got := writeAndRead(t, conn, request); got != OK_SIMPLE_STRING {
+			t.Fatalf("got: %q. want: %q", got, OK_SIMPLE_STRING)
+		}
+
+		select {
+		case <-replica.replication.HandshakeDone():
+		case <-time.After(5 * time.Second):
+			t.Fatal("handshake did not finish in time")
+		}
+
+		if err := replica.replication.HandshakeErr(); err != nil {
+			t.Errorf("expected handshake to succeed. got: %v", err)
+		}
+
+		if got := replica.replication.Master(); got != masterSrv.Addr().String() {
+			t.Errorf("got master: %s. want: %s", got, masterSrv.Addr().String())
+		}
+	})
+
+	t.Run("same master is not connected again", func(t *testing.T) {
+		request := fmt.Sprintf("*3\r\n$7\r\nslaveof\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		want := "+OK Already connected to specified master\r\n"
+		if got := writeAndRead(t, conn, request); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	})
+
+	t.Run("invalid port", func(t *testing.T) {
+		want := "-ERR Invalid master port\r\n"
+		if got := writeAndRead(t, conn, "*3\r\n$9\r\nreplicaof\r\n$9\r\nlocalhost\r\n$4\r\nport\r\n"); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	})
+
+	t.Run("stop replicating", func(t *testing.T) {
+		if got := writeAndRead(t, conn, "*3\r\n$9\r\nreplicaof\r\n$2\r\nno\r\n$3\r\none\r\n"); got != OK_SIMPLE_STRING {
+			t.Fatalf("got: %q. want: %q", got, OK_SIMPLE_STRING)
+		}
+
+		if got := replica.replication.Master(); got != "" {
+			t.Errorf("expected no master. got: %s", got)
+		}
+	})
+
+	t.Run("change replication id", func(t *testing.T) {
+		if got := writeAndRead(t, conn, "*2\r\n$5\r\ndebug\r\n$14\r\nchange-repl-id\r\n"); got != OK_SIMPLE_STRING {
+			t.Errorf("got: %q. want: %q", got, OK_SIMPLE_STRING)
+		}
+	})
+}
+
+func TestStringCommandsRefuseOtherGroups(t *testing.T) {
+	now := time.Now()
+	state := func() mapState {
+		tree := NewTree[float64, string]()
+		tree.Put(1, "Norem")
+
+		return mapState{
+			ks: map[string]keyspaceEntry{
+				"mylist": {group: "list", expires: nil},
+				"myset":  {group: "sorted-set", expires: nil},
+			},
+			sm: map[string]string{},
+			lm: map[string]list{"mylist": NewListFromSlice([]string{"1"})},
+			tm: map[string]rbtState{"myset": {tree: *tree, keys: []float64{1}, values: []string{"Norem"}}},
+		}
+	}
+
+	commands := []struct {
+		name string
+		args []string
+	}{
+		{name: "append", args: []string{"1"}},
+		{name: "incr"},
+		{name: "decr"},
+		{name: "incrby", args: []string{"1"}},
+		{name: "decrby", args: []string{"1"}},
+	}
+
+	testCases := []testCase{}
+	for _, key := range []string{"mylist", "myset"} {
+		for _, cmd := range commands {
+			request := append([]string{cmd.name, key}, cmd.args...)
+			data := fmt.Sprintf("*%d\r\n", len(request))
+			for _, arg := range request {
+				data += SerializeBulkString(arg)
+			}
+
+			testCases = append(testCases, testCase{
+				now:          now,
+				desc:         cmd.name + " on " + key,
+				data:         data,
+				want:         []byte(fmt.Sprintf("-WRONGTYPE key '%s' does not support this operation\r\n", key)),
+				initialState: state(),
+				wantState:    state(),
+			})
+		}
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestIdGenCommand(t *testing.T) {
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	tC := testCase{now: time.Now(), initialState: emptyState, wantState: emptyState}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not establish connection: %v", err)
+	}
+	defer conn.Close()
+
+	idGen := func(namespace string) string {
+		return writeAndRead(t, conn, fmt.Sprintf("*2\r\n$5\r\nidgen\r\n$%d\r\n%s\r\n", len(namespace), namespace))
+	}
+
+	for _, want := range []string{":1\r\n", ":2\r\n", ":3\r\n"} {
+		if got := idGen("users"); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	}
+
+	if got := idGen("orders"); got != ":1\r\n" {
+		t.Errorf("expected namespaces to be independent. got: %q", got)
+	}
+
+	if got := writeAndRead(t, conn, "*2\r\n$5\r\ndebug\r\n$6\r\nreload\r\n"); got != OK_SIMPLE_STRING {
+		t.Fatalf("got: %q. want: %q", got, OK_SIMPLE_STRING)
+	}
+
+	if got := idGen("users"); got != ":4\r\n" {
+		t.Errorf("expected counter to survive a reload. got: %q", got)
+	}
+}
+
+// encodeRequest serializes args as the array of bulk strings sent by clients.
+func encodeRequest(t *testing.T, args ...string) string {
+	var b strings.Builder
+	if err := WriteBulkStringArray(&b, args); err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	return b.String()
+}
+
+func TestEvalCommand(t *testing.T) {
+	now := time.Now()
+	request := func(args ...string) string {
+		return encodeRequest(t, append([]string{"eval"}, args...)...)
+	}
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+	counterState := mapState{
+		ks: map[string]keyspaceEntry{"counter": {group: "string", expires: nil}},
+		sm: map[string]string{"counter": "15"},
+		lm: map[string]list{},
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "two command script replies with the last reply",
+			data:         request("SET KEYS[1] ARGV[1]\nINCRBY KEYS[1] ARGV[2]", "1", "counter", "10", "5"),
+			want:         []byte(":15\r\n"),
+			initialState: emptyState,
+			wantState:    counterState,
+		},
+		{
+			now:          now,
+			desc:         "blank lines and extra spaces are skipped",
+			data:         request("\n  SET   KEYS[1] 10 \n\nINCRBY KEYS[1] ARGV[1]\n", "1", "counter", "5"),
+			want:         []byte(":15\r\n"),
+			initialState: emptyState,
+			wantState:    counterState,
+		},
+		{
+			now:          now,
+			desc:         "script stops at the first error",
+			data:         request("SET KEYS[1] 15\nZADD KEYS[1] 1 a\nSET KEYS[1] 20", "1", "counter"),
+			want:         []byte("-WRONGTYPE key 'counter' does not support this operation\r\n"),
+			initialState: emptyState,
+			wantState:    counterState,
+		},
+		{
+			now:          now,
+			desc:         "missing binding returns error",
+			data:         request("SET KEYS[1] ARGV[2]", "1", "counter", "10"),
+			want:         []byte("-ERR script references ARGV[2] but ARGV has 1 elements\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+		{
+			now:          now,
+			desc:         "more keys than arguments returns error",
+			data:         request("GET KEYS[1]", "2", "counter"),
+			want:         []byte("-ERR Number of keys can't be greater than number of args\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+		{
+			now:          now,
+			desc:         "denied command returns error",
+			data:         request("EVAL x 0", "0"),
+			want:         []byte("-ERR command 'eval' is not allowed from scripts\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+		{
+			now:          now,
+			desc:         "invalid command returns error",
+			data:         request("NOPE KEYS[1]", "1", "counter"),
+			want:         []byte("-ERR invalid command: 'nope'\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestMSetExCommand(t *testing.T) {
+	now := time.Now()
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "sets every key with the same expiry",
+			data:         "*6\r\n$6\r\nmsetex\r\n$2\r\n10\r\n$4\r\nName\r\n$4\r\nJohn\r\n$7\r\nSurname\r\n$3\r\nDoe\r\n",
+			want:         []byte(OK_SIMPLE_STRING),
+			initialState: emptyState,
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{
+					"Name":    {group: "string", expires: getFuture(now, 10)},
+					"Surname": {group: "string", expires: getFuture(now, 10)},
+				},
+				sm: map[string]string{"Name": "John", "Surname": "Doe"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:  now,
+			desc: "replaces keys of other groups",
+			data: "*4\r\n$6\r\nmsetex\r\n$1\r\n5\r\n$6\r\nmylist\r\n$2\r\nhi\r\n",
+			want: []byte(OK_SIMPLE_STRING),
+			initialState: mapState{
+				ks: map[string]keyspaceEntry{"mylist": {group: "list", expires: nil}},
+				sm: map[string]string{},
+				lm: map[string]list{"mylist": NewListFromSlice([]string{"a"})},
+			},
+			wantState: mapState{
+				ks: map[string]keyspaceEntry{"mylist": {group: "string", expires: getFuture(now, 5)}},
+				sm: map[string]string{"mylist": "hi"},
+				lm: map[string]list{},
+			},
+		},
+		{
+			now:          now,
+			desc:         "odd number of keys and values returns error",
+			data:         "*5\r\n$6\r\nmsetex\r\n$2\r\n10\r\n$4\r\nName\r\n$4\r\nJohn\r\n$7\r\nSurname\r\n",
+			want:         []byte("-ERR wrong number of arguments for 'msetex' command\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+		{
+			now:          now,
+			desc:         "non positive seconds returns error",
+			data:         "*4\r\n$6\r\nmsetex\r\n$1\r\n0\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+			want:         []byte("-ERR invalid expire time in 'msetex' command\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+		{
+			now:          now,
+			desc:         "invalid seconds returns error",
+			data:         "*4\r\n$6\r\nmsetex\r\n$3\r\nten\r\n$4\r\nName\r\n$4\r\nJohn\r\n",
+			want:         []byte("-ERR invalid expire time in 'msetex' command\r\n"),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+
+	t.Run("snapshot records the expiry of every key", func(t *testing.T) {
+		tC := testCases[0]
+		app, srv, logger := setupApplication(testCase{now: now, initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		}}, t)
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer(tC.data, srv, t)
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+
+		var snapshot bytes.Buffer
+		if err := app.state.Save(&snapshot, SnapshotRESP); err != nil {
+			t.Fatalf("failed to save snapshot: %v", err)
+		}
+
+		stamp := fmt.Sprint(getFuture(now, 10).UnixMilli())
+		for _, key := range []string{"Name", "Surname"} {
+			want := fmt.Sprintf("*3\r\n$9\r\npexpireat\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(stamp), stamp)
+			if !strings.Contains(snapshot.String(), want) {
+				t.Errorf("expected snapshot to contain %q. got: %q", want, snapshot.String())
+			}
+		}
+	})
+}
+
+func TestZCombineCommands(t *testing.T) {
+	now := time.Now()
+	state := func() mapState {
+		a := NewTree[float64, string]()
+		a.Put(1, "x")
+		a.Put(2, "y")
+		b := NewTree[float64, string]()
+		b.Put(10, "y")
+		b.Put(20, "z")
+
+		return mapState{
+			ks: map[string]keyspaceEntry{
+				"a":    {group: "sorted-set", expires: nil},
+				"b":    {group: "sorted-set", expires: nil},
+				"Name": {group: "string", expires: nil},
+			},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+			tm: map[string]rbtState{"a": {tree: *a}, "b": {tree: *b}},
+		}
+	}
+
+	// unchanged is the state assertion of the sets built by state
+	unchanged := func() mapState {
+		want := state()
+		want.tm = map[string]rbtState{
+			"a": {keys: []float64{1, 2}, values: []string{"x", "y"}},
+			"b": {keys: []float64{10, 20}, values: []string{"y", "z"}},
+		}
+		return want
+	}
+
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "union sums weighted scores",
+			data:         encodeRequest(t, "zunion", "2", "a", "b", "WEIGHTS", "2", "3", "WITHSCORES"),
+			want:         []byte(encodeRequest(t, "x", "2", "y", "34", "z", "60")),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "union without options",
+			data:         encodeRequest(t, "zunion", "2", "a", "b"),
+			want:         []byte(encodeRequest(t, "x", "y", "z")),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "intersection aggregates with max",
+			data:         encodeRequest(t, "zinter", "2", "a", "b", "weights", "2", "3", "aggregate", "max", "withscores"),
+			want:         []byte(encodeRequest(t, "y", "30")),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "intersection with missing key is empty",
+			data:         encodeRequest(t, "zinter", "2", "a", "missing"),
+			want:         []byte("*0\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "difference keeps members only in the first set",
+			data:         encodeRequest(t, "zdiff", "2", "a", "b", "WITHSCORES"),
+			want:         []byte(encodeRequest(t, "x", "1")),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "difference does not accept weights",
+			data:         encodeRequest(t, "zdiff", "2", "a", "b", "WEIGHTS", "1", "1"),
+			want:         []byte("-ERR syntax error, invalid option 'WEIGHTS'\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "union store writes the weighted sum to destination",
+			data:         encodeRequest(t, "zunionstore", "Name", "2", "a", "b", "WEIGHTS", "2", "3"),
+			want:         []byte(":3\r\n"),
+			initialState: state(),
+			wantState: func() mapState {
+				want := unchanged()
+				want.ks["Name"] = keyspaceEntry{group: "sorted-set", expires: nil}
+				want.sm = map[string]string{}
+				want.tm["Name"] = rbtState{keys: []float64{2, 34, 60}, values: []string{"x", "y", "z"}}
+				return want
+			}(),
+		},
+		{
+			now:          now,
+			desc:         "empty store result deletes destination",
+			data:         encodeRequest(t, "zinterstore", "a", "2", "b", "missing"),
+			want:         []byte(":0\r\n"),
+			initialState: state(),
+			wantState: func() mapState {
+				want := unchanged()
+				delete(want.ks, "a")
+				delete(want.tm, "a")
+				return want
+			}(),
+		},
+		{
+			now:          now,
+			desc:         "key of other group returns error",
+			data:         encodeRequest(t, "zunion", "2", "a", "Name"),
+			want:         []byte("-WRONGTYPE key 'Name' does not support this operation\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "zero keys returns error",
+			data:         encodeRequest(t, "zunion", "0", "a"),
+			want:         []byte("-ERR at least 1 input key is needed for 'zunion' command\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "missing weights return error",
+			data:         encodeRequest(t, "zunion", "2", "a", "b", "WEIGHTS", "2"),
+			want:         []byte("-ERR wrong number of arguments for 'zunion' command\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+		{
+			now:          now,
+			desc:         "invalid aggregate returns error",
+			data:         encodeRequest(t, "zunion", "2", "a", "b", "AGGREGATE", "avg"),
+			want:         []byte("-ERR invalid aggregate 'avg'. Only 'sum', 'min' or 'max' allowed.\r\n"),
+			initialState: state(),
+			wantState:    unchanged(),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestInfoCommand(t *testing.T) {
+	now := time.Now()
+	state := func() mapState {
+		return mapState{
+			ks: map[string]keyspaceEntry{
+				"Name":    {group: "string", expires: nil},
+				"Session": {group: "string", expires: getFuture(now, 10)},
+				"Old":     {group: "string", expires: getFuture(now, -10)},
+				"Names":   {group: "list", expires: nil},
+			},
+			sm: map[string]string{"Name": "John", "Session": "abc", "Old": "value"},
+			lm: map[string]list{"Names": NewListFromSlice([]string{"John"})},
+		}
+	}
+	emptyState := mapState{
+		ks: map[string]keyspaceEntry{},
+		sm: map[string]string{},
+		lm: map[string]list{},
+	}
+
+	keyspace := SerializeBulkString("# Keyspace\r\ndb0:keys=3,expires=1,avg_ttl=0\r\n")
+	testCases := []testCase{
+		{
+			now:          now,
+			desc:         "all sections",
+			data:         encodeRequest(t, "info"),
+			want:         []byte(SerializeBulkString("# Stats\r\nwrong_type_errors:0\r\n\r\n# Keyspace\r\ndb0:keys=3,expires=1,avg_ttl=0\r\n")),
+			initialState: state(),
+			wantState:    state(),
+		},
+		{
+			now:          now,
+			desc:         "keyspace section",
+			data:         encodeRequest(t, "info", "KEYSPACE"),
+			want:         []byte(keyspace),
+			initialState: state(),
+			wantState:    state(),
+		},
+		{
+			now:          now,
+			desc:         "unknown section",
+			data:         encodeRequest(t, "info", "clients"),
+			want:         []byte("$0\r\n\r\n"),
+			initialState: state(),
+			wantState:    state(),
+		},
+		{
+			now:          now,
+			desc:         "empty databases are left out",
+			data:         encodeRequest(t, "info", "keyspace"),
+			want:         []byte(SerializeBulkString("# Keyspace\r\n")),
+			initialState: emptyState,
+			wantState:    emptyState,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			app, srv, logger := setupApplication(tC, t)
+
+			go func() { Listen(srv, app, logger) }()
+
+			conn := makeRequestToServer(tC.data, srv, t)
+			defer conn.Close()
+
+			assertConnectionAndAppState(t, tC, conn, app)
+		})
+	}
+}
+
+func TestWrongTypeErrors(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Names": {group: "list", expires: nil}},
+			sm: map[string]string{},
+			lm: map[string]list{"Names": NewListFromSlice([]string{"John"})},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	logs := new(bytes.Buffer)
+	app.logger = slog.New(slog.NewTextHandler(logs, &testLogOpts))
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"wrong type", encodeRequest(t, "incr", "Names"), "-WRONGTYPE key 'Names' does not support this operation\r\n"},
+		{"get treats it as missing", encodeRequest(t, "get", "Names"), NIL_BULK_STRING},
+		{"right type", encodeRequest(t, "rpush", "Names", "Jane"), ":2\r\n"},
+		{"missing key", encodeRequest(t, "incr", "Counter"), ":1\r\n"},
+		{"counted", encodeRequest(t, "info", "stats"), SerializeBulkString("# Stats\r\nwrong_type_errors:2\r\n\r\n")},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+
+	if got := app.wrongTypeErrors.Load(); got != 2 {
+		t.Errorf("got: %d wrong type errors. want: 2", got)
+	}
+	if got := logs.String(); !strings.Contains(got, "level=DEBUG msg=\"wrong type\" command=incr key=Names") {
+		t.Errorf("expected a debug log of the wrong type error. got logs:\n%s", got)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		processed []string
+		want      []string
+	}{
+		{desc: "regular command", processed: []string{"SET", "Name", "John"}, want: []string{"Name", "John"}},
+		{desc: "auth password", processed: []string{"AUTH", "secret"}, want: []string{redactedArg}},
+		{desc: "auth username and password", processed: []string{"auth", "default", "secret"}, want: []string{redactedArg, redactedArg}},
+		{desc: "hello auth", processed: []string{"HELLO", "3", "AUTH", "default", "secret", "SETNAME", "app"}, want: []string{"3", "AUTH", redactedArg, redactedArg, "SETNAME", "app"}},
+		{desc: "hello without auth", processed: []string{"HELLO", "3"}, want: []string{"3"}},
+		{desc: "config set requirepass", processed: []string{"CONFIG", "SET", "save", "60 1", "RequirePass", "secret"}, want: []string{"SET", "save", "60 1", "RequirePass", redactedArg}},
+		{desc: "config get requirepass", processed: []string{"CONFIG", "GET", "requirepass"}, want: []string{"GET", "requirepass"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got := redactArgs(tC.processed)
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got: %#v. want: %#v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestRequestLogsAreRedacted(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &testLogOpts))
+	config, err := NewApplicationConfiguration("no", "")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	app := NewApplication(config, TestClockTimer{mockNow: time.Now()}, logger)
+
+	requests := []string{
+		encodeRequest(t, "auth", "default", "hunter2"),
+		encodeRequest(t, "config", "set", "requirepass", "hunter2"),
+		encodeRequest(t, "set", "Name", "John"),
+	}
+	for _, req := range requests {
+		app.ProcessRequest(Message{raw: []byte(req)})
+	}
+
+	got := logs.String()
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("expected the password to be redacted. got logs:\n%s", got)
+	}
+
+	for _, want := range []string{"command=auth args=2", "command=config args=3", "command=set args=2 argv=\"[Name John]\""} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected logs to contain '%s'. got logs:\n%s", want, got)
+		}
+	}
+}
+
+func TestRandomKeyCommand(t *testing.T) {
+	now := time.Now()
+	tC := testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"Name":    {group: "string", expires: nil},
+				"Surname": {group: "string", expires: nil},
+				"Old":     {group: "list", expires: getFuture(now, -10)},
+				"Names":   {group: "list", expires: nil},
+				"Queue":   {group: "list", expires: nil},
+				"Scores":  {group: "sorted-set", expires: nil},
+			},
+			sm: map[string]string{"Name": "John", "Surname": "Doe"},
+			lm: map[string]list{
+				"Old":   NewListFromSlice([]string{"a"}),
+				"Names": NewListFromSlice([]string{"John"}),
+				"Queue": NewListFromSlice([]string{"job"}),
+			},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(1, "John")
+				return map[string]rbtState{"Scores": {tree: *tree}}
+			}(),
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	draws := []struct {
+		desc string
+		args []string
+		want []string
+	}{
+		{desc: "any type", args: []string{"randomkey"}, want: []string{"Name", "Surname", "Names", "Queue", "Scores"}},
+		{desc: "list type", args: []string{"randomkey", "type", "list"}, want: []string{"Names", "Queue"}},
+		{desc: "string type", args: []string{"randomkey", "TYPE", "string"}, want: []string{"Name", "Surname"}},
+		{desc: "zset type", args: []string{"randomkey", "type", "zset"}, want: []string{"Scores"}},
+	}
+	for _, draw := range draws {
+		for i := 0; i < 20; i++ {
+			got := writeAndRead(t, conn, encodeRequest(t, draw.args...))
+
+			valid := false
+			for _, key := range draw.want {
+				if got == SerializeBulkString(key) {
+					valid = true
+				}
+			}
+			if !valid {
+				t.Fatalf("%s - got: %#v. want one of: %v", draw.desc, got, draw.want)
+			}
+		}
+	}
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"unknown type", encodeRequest(t, "randomkey", "type", "hash"), "-ERR unknown type name 'hash'\r\n"},
+		{"invalid option", encodeRequest(t, "randomkey", "count", "1"), "-ERR syntax error, invalid option 'count'\r\n"},
+		{"missing type", encodeRequest(t, "randomkey", "type"), "-ERR syntax error\r\n"},
+		{"delete the only zset", encodeRequest(t, "del", "Scores"), ":1\r\n"},
+		{"nil when no key of type", encodeRequest(t, "randomkey", "type", "zset"), NIL_BULK_STRING},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestProtoMaxBulkLen(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"default limit", encodeRequest(t, "config", "get", "proto-max-bulk-len"), "*2\r\n$18\r\nproto-max-bulk-len\r\n$9\r\n536870912\r\n"},
+		{"invalid limit", encodeRequest(t, "config", "set", "proto-max-bulk-len", "0"), "-ERR invalid value '0' for 'proto-max-bulk-len'. Must be a positive integer.\r\n"},
+		{"lower limit", encodeRequest(t, "config", "set", "proto-max-bulk-len", "8"), OK_SIMPLE_STRING},
+		{"append up to the limit", encodeRequest(t, "append", "Name", " Doe"), ":8\r\n"},
+		{"append past the limit", encodeRequest(t, "append", "Name", "!"), "-ERR string exceeds maximum allowed size\r\n"},
+		{"value is kept", encodeRequest(t, "get", "Name"), "$8\r\nJohn Doe\r\n"},
+		{"append past the limit to missing key", encodeRequest(t, "append", "Other", "123456789"), "-ERR string exceeds maximum allowed size\r\n"},
+		{"missing key is not created", encodeRequest(t, "exists", "Other"), ":0\r\n"},
+		{"set past the limit", encodeRequest(t, "set", "Name", "123456789"), "-ERR string exceeds maximum allowed size\r\n"},
+		{"msetex past the limit", encodeRequest(t, "msetex", "10", "A", "1", "B", "123456789"), "-ERR string exceeds maximum allowed size\r\n"},
+		{"msetex sets nothing", encodeRequest(t, "exists", "A"), ":0\r\n"},
+		{"set up to the limit", encodeRequest(t, "set", "Name", "12345678"), OK_SIMPLE_STRING},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"Name":  {group: "string", expires: nil},
+				"Names": {group: "list", expires: nil},
+			},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{"Names": NewListFromSlice([]string{"John"})},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	config, err := NewApplicationConfiguration("no", "")
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	config.ReadOnly = true
+	app.config.Store(config)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	readOnly := "-READONLY You can't write against a read only replica\r\n"
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"get", encodeRequest(t, "get", "Name"), "$4\r\nJohn\r\n"},
+		{"exists", encodeRequest(t, "exists", "Name", "Names"), ":2\r\n"},
+		{"set", encodeRequest(t, "set", "Name", "Jane"), readOnly},
+		{"del", encodeRequest(t, "del", "Name"), readOnly},
+		{"rpush", encodeRequest(t, "rpush", "Names", "Jane"), readOnly},
+		{"write inside a script", encodeRequest(t, "eval", "SET KEYS[1] Jane", "1", "Name"), readOnly},
+		{"read inside a script", encodeRequest(t, "eval", "GET KEYS[1]", "1", "Name"), "$4\r\nJohn\r\n"},
+		{"arity is checked first", encodeRequest(t, "set", "Name"), "-ERR wrong number of arguments for 'set' command\r\n"},
+		{"value is kept", encodeRequest(t, "get", "Name"), "$4\r\nJohn\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestCasCommand(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"match", encodeRequest(t, "cas", "Name", "John", "Jane"), ":1\r\n"},
+		{"value is swapped", encodeRequest(t, "get", "Name"), "$4\r\nJane\r\n"},
+		{"mismatch", encodeRequest(t, "cas", "Name", "John", "Mary"), ":0\r\n"},
+		{"value is kept", encodeRequest(t, "get", "Name"), "$4\r\nJane\r\n"},
+		{"absent key", encodeRequest(t, "cas", "Other", "", "new"), ":1\r\n"},
+		{"absent key is created", encodeRequest(t, "get", "Other"), "$3\r\nnew\r\n"},
+		{"wrong number of arguments", encodeRequest(t, "cas", "Name", "Jane"), "-ERR wrong number of arguments for 'cas' command\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestZRangeOptions(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"myset":   {group: "sorted-set", expires: nil},
+				"letters": {group: "sorted-set", expires: nil},
+				"Name":    {group: "string", expires: nil},
+			},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(10, "Norem")
+				tree.Put(12, "Castilla")
+				tree.Put(8, "Sam-Bodden")
+				tree.Put(10, "Royce")
+				tree.Put(6, "Ford")
+				tree.Put(14, "Prickett")
+
+				letters := NewTree[float64, string]()
+				for _, l := range []string{"a", "b", "c", "d", "e"} {
+					letters.Put(0, l)
+				}
+				return map[string]rbtState{"myset": {tree: *tree}, "letters": {tree: *letters}}
+			}(),
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	array := func(values ...string) string {
+		var b strings.Builder
+		WriteBulkStringArray(&b, values)
+		return b.String()
+	}
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"ranks are inclusive", encodeRequest(t, "zrange", "myset", "0", "1"), array("Ford", "Sam-Bodden")},
+		{"ranks past the end are clamped", encodeRequest(t, "zrange", "myset", "4", "100"), array("Castilla", "Prickett")},
+		{"start past stop", encodeRequest(t, "zrange", "myset", "3", "1"), array()},
+		{"large ranks", encodeRequest(t, "zrange", "myset", "-1000", "1000"), array("Ford", "Sam-Bodden", "Norem", "Royce", "Castilla", "Prickett")},
+		{"extreme ranks", encodeRequest(t, "zrange", "myset", "-9223372036854775808", "9223372036854775807"), array("Ford", "Sam-Bodden", "Norem", "Royce", "Castilla", "Prickett")},
+		{"rank in another base", encodeRequest(t, "zrange", "myset", "0x0", "1"), "-ERR value is not an integer or out of range\r\n"},
+		{"rank out of range", encodeRequest(t, "zrange", "myset", "0", "9223372036854775808"), "-ERR value is not an integer or out of range\r\n"},
+		{"rev", encodeRequest(t, "zrange", "myset", "0", "2", "rev"), array("Prickett", "Castilla", "Royce")},
+		{"rev with scores", encodeRequest(t, "zrange", "myset", "0", "0", "REV", "WITHSCORES"), array("Prickett", "14")},
+		{"rev from the end", encodeRequest(t, "zrange", "myset", "-3", "-2", "rev"), array("Norem", "Sam-Bodden")},
+		{"byscore", encodeRequest(t, "zrange", "myset", "8", "10", "byscore"), array("Sam-Bodden", "Norem", "Royce")},
+		{"byscore exclusive", encodeRequest(t, "zrange", "myset", "(8", "(12", "byscore"), array("Norem", "Royce")},
+		{"byscore infinite", encodeRequest(t, "zrange", "myset", "-inf", "+inf", "byscore", "withscores"), array("Ford", "6", "Sam-Bodden", "8", "Norem", "10", "Royce", "10", "Castilla", "12", "Prickett", "14")},
+		{"byscore rev", encodeRequest(t, "zrange", "myset", "12", "8", "byscore", "rev"), array("Castilla", "Royce", "Norem", "Sam-Bodden")},
+		{"byscore rev with bounds in order", encodeRequest(t, "zrange", "myset", "8", "12", "byscore", "rev"), array()},
+		{"byscore limit", encodeRequest(t, "zrange", "myset", "-inf", "+inf", "byscore", "limit", "1", "2"), array("Sam-Bodden", "Norem")},
+		{"byscore limit negative count", encodeRequest(t, "zrange", "myset", "-inf", "+inf", "byscore", "limit", "4", "-1"), array("Castilla", "Prickett")},
+		{"byscore limit offset past the end", encodeRequest(t, "zrange", "myset", "-inf", "+inf", "byscore", "limit", "10", "1"), array()},
+		{"byscore rev limit", encodeRequest(t, "zrange", "myset", "+inf", "-inf", "byscore", "rev", "limit", "0", "1"), array("Prickett")},
+		{"byscore rev limit with offset", encodeRequest(t, "zrange", "myset", "12", "-inf", "byscore", "rev", "limit", "1", "2"), array("Royce", "Norem")},
+		{"byscore invalid bound", encodeRequest(t, "zrange", "myset", "x", "10", "byscore"), "-ERR min or max is not a float\r\n"},
+		{"bylex", encodeRequest(t, "zrange", "letters", "[b", "(d", "bylex"), array("b", "c")},
+		{"bylex infinite", encodeRequest(t, "zrange", "letters", "-", "+", "bylex"), array("a", "b", "c", "d", "e")},
+		{"bylex rev", encodeRequest(t, "zrange", "letters", "[d", "-", "bylex", "rev"), array("d", "c", "b", "a")},
+		{"bylex limit", encodeRequest(t, "zrange", "letters", "-", "+", "bylex", "limit", "2", "2"), array("c", "d")},
+		{"bylex invalid bound", encodeRequest(t, "zrange", "letters", "b", "+", "bylex"), "-ERR min or max not valid string range item\r\n"},
+		{"bylex with scores", encodeRequest(t, "zrange", "letters", "-", "+", "bylex", "withscores"), "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{"limit by rank", encodeRequest(t, "zrange", "myset", "0", "-1", "limit", "0", "1"), "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{"limit without count", encodeRequest(t, "zrange", "myset", "0", "-1", "byscore", "limit", "0"), "-ERR syntax error\r\n"},
+		{"limit not an integer", encodeRequest(t, "zrange", "myset", "0", "-1", "byscore", "limit", "x", "1"), "-ERR value is not an integer or out of range\r\n"},
+		{"missing key", encodeRequest(t, "zrange", "none", "0", "-1"), array()},
+		{"missing key byscore rev", encodeRequest(t, "zrange", "none", "+inf", "-inf", "byscore", "rev", "withscores"), array()},
+		{"missing key bylex", encodeRequest(t, "zrange", "none", "-", "+", "bylex"), array()},
+		{"wrong type", encodeRequest(t, "zrange", "Name", "0", "-1"), "-WRONGTYPE key 'Name' does not support this operation\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestZRangeOfAReplacedKey(t *testing.T) {
+	app := NewApplication(nil, TestClockTimer{mockNow: time.Now()}, NewTestLogger())
+	app.state.keyspace.PutInSortedSet("myset", []string{"1", "Norem"}, zaddAlways, false)
+
+	_, stream, err := processZRange([]string{"myset", "0", "-1"}, nil, app)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the reply is streamed after the key was checked, by which time it may
+	// hold another type
+	app.state.keyspace.SetStringKey("myset", "John", nil)
+
+	var got strings.Builder
+	if err := stream(&got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := SerializeError(wrongTypeError("myset"))
+	if got.String() != want {
+		t.Errorf("got: %#v. want: %#v", got.String(), want)
+	}
+}
+
+func TestCommandScanner(t *testing.T) {
+	frames := []string{
+		"*1\r\n$4\r\nping\r\n",
+		"*3\r\n$3\r\nset\r\n$4\r\nName\r\n$9\r\nJohn\r\nDoe\r\n",
+		"$4\r\nping\r\n",
+		"PING\r\n",
+		"\r\n",
+		"*0\r\n",
+		"*x\r\n",
+		"*1\r\n4\r\nping\r\n",
+		"*1\r\n$x\r\nping\r\n",
+		"*1\r\n$3\r\nping\r\n",
+		"*2\r\n$4\r\necho\r\n$10000\r\n" + strings.Repeat("a", 10000) + "\r\n",
+		"set Name John\n",
+	}
+	stream := strings.Join(frames, "") + "*2\r\n$4\r\necho"
+
+	// where splitCommands ends the commands of the whole stream
+	want := make([]int, 0)
+	for end := 0; ; {
+		advance, _, _ := splitCommands([]byte(stream[end:]), false)
+		if advance == 0 {
+			break
+		}
+		end += advance
+		want = append(want, end)
+	}
+
+	for _, size := range []int{1, 2, 3, 7, 4096, len(stream)} {
+		t.Run(fmt.Sprintf("reads of %d bytes", size), func(t *testing.T) {
+			scanner := newCommandScanner()
+			var pending []byte
+			consumed := 0
+			got := make([]int, 0)
+			for i := 0; i < len(stream); i += size {
+				data := append(pending, stream[i:min(i+size, len(stream))]...)
+				complete := scanner.scan(data)
+				scanner.consume(complete)
+				pending = data[complete:]
+
+				if complete > 0 {
+					consumed += complete
+					got = append(got, consumed)
+				}
+			}
+
+			// reads can end several commands at once, so only the reads of a
+			// single byte end them one by one
+			for _, end := range got {
+				if !slices.Contains(want, end) {
+					t.Errorf("got a command ending at %d. want them ending at: %v", end, want)
+				}
+			}
+			if size == 1 && !slices.Equal(got, want) {
+				t.Errorf("got commands ending at: %v. want: %v", got, want)
+			}
+			if string(pending) != "*2\r\n$4\r\necho" {
+				t.Errorf("got pending: %q. want the incomplete command", pending)
+			}
+		})
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{},
+			sm: map[string]string{},
+			lm: map[string]list{},
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	readAll := func(want string) string {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+		return string(got)
+	}
+
+	t.Run("replies are in order", func(t *testing.T) {
+		var data, want strings.Builder
+		for i := 1; i <= 2000; i++ {
+			data.WriteString(encodeRequest(t, "incr", "Counter"))
+			want.WriteString(SerializeInteger(i))
+			data.WriteString(encodeRequest(t, "get", "Counter"))
+			want.WriteString(SerializeBulkString(strconv.Itoa(i)))
+		}
+
+		if _, err := conn.Write([]byte(data.String())); err != nil {
+			t.Fatalf("could not write payload to server: %v", err)
+		}
+		if got := readAll(want.String()); got != want.String() {
+			t.Errorf("got: %q. want: %q", got, want.String())
+		}
+	})
+
+	t.Run("command split across writes", func(t *testing.T) {
+		data := encodeRequest(t, "set", "Name", "John") + encodeRequest(t, "get", "Name")
+		for _, part := range []string{data[:10], data[10:30], data[30:]} {
+			if _, err := conn.Write([]byte(part)); err != nil {
+				t.Fatalf("could not write payload to server: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		want := OK_SIMPLE_STRING + "$4\r\nJohn\r\n"
+		if got := readAll(want); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	})
+
+	t.Run("bulk strings with newlines", func(t *testing.T) {
+		data := encodeRequest(t, "eval", "SET KEYS[1] Jane\nGET KEYS[1]", "1", "Name") + encodeRequest(t, "ping")
+		want := "$4\r\nJane\r\n+PONG\r\n"
+		if _, err := conn.Write([]byte(data)); err != nil {
+			t.Fatalf("could not write payload to server: %v", err)
+		}
+		if got := readAll(want); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	})
+
+	t.Run("replies before subscribe", func(t *testing.T) {
+		data := encodeRequest(t, "ping") + encodeRequest(t, "subscribe", "news")
+		want := "+PONG\r\n*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+		if _, err := conn.Write([]byte(data)); err != nil {
+			t.Fatalf("could not write payload to server: %v", err)
+		}
+		if got := readAll(want); got != want {
+			t.Errorf("got: %q. want: %q", got, want)
+		}
+	})
+}
+
+// countingListener counts the writes to the connections it accepts.
+type countingListener struct {
+	net.Listener
+	writes *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, writes: l.writes}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	timer := TestClockTimer{mockNow: time.Now()}
+	app := NewApplication(nil, timer, NewTestLogger())
+
+	srv, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		b.Fatalf("failed to setup listener: %v", err)
+	}
+	defer srv.Close()
+
+	writes := &atomic.Int64{}
+	go func() { Listen(countingListener{Listener: srv, writes: writes}, app, app.logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		b.Fatalf("could not establish connection: %v", err)
+	}
+	defer conn.Close()
+
+	const commands = 10000
+	var data bytes.Buffer
+	for i := 0; i < commands; i++ {
+		WriteBulkStringArray(&data, []string{"set", "Key" + strconv.Itoa(i), "value"})
+	}
+	replies := make([]byte, commands*len(OK_SIMPLE_STRING))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(data.Bytes()); err != nil {
+			b.Fatalf("could not write payload to server: %v", err)
+		}
+		if _, err := io.ReadFull(conn, replies); err != nil {
+			b.Fatalf("failed to read from connection: %s", err)
+		}
+	}
+
+	b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+	b.ReportMetric(float64(commands*b.N)/b.Elapsed().Seconds(), "commands/s")
+}
+
+func TestMigrateCommand(t *testing.T) {
+	now := time.Now()
+	source, sourceSrv, sourceLogger := setupApplication(testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"Name":  {group: "string", expires: getFuture(now, 10)},
+				"Names": {group: "list", expires: nil},
+				"Taken": {group: "string", expires: nil},
+			},
+			sm: map[string]string{"Name": "John", "Taken": "here"},
+			lm: map[string]list{"Names": NewListFromSlice([]string{"John", "Jane"})},
+		},
+	}, t)
+	go func() { Listen(sourceSrv, source, sourceLogger) }()
+
+	target, targetSrv, targetLogger := setupApplication(testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Taken": {group: "string", expires: nil}},
+			sm: map[string]string{"Taken": "there"},
+			lm: map[string]list{},
+		},
+	}, t)
+	go func() { Listen(targetSrv, target, targetLogger) }()
+
+	conn, err := net.Dial("tcp", sourceSrv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	host, port, err := net.SplitHostPort(targetSrv.Addr().String())
+	if err != nil {
+		t.Fatalf("invalid target address: %v", err)
+	}
+
+	closed, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatalf("failed to setup listener: %v", err)
+	}
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"string with expiry", encodeRequest(t, "migrate", host, port, "Name", "0", "1000"), OK_SIMPLE_STRING},
+		{"string is deleted", encodeRequest(t, "exists", "Name"), ":0\r\n"},
+		{"list", encodeRequest(t, "migrate", host, port, "Names", "0", "0"), OK_SIMPLE_STRING},
+		{"missing key", encodeRequest(t, "migrate", host, port, "Missing", "0", "1000"), "+NOKEY\r\n"},
+		{"key on target", encodeRequest(t, "migrate", host, port, "Taken", "0", "1000"), "-BUSYKEY Target key name already exists.\r\n"},
+		{"key is kept", encodeRequest(t, "get", "Taken"), "$4\r\nhere\r\n"},
+		{"other database", encodeRequest(t, "migrate", host, port, "Taken", "1", "1000"), "-ERR DB index is out of range\r\n"},
+		{"invalid timeout", encodeRequest(t, "migrate", host, port, "Taken", "0", "-1"), "-ERR value is not an integer or out of range\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+
+	got := writeAndRead(t, conn, encodeRequest(t, "migrate", host, closedPort, "Taken", "0", "1000"))
+	if !strings.HasPrefix(got, "-IOERR error or timeout connecting to") {
+		t.Errorf("unreachable target - got: %#v. want an IOERR error", got)
+	}
+
+	ks := &target.state.keyspace
+	if got := ks.stringMap["Name"].String(); got != "John" {
+		t.Errorf("got target value: %q. want: %q", got, "John")
+	}
+	if e := ks.keys["Name"]; e.expires == nil || !e.expires.Equal(getFuture(now, 10).Truncate(time.Millisecond)) {
+		t.Errorf("got target expiry: %v. want: %v", e.expires, getFuture(now, 10))
+	}
+	names := ks.listMap["Names"]
+	if got := names.ToSlice(); !slices.Equal(got, []string{"John", "Jane"}) {
+		t.Errorf("got target list: %v. want: [John Jane]", got)
+	}
+	if got := ks.stringMap["Taken"].String(); got != "there" {
+		t.Errorf("got target value: %q. want: %q", got, "there")
+	}
+}
+
+func TestMigrateKeyChangedDuringTransfer(t *testing.T) {
+	now := time.Now()
+	source, sourceSrv, sourceLogger := setupApplication(testCase{
+		now: now,
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{"Name": {group: "string", expires: nil}},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+		},
+	}, t)
+	go func() { Listen(sourceSrv, source, sourceLogger) }()
+
+	// the target changes the key on the source before it acknowledges the
+	// commands that recreate it
+	target, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatalf("failed to setup listener: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+		conn.Write([]byte(":0\r\n"))
+
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+		source.state.keyspace.SetStringKey("Name", "Jane", nil)
+		conn.Write([]byte(OK_SIMPLE_STRING))
+	}()
+
+	host, port, err := net.SplitHostPort(target.Addr().String())
+	if err != nil {
+		t.Fatalf("invalid target address: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", sourceSrv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	got := writeAndRead(t, conn, encodeRequest(t, "migrate", host, port, "Name", "0", "1000"))
+	want := "-ERR Key changed while it was migrated and was kept. The target holds its previous value\r\n"
+	if got != want {
+		t.Errorf("got: %#v. want: %#v", got, want)
+	}
+
+	if got := writeAndRead(t, conn, encodeRequest(t, "get", "Name")); got != "$4\r\nJane\r\n" {
+		t.Errorf("expected the changed key to be kept. got: %#v", got)
+	}
+}
+
+func TestDebugExpireCommand(t *testing.T) {
+	now := time.Now()
+	state := mapState{
+		ks: map[string]keyspaceEntry{
+			"Name":    {group: "string", expires: nil},
+			"Surname": {group: "string", expires: nil},
+		},
+		sm: map[string]string{"Name": "John", "Surname": "Doe"},
+		lm: map[string]list{},
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		app, srv, logger := setupApplication(testCase{now: now, initialState: state}, t)
+		app.config.Store(&ApplicationConfiguration{})
+		go func() { Listen(srv, app, logger) }()
+
+		conn := makeRequestToServer(encodeRequest(t, "debug", "expire", "Name", "0"), srv, t)
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read from connection: %s", err)
+		}
+		if got, want := string(buf[:n]), "-ERR DEBUG EXPIRE is disabled\r\n"; got != want {
+			t.Errorf("got: %#v. want: %#v", got, want)
+		}
+	})
+
+	app, srv, logger := setupApplication(testCase{now: now, initialState: state}, t)
+	app.config.Store(&ApplicationConfiguration{DebugExpire: true})
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	past := strconv.FormatInt(now.UnixMilli()-1, 10)
+	future := strconv.FormatInt(now.UnixMilli()+60_000, 10)
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"past deadline", encodeRequest(t, "debug", "expire", "Name", past), OK_SIMPLE_STRING},
+		{"future deadline", encodeRequest(t, "debug", "expire", "Surname", future), OK_SIMPLE_STRING},
+		{"missing key", encodeRequest(t, "debug", "expire", "Missing", future), "-ERR no such key\r\n"},
+		{"invalid deadline", encodeRequest(t, "debug", "expire", "Surname", "soon"), "-ERR value is not an integer or out of range\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+
+	ks := &app.state.keyspace
+	if _, ok := ks.keys["Name"]; !ok {
+		t.Fatalf("expected the key to be kept until it is accessed")
+	}
+	if e := ks.keys["Surname"]; e.expires == nil || e.expires.UnixMilli() != now.UnixMilli()+60_000 {
+		t.Errorf("got expiry: %v. want: %d", e.expires, now.UnixMilli()+60_000)
+	}
+
+	if got := writeAndRead(t, conn, encodeRequest(t, "get", "Name")); got != NIL_BULK_STRING {
+		t.Errorf("got: %#v. want: %#v", got, NIL_BULK_STRING)
+	}
+	if _, ok := ks.keys["Name"]; ok {
+		t.Errorf("expected the key to be deleted by GET")
+	}
+}
+
+func TestZAddConditions(t *testing.T) {
+	tC := testCase{
+		now: time.Now(),
+		initialState: mapState{
+			ks: map[string]keyspaceEntry{
+				"Scores": {group: "sorted-set", expires: nil},
+				"Name":   {group: "string", expires: nil},
+			},
+			sm: map[string]string{"Name": "John"},
+			lm: map[string]list{},
+			tm: func() map[string]rbtState {
+				tree := NewTree[float64, string]()
+				tree.Put(1, "John")
+				return map[string]rbtState{"Scores": {tree: *tree}}
+			}(),
+		},
+	}
+
+	app, srv, logger := setupApplication(tC, t)
+	go func() { Listen(srv, app, logger) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{"nx incr on existing member", encodeRequest(t, "zadd", "Scores", "nx", "incr", "5", "John"), NIL_BULK_STRING},
+		{"nx incr on new member", encodeRequest(t, "zadd", "Scores", "NX", "INCR", "5", "Jane"), "$1\r\n5\r\n"},
+		{"xx incr on existing member", encodeRequest(t, "zadd", "Scores", "xx", "incr", "2.5", "John"), "$3\r\n3.5\r\n"},
+		{"xx incr on new member", encodeRequest(t, "zadd", "Scores", "xx", "incr", "1", "Mary"), NIL_BULK_STRING},
+		{"incr", encodeRequest(t, "zadd", "Scores", "incr", "-1", "Jane"), "$1\r\n4\r\n"},
+		{"scores", encodeRequest(t, "zrange", "Scores", "0", "-1", "withscores"), "*4\r\n$4\r\nJohn\r\n$3\r\n3.5\r\n$4\r\nJane\r\n$1\r\n4\r\n"},
+		{"nx skips existing members", encodeRequest(t, "zadd", "Scores", "nx", "ch", "10", "John", "6", "Mary"), ":1\r\n"},
+		{"xx skips new members", encodeRequest(t, "zadd", "Scores", "xx", "ch", "10", "John", "7", "Anne"), ":1\r\n"},
+		{"conditions apply", encodeRequest(t, "zrange", "Scores", "0", "-1", "withscores"), "*6\r\n$4\r\nJane\r\n$1\r\n4\r\n$4\r\nMary\r\n$1\r\n6\r\n$4\r\nJohn\r\n$2\r\n10\r\n"},
+		{"xx on missing key", encodeRequest(t, "zadd", "Other", "xx", "1", "John"), ":0\r\n"},
+		{"missing key is not created", encodeRequest(t, "exists", "Other"), ":0\r\n"},
+		{"xx incr on missing key", encodeRequest(t, "zadd", "Other", "xx", "incr", "1", "John"), NIL_BULK_STRING},
+		{"nx and xx", encodeRequest(t, "zadd", "Scores", "nx", "xx", "1", "John"), "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{"incr with many pairs", encodeRequest(t, "zadd", "Scores", "incr", "1", "John", "2", "Jane"), "-ERR INCR option supports a single increment-element pair\r\n"},
+		{"incr to nan", encodeRequest(t, "zadd", "Inf", "incr", "inf", "John"), "$3\r\ninf\r\n"},
+		{"incr nan", encodeRequest(t, "zadd", "Inf", "incr", "-inf", "John"), "-ERR value is not a valid float\r\n"},
+		{"nan score", encodeRequest(t, "zadd", "Scores", "nan", "Anne"), "-ERR value is not a valid float\r\n"},
+		{"nan increment", encodeRequest(t, "zadd", "Scores", "incr", "NaN", "John"), "-ERR value is not a valid float\r\n"},
+		{"nan among valid scores", encodeRequest(t, "zadd", "Scores", "1", "Anne", "nan", "Bob"), "-ERR value is not a valid float\r\n"},
+		{"nan is not stored", encodeRequest(t, "zscore", "Scores", "Anne"), NIL_BULK_STRING},
+		{"wrong type", encodeRequest(t, "zadd", "Name", "incr", "1", "John"), "-WRONGTYPE key 'Name' does not support this operation\r\n"},
+	}
+	for _, step := range steps {
+		got := writeAndRead(t, conn, step.data)
+		if got != step.want {
+			t.Errorf("%s - got: %#v. want: %#v", step.desc, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package redis
+
+// sortedSet is the value of a sorted set key. The tree keeps the members
+// ordered by score, and scores holds the score of each member, so finding a
+// member doesn't walk the tree.
+type sortedSet struct {
+	tree   rbtree[float64, string]
+	scores map[string]float64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{tree: *NewTree[float64, string](), scores: make(map[string]float64)}
+}
+
+// Len returns how many members the set has.
+func (s *sortedSet) Len() int64 {
+	return s.tree.Size()
+}
+
+// Score returns the score of member, and whether the set has it.
+func (s *sortedSet) Score(member string) (float64, bool) {
+	score, ok := s.scores[member]
+	return score, ok
+}
+
+// Put sets the score of member, adding it when the set doesn't have it yet.
+func (s *sortedSet) Put(member string, score float64) {
+	if old, ok := s.scores[member]; ok {
+		if old == score {
+			return
+		}
+		// the member moves from the node of its old score to the new one
+		s.tree.RemoveValue(old, member)
+	}
+
+	s.scores[member] = score
+	s.tree.Put(score, member)
+}
+
+// Walk visits the members ordered by score and then lexicographically, or in
+// the opposite order when reverse is set, until visit returns false.
+func (s *sortedSet) Walk(reverse bool, visit func(scoredMember) bool) {
+	s.tree.Walk(reverse, func(score float64, members []string) bool {
+		for i := range members {
+			if reverse {
+				i = len(members) - 1 - i
+			}
+			if !visit(scoredMember{member: members[i], score: score}) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,77 @@
+package redis
+
+import "strconv"
+
+// maxIntStringLength is the length of the longest int64 in decimal,
+// math.MinInt64.
+const maxIntStringLength = 20
+
+// stringValue is the value of a string key. Like the int encoding of redis,
+// values that are integers are kept as an int64 instead of their text, so
+// INCR and DECR don't parse and format them every time.
+type stringValue struct {
+	raw   []byte
+	num   int64
+	isInt bool
+}
+
+// newStringValue stores b as an int64 when it is the canonical text of one,
+// so formatting it back gives b again. Other values, like "007" or "+1", are
+// kept as they are.
+func newStringValue(b []byte) stringValue {
+	if len(b) == 0 || len(b) > maxIntStringLength {
+		return stringValue{raw: b}
+	}
+
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(b) {
+		return stringValue{raw: b}
+	}
+
+	return intStringValue(n)
+}
+
+func intStringValue(n int64) stringValue {
+	return stringValue{num: n, isInt: true}
+}
+
+// Bytes returns the text of the value. Integers are formatted on every call.
+func (v stringValue) Bytes() []byte {
+	if v.isInt {
+		return strconv.AppendInt(nil, v.num, 10)
+	}
+	return v.raw
+}
+
+func (v stringValue) String() string {
+	if v.isInt {
+		return strconv.FormatInt(v.num, 10)
+	}
+	return string(v.raw)
+}
+
+// Len returns the length of the text of the value.
+func (v stringValue) Len() int {
+	if v.isInt {
+		return len(v.String())
+	}
+	return len(v.raw)
+}
+
+// Int returns the value as an integer, parsing it unless it is kept as one.
+func (v stringValue) Int() (int64, bool) {
+	if v.isInt {
+		return v.num, true
+	}
+
+	n, err := strconv.ParseInt(string(v.raw), 10, 64)
+	return n, err == nil
+}
+
+// Equal reports whether both values have the same text.
+func (v stringValue) Equal(o stringValue) bool {
+	if v.isInt && o.isInt {
+		return v.num == o.num
+	}
+	return v.String() == o.String()
+}
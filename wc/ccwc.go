@@ -1,67 +1,147 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-const MAX_FLAGS_NUMBER = 4
+const MAX_FLAGS_NUMBER = 5
+
+// DEFAULT_FLAGS_NUMBER is how many counters are printed when no counter flag
+// is given: bytes, lines and words.
+const DEFAULT_FLAGS_NUMBER = 3
+
+// PROGRESS_INTERVAL is how many bytes are read between the progress reports
+// of --progress.
+const PROGRESS_INTERVAL int64 = 64 * 1024 * 1024
 
 type WcConfigs struct {
-	in               *os.File
-	shouldCountBytes bool
-	shouldCountLines bool
-	shouldCountWords bool
-	shouldCountChars bool
-	numberOfFlagsSet int
+	in                 *os.File
+	shouldCountBytes   bool
+	shouldCountLines   bool
+	shouldCountWords   bool
+	shouldCountChars   bool
+	shouldCountMaxLine bool
+	recursive          bool
+	json               bool
+	progress           bool
+	noFilename         bool
+	files0From         string
+	total              string
+	delim              string
+	lines              string
+	numberOfFlagsSet   int
 }
 
-func (c *WcConfigs) parseFlagsAndFileName(programName string, args []string) (string, error) {
+// parseFlagsAndFileName parses the flags in args and returns the names of the
+// files to count. Flags end at the first file name or at "--", so files with
+// names starting with '-' are given after it, like in GNU wc.
+func (c *WcConfigs) parseFlagsAndFileName(programName string, args []string) ([]string, error) {
 	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
 	flags.BoolVar(&c.shouldCountBytes, "c", false, "print the bytes count")
 	flags.BoolVar(&c.shouldCountLines, "l", false, "print the line count")
 	flags.BoolVar(&c.shouldCountWords, "w", false, "print the word count")
 	flags.BoolVar(&c.shouldCountChars, "m", false, "print the char count")
+	flags.BoolVar(&c.shouldCountMaxLine, "L", false, "print the maximum line length")
+	flags.BoolVar(&c.recursive, "r", false, "count the files inside directories recursively")
+	flags.BoolVar(&c.json, "json", false, "print the counts as json")
+	flags.BoolVar(&c.noFilename, "no-filename", false, "print only the counts, without the file names or the total label")
+	flags.BoolVar(&c.noFilename, "q", false, "shorthand for --no-filename")
+	flags.BoolVar(&c.progress, "progress", false, "report how many bytes were read to stderr while counting large inputs")
+	flags.StringVar(&c.files0From, "files0-from", "", "read the NUL-terminated file names from `file`, or stdin when '-'")
+	flags.StringVar(&c.delim, "delim", "", "count words separated by any of the `chars` instead of white space")
+	flags.Func("lines", "what the line count counts: newline characters, like GNU wc, or logical lines, which includes a last line without a newline (default newline)", func(s string) error {
+		switch s {
+		default:
+			return fmt.Errorf("invalid argument '%s' for '--lines'", s)
+		case "newline", "logical":
+			c.lines = s
+		}
+
+		return nil
+	})
+	flags.Func("total", "when to print the totals line: auto, always, only or never", func(s string) error {
+		switch s {
+		default:
+			return fmt.Errorf("invalid argument '%s' for '--total'", s)
+		case "auto", "always", "only", "never":
+			c.total = s
+		}
+
+		return nil
+	})
 
 	err := flags.Parse(args)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	c.numberOfFlagsSet = 0
 	flags.Visit(func(f *flag.Flag) {
 		switch f.Name {
-		case "c", "l", "w", "m":
+		case "c", "l", "w", "m", "L":
 			c.numberOfFlagsSet += 1
 		}
 	})
 
 	c.flipAllFlagsIfNoneSet()
-	filename := flags.Arg(0)
-	return filename, err
-}
-
-func (c *WcConfigs) checkIfFlagIsIsolated(flag string) bool {
-	isIsolated := false
-
-	switch flag {
-	case "c":
-		isIsolated = c.shouldCountBytes && c.numberOfFlagsSet == 1
-	case "l":
-		isIsolated = c.shouldCountLines && c.numberOfFlagsSet == 1
-	case "w":
-		isIsolated = c.shouldCountWords && c.numberOfFlagsSet == 1
-	case "m":
-		isIsolated = c.shouldCountChars && c.numberOfFlagsSet == 1
-	default:
-		isIsolated = false
+
+	if c.files0From == "" {
+		return flags.Args(), nil
+	}
+
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("extra operand '%s'. file operands cannot be combined with --files0-from", flags.Arg(0))
 	}
 
-	return isIsolated
+	return readFiles0(c.files0From)
+}
+
+// readFiles0 reads the NUL-terminated file names listed in the named file, or
+// in stdin when name is "-".
+func readFiles0(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := openFile(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitFiles0(data), nil
+}
+
+// splitFiles0 splits a list of NUL-terminated file names. The terminator of
+// the last name is optional. Zero-length names are kept, so they can be
+// reported when counted.
+func splitFiles0(data []byte) []string {
+	names := make([]string, 0)
+	if len(data) == 0 {
+		return names
+	}
+
+	data = bytes.TrimSuffix(data, []byte{0})
+	for _, name := range bytes.Split(data, []byte{0}) {
+		names = append(names, string(name))
+	}
+	return names
 }
 
 func (c *WcConfigs) flipAllFlagsIfNoneSet() {
@@ -71,24 +151,59 @@ func (c *WcConfigs) flipAllFlagsIfNoneSet() {
 		c.shouldCountLines = true
 		c.shouldCountWords = true
 		c.shouldCountChars = false
-		c.numberOfFlagsSet = MAX_FLAGS_NUMBER - 1
+		c.shouldCountMaxLine = false
+		c.numberOfFlagsSet = DEFAULT_FLAGS_NUMBER
 	}
 }
 
 type WcResult struct {
-	name      string
-	byteCount int64
-	lineCount int
-	wordCount int
-	charCount int
+	name          string
+	byteCount     int64
+	lineCount     int
+	wordCount     int
+	charCount     int
+	maxLineLength int
 }
 
 var defaultWcResult = WcResult{
-	name:      "",
-	byteCount: 0,
-	lineCount: 0,
-	wordCount: 0,
-	charCount: 0,
+	name:          "",
+	byteCount:     0,
+	lineCount:     0,
+	wordCount:     0,
+	charCount:     0,
+	maxLineLength: 0,
+}
+
+// selected returns the counts chosen in configs, in the order they are
+// reported: bytes, lines, words, chars and the maximum line length. When no
+// counter is chosen, the bytes, lines and words are returned.
+func (r WcResult) selected(configs WcConfigs) []int {
+	if configs.numberOfFlagsSet == 0 {
+		return []int{int(r.byteCount), r.lineCount, r.wordCount}
+	}
+
+	counts := make([]int, 0, MAX_FLAGS_NUMBER)
+	if configs.shouldCountBytes {
+		counts = append(counts, int(r.byteCount))
+	}
+
+	if configs.shouldCountLines {
+		counts = append(counts, r.lineCount)
+	}
+
+	if configs.shouldCountWords {
+		counts = append(counts, r.wordCount)
+	}
+
+	if configs.shouldCountChars {
+		counts = append(counts, r.charCount)
+	}
+
+	if configs.shouldCountMaxLine {
+		counts = append(counts, r.maxLineLength)
+	}
+
+	return counts
 }
 
 func openFile(filename string) (*os.File, error) {
@@ -100,104 +215,340 @@ func openFile(filename string) (*os.File, error) {
 	return file, nil
 }
 
-func getNumberOfLines(buf *bytes.Buffer) int {
-	reader := bytes.NewReader(buf.Bytes())
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanLines)
+// walkPaths expands the directories in args into the files inside them when
+// recursive is set. Otherwise directories are kept as they are, so counting
+// them reports an "is a directory" error like GNU wc. Paths that can't be
+// walked are kept too, so their error is reported when they are counted.
+func walkPaths(args []string, recursive bool) []string {
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() || !recursive {
+			paths = append(paths, arg)
+			continue
+		}
 
-	var lines int
-	for scanner.Scan() {
-		lines++
+		filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				paths = append(paths, path)
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			if !d.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
 	}
 
-	return lines
+	return paths
 }
 
-func getNumberOfWords(buf *bytes.Buffer) int {
-	reader := bytes.NewReader(buf.Bytes())
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanWords)
+// wordSeparatorFunc returns the function telling the runes that separate
+// words: white space unless --delim was given.
+func (c *WcConfigs) wordSeparatorFunc() func(rune) bool {
+	if c.delim == "" {
+		return unicode.IsSpace
+	}
+
+	return delimiterFunc(c.delim)
+}
 
-	var words int
-	for scanner.Scan() {
-		words++
+// delimiterFunc returns a function telling whether a rune is one of delims.
+// Line breaks always separate words, so a word never spans two lines.
+func delimiterFunc(delims string) func(rune) bool {
+	return func(r rune) bool {
+		return r == '\n' || r == '\r' || strings.ContainsRune(delims, r)
 	}
-	return words
 }
 
-func getNumberOfChars(buf *bytes.Buffer) int {
-	reader := bytes.NewReader(buf.Bytes())
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanRunes)
+// counter counts the bytes, lines, words and chars written to it, and
+// measures its longest line, looking at each byte once. The data can be
+// written in chunks of any size: a rune cut by the end of a chunk is carried
+// over to the next one.
+type counter struct {
+	isSeparator func(rune) bool
+	logical     bool
+	result      WcResult
+
+	carry      []byte
+	inWord     bool
+	lineLength int
+	lastWasCR  bool
+
+	// lineHasData is set when there are bytes after the last '\n', which
+	// make a last logical line
+	lineHasData bool
+}
+
+func newCounter(opts WcConfigs) *counter {
+	return &counter{isSeparator: opts.wordSeparatorFunc(), logical: opts.lines == "logical"}
+}
 
-	var chars int
-	for scanner.Scan() {
-		chars++
+func (c *counter) Write(p []byte) (int, error) {
+	c.result.byteCount += int64(len(p))
+
+	data := p
+	if len(c.carry) > 0 {
+		data = append(c.carry, p...)
+		c.carry = nil
+	}
+
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			c.countRune(rune(data[i]))
+			i++
+			continue
+		}
+
+		if !utf8.FullRune(data[i:]) {
+			c.carry = bytes.Clone(data[i:])
+			break
+		}
+
+		r, width := utf8.DecodeRune(data[i:])
+		c.countRune(r)
+		i += width
+	}
+
+	return len(p), nil
+}
+
+// countRune counts r. Invalid UTF-8 is counted one byte at a time, each as a
+// char that is part of a word, like utf8.RuneCount and bufio.ScanWords do.
+func (c *counter) countRune(r rune) {
+	c.result.charCount++
+
+	if c.isSeparator(r) {
+		c.inWord = false
+	} else if !c.inWord {
+		c.inWord = true
+		c.result.wordCount++
 	}
-	return chars
+
+	if r != '\n' {
+		c.lineLength++
+		c.lastWasCR = r == '\r'
+		c.lineHasData = true
+		return
+	}
+
+	c.result.lineCount++
+	c.endLine()
+	c.lineHasData = false
 }
 
-func DoWc(file *os.File) (WcResult, error) {
+// endLine measures the current line, without its line break, and starts
+// the next one.
+func (c *counter) endLine() {
+	length := c.lineLength
+	if c.lastWasCR {
+		length--
+	}
+
+	c.result.maxLineLength = max(c.result.maxLineLength, length)
+	c.lineLength = 0
+	c.lastWasCR = false
+}
+
+// finish counts what is left at the end of the data: the bytes of an
+// incomplete rune and the last line, when it has no newline.
+func (c *counter) finish() WcResult {
+	for len(c.carry) > 0 {
+		r, width := utf8.DecodeRune(c.carry)
+		c.countRune(r)
+		c.carry = c.carry[width:]
+	}
+
+	c.endLine()
+	if c.logical && c.lineHasData {
+		c.result.lineCount++
+	}
+
+	return c.result
+}
+
+// DoWc counts the contents of file with DoWcReader and names the result
+// after it. Character devices, like a terminal, are read through stdin.
+func DoWc(file *os.File, opts WcConfigs) (WcResult, error) {
 	info, err := file.Stat()
 	if err != nil {
 		return defaultWcResult, err
 	}
 
-	var reader io.Reader
-	if (info.Mode() & os.ModeCharDevice) == 0 {
-		reader = bufio.NewReader(file)
-	} else {
-		reader = bufio.NewReader(os.Stdin)
+	var reader io.Reader = file
+	if (info.Mode() & os.ModeCharDevice) != 0 {
+		reader = os.Stdin
+	}
+
+	if opts.progress {
+		reader = newProgressReader(reader, PROGRESS_INTERVAL, func(read int64) {
+			fmt.Fprintf(os.Stderr, "%s: %d bytes read\n", file.Name(), read)
+		})
 	}
 
-	buf := &bytes.Buffer{}
-	fileSize, err := buf.ReadFrom(reader)
+	result, err := DoWcReader(reader, opts)
 	if err != nil {
 		return defaultWcResult, err
 	}
 
-	lines := getNumberOfLines(buf)
-	words := getNumberOfWords(buf)
-	chars := getNumberOfChars(buf)
-	return WcResult{name: file.Name(), byteCount: fileSize, lineCount: lines, wordCount: words, charCount: chars}, nil
+	result.name = file.Name()
+	return result, nil
 }
 
-func getResultsReport(configs WcConfigs, results WcResult) string {
-	report := results.name
+// DoWcReader counts the bytes, lines, words and chars read from r until EOF,
+// and measures its longest line, in a single pass that holds only a chunk of
+// the data at a time.
+// Of opts, only the options that change how things are counted are used, like
+// the word delimiters and the line definition. The result has no name.
+func DoWcReader(r io.Reader, opts WcConfigs) (WcResult, error) {
+	c := newCounter(opts)
+	if _, err := io.Copy(c, r); err != nil {
+		return defaultWcResult, err
+	}
 
-	if configs.numberOfFlagsSet == 0 {
-		report = fmt.Sprintf("%d %d %d %s", results.byteCount, results.lineCount, results.wordCount, report)
-	} else if configs.numberOfFlagsSet == MAX_FLAGS_NUMBER {
-		report = fmt.Sprintf("%d %d %d %d %s", results.byteCount, results.lineCount, results.wordCount, results.charCount, report)
-	} else if configs.numberOfFlagsSet == 1 {
-		if configs.checkIfFlagIsIsolated("c") {
-			report = fmt.Sprintf("%d %s", results.byteCount, report)
-		} else if configs.checkIfFlagIsIsolated("l") {
-			report = fmt.Sprintf("%d %s", results.lineCount, report)
-		} else if configs.checkIfFlagIsIsolated("w") {
-			report = fmt.Sprintf("%d %s", results.wordCount, report)
-		} else if configs.checkIfFlagIsIsolated("m") {
-			report = fmt.Sprintf("%d %s", results.charCount, report)
-		}
+	return c.finish(), nil
+}
 
-	} else {
-		if configs.shouldCountChars {
-			report = fmt.Sprintf("%d %s", results.charCount, report)
-		}
+// progressReader tallies the bytes read through it and calls report with the
+// total every time another interval of bytes was read.
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	next     int64
+	interval int64
+	report   func(read int64)
+}
 
-		if configs.shouldCountWords {
-			report = fmt.Sprintf("%d %s", results.wordCount, report)
+func newProgressReader(r io.Reader, interval int64, report func(read int64)) *progressReader {
+	return &progressReader{r: r, next: interval, interval: interval, report: report}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	// a single read may cross several intervals, which are reported once
+	if p.read >= p.next {
+		p.report(p.read)
+		for p.next <= p.read {
+			p.next += p.interval
 		}
+	}
+
+	return n, err
+}
+
+// sumResults adds up the counts of results. Like GNU wc, the maximum line
+// length of the total is the longest of all results.
+func sumResults(results []WcResult) WcResult {
+	total := WcResult{name: "total"}
+	for _, r := range results {
+		total.byteCount += r.byteCount
+		total.lineCount += r.lineCount
+		total.wordCount += r.wordCount
+		total.charCount += r.charCount
+		total.maxLineLength = max(total.maxLineLength, r.maxLineLength)
+	}
+
+	return total
+}
+
+// getReportResults returns the results to print, in order, with the totals
+// line added according to the --total option.
+func getReportResults(configs WcConfigs, results []WcResult) []WcResult {
+	switch configs.total {
+	case "always":
+		return append(results, sumResults(results))
+
+	case "only":
+		total := sumResults(results)
+		total.name = ""
+		return []WcResult{total}
 
-		if configs.shouldCountLines {
-			report = fmt.Sprintf("%d %s", results.lineCount, report)
+	case "never":
+		return results
+
+	default:
+		if len(results) > 1 {
+			return append(results, sumResults(results))
 		}
+		return results
+	}
+}
+
+func getResultsReport(configs WcConfigs, results WcResult) string {
+	counts := results.selected(configs)
+
+	fields := make([]string, 0, len(counts)+1)
+	for _, count := range counts {
+		fields = append(fields, strconv.Itoa(count))
+	}
+
+	if results.name != "" && !configs.noFilename {
+		fields = append(fields, results.name)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+type wcJSONResult struct {
+	Name          string `json:"name"`
+	Bytes         *int64 `json:"bytes,omitempty"`
+	Lines         *int   `json:"lines,omitempty"`
+	Words         *int   `json:"words,omitempty"`
+	Chars         *int   `json:"chars,omitempty"`
+	MaxLineLength *int   `json:"max_line_length,omitempty"`
+}
+
+func newWcJSONResult(configs WcConfigs, results WcResult) wcJSONResult {
+	r := wcJSONResult{Name: results.name}
+
+	if configs.shouldCountBytes {
+		r.Bytes = &results.byteCount
+	}
+
+	if configs.shouldCountLines {
+		r.Lines = &results.lineCount
+	}
+
+	if configs.shouldCountWords {
+		r.Words = &results.wordCount
+	}
+
+	if configs.shouldCountChars {
+		r.Chars = &results.charCount
+	}
+
+	if configs.shouldCountMaxLine {
+		r.MaxLineLength = &results.maxLineLength
+	}
+
+	return r
+}
+
+// getResultsReportJSON reports only the selected counts of each result. A
+// single result is reported as an object, multiple results as an array.
+func getResultsReportJSON(configs WcConfigs, results []WcResult) (string, error) {
+	var report []byte
+	var err error
 
-		if configs.shouldCountBytes {
-			report = fmt.Sprintf("%d %s", results.byteCount, report)
+	if len(results) == 1 {
+		report, err = json.Marshal(newWcJSONResult(configs, results[0]))
+	} else {
+		all := make([]wcJSONResult, 0, len(results))
+		for _, r := range results {
+			all = append(all, newWcJSONResult(configs, r))
 		}
+		report, err = json.Marshal(all)
+	}
 
+	if err != nil {
+		return "", err
 	}
 
-	return report
+	return string(report), nil
 }
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestOpenFile(t *testing.T) {
@@ -28,7 +35,7 @@ func TestNumberOfBytesInFile(t *testing.T) {
 	file, _ := openFile(filename)
 	defer file.Close()
 
-	result, err := DoWc(file)
+	result, err := DoWc(file, WcConfigs{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,7 +52,7 @@ func TestNumberOfLinesInFile(t *testing.T) {
 	file, _ := openFile(filename)
 	defer file.Close()
 
-	result, err := DoWc(file)
+	result, err := DoWc(file, WcConfigs{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,7 +69,7 @@ func TestNumberOfWordsInFile(t *testing.T) {
 	file, _ := openFile(filename)
 	defer file.Close()
 
-	result, err := DoWc(file)
+	result, err := DoWc(file, WcConfigs{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -326,3 +333,638 @@ func TestGetResultsReport(t *testing.T) {
 		}
 	})
 }
+
+func TestGetResultsReportMaxLine(t *testing.T) {
+	results := WcResult{name: "test.txt", byteCount: 342190, lineCount: 7145, wordCount: 58164, charCount: 339292, maxLineLength: 80}
+	testCases := []struct {
+		desc    string
+		configs WcConfigs
+		want    string
+	}{
+		{
+			desc:    "max line length in isolation",
+			configs: WcConfigs{numberOfFlagsSet: 1, shouldCountMaxLine: true},
+			want:    "80 test.txt",
+		},
+		{
+			desc:    "max line length after the line count",
+			configs: WcConfigs{numberOfFlagsSet: 2, shouldCountLines: true, shouldCountMaxLine: true},
+			want:    "7145 80 test.txt",
+		},
+		{
+			desc:    "max line length after the char count",
+			configs: WcConfigs{numberOfFlagsSet: 3, shouldCountBytes: true, shouldCountChars: true, shouldCountMaxLine: true},
+			want:    "342190 339292 80 test.txt",
+		},
+		{
+			desc:    "all counters",
+			configs: WcConfigs{numberOfFlagsSet: 5, shouldCountBytes: true, shouldCountLines: true, shouldCountWords: true, shouldCountChars: true, shouldCountMaxLine: true},
+			want:    "342190 7145 58164 339292 80 test.txt",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got := getResultsReport(tC.configs, results)
+			if got != tC.want {
+				t.Errorf("got '%s' want '%s'", got, tC.want)
+			}
+		})
+	}
+
+	t.Run("unnamed result has no trailing space", func(t *testing.T) {
+		configs := WcConfigs{numberOfFlagsSet: 2, shouldCountWords: true, shouldCountMaxLine: true}
+
+		want := "58164 80"
+		if got := getResultsReport(configs, WcResult{wordCount: 58164, maxLineLength: 80}); got != want {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+
+	t.Run("flag order does not change the report order", func(t *testing.T) {
+		configs := WcConfigs{}
+		if _, err := configs.parseFlagsAndFileName("wc", []string{"-L", "-w", "-c", "test.txt"}); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		want := "342190 58164 80 test.txt"
+		if got := getResultsReport(configs, results); got != want {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+}
+
+func TestWalkPaths(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested")
+	if err := os.Mkdir(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{filepath.Join(dir, "a.txt"), filepath.Join(nested, "b.txt"), filepath.Join(dir, "c.txt")}
+	for _, f := range files {
+		if err := os.WriteFile(f, []byte("hello world\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("directories should be expanded if recursive", func(t *testing.T) {
+		want := []string{files[0], files[2], files[1], "test.txt"}
+		got := walkPaths([]string{dir, "test.txt"}, true)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("directories should be kept if not recursive", func(t *testing.T) {
+		want := []string{dir, "test.txt"}
+		got := walkPaths([]string{dir, "test.txt"}, false)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("missing paths should be kept to report their error", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing")
+		want := []string{missing}
+		got := walkPaths([]string{missing}, true)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("counting a directory should return an error", func(t *testing.T) {
+		_, err := countFile(dir, WcConfigs{})
+		if err == nil {
+			t.Error("Expected an error when counting a directory")
+		}
+	})
+
+	t.Run("totals should sum all walked files", func(t *testing.T) {
+		results := make([]WcResult, 0)
+		for _, path := range walkPaths([]string{dir}, true) {
+			r, err := countFile(path, WcConfigs{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, r)
+		}
+
+		want := WcResult{name: "total", byteCount: 36, lineCount: 3, wordCount: 6, charCount: 36, maxLineLength: 11}
+		got := sumResults(results)
+
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
+
+func TestGetResultsReportJSON(t *testing.T) {
+	results := WcResult{name: "test.txt", byteCount: 342190, lineCount: 7145, wordCount: 58164, charCount: 339292}
+	other := WcResult{name: "other.txt", byteCount: 12, lineCount: 1, wordCount: 2, charCount: 12}
+
+	t.Run("single file should be reported as an object", func(t *testing.T) {
+		configs := WcConfigs{shouldCountBytes: true, shouldCountLines: true, shouldCountWords: true, shouldCountChars: false}
+
+		want := `{"name":"test.txt","bytes":342190,"lines":7145,"words":58164}`
+		got, err := getResultsReportJSON(configs, []WcResult{results})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want != got {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+
+	t.Run("only selected counts should be reported", func(t *testing.T) {
+		configs := WcConfigs{shouldCountBytes: false, shouldCountLines: true, shouldCountWords: false, shouldCountChars: true}
+
+		want := `{"name":"test.txt","lines":7145,"chars":339292}`
+		got, err := getResultsReportJSON(configs, []WcResult{results})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want != got {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+
+	t.Run("multiple files should be reported as an array", func(t *testing.T) {
+		configs := WcConfigs{shouldCountBytes: false, shouldCountLines: true, shouldCountWords: false, shouldCountChars: false}
+
+		want := `[{"name":"test.txt","lines":7145},{"name":"other.txt","lines":1}]`
+		got, err := getResultsReportJSON(configs, []WcResult{results, other})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want != got {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+}
+
+func TestSplitFiles0(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+		want []string
+	}{
+		{desc: "empty list", data: "", want: []string{}},
+		{desc: "terminated names", data: "a.txt\x00b.txt\x00", want: []string{"a.txt", "b.txt"}},
+		{desc: "last name not terminated", data: "a.txt\x00b.txt", want: []string{"a.txt", "b.txt"}},
+		{desc: "zero-length names are kept", data: "a.txt\x00\x00b.txt\x00", want: []string{"a.txt", "", "b.txt"}},
+		{desc: "names with spaces and newlines", data: "a file.txt\x00new\nline.txt\x00", want: []string{"a file.txt", "new\nline.txt"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got := splitFiles0([]byte(tC.data))
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got %q want %q", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestFiles0FromFlag(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(list, []byte("test.txt\x00ccwc.go\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("file names should be read from the list", func(t *testing.T) {
+		configs := WcConfigs{}
+		got, err := configs.parseFlagsAndFileName("some-name", []string{"--files0-from=" + list})
+		if err != nil {
+			t.Fatalf("Expected to parse flags without errors. err: %v", err)
+		}
+
+		want := []string{"test.txt", "ccwc.go"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("file operands should not be allowed", func(t *testing.T) {
+		configs := WcConfigs{}
+		_, err := configs.parseFlagsAndFileName("some-name", []string{"--files0-from=" + list, "test.txt"})
+		if err == nil {
+			t.Error("Expected an error when combining file operands with --files0-from")
+		}
+	})
+
+	t.Run("missing list should fail", func(t *testing.T) {
+		configs := WcConfigs{}
+		_, err := configs.parseFlagsAndFileName("some-name", []string{"--files0-from=" + list + ".missing"})
+		if err == nil {
+			t.Error("Expected an error for a missing list file")
+		}
+	})
+}
+
+func TestGetReportResults(t *testing.T) {
+	a := WcResult{name: "a.txt", byteCount: 10, lineCount: 1, wordCount: 2}
+	b := WcResult{name: "b.txt", byteCount: 20, lineCount: 3, wordCount: 4}
+	total := WcResult{name: "total", byteCount: 30, lineCount: 4, wordCount: 6}
+	onlyTotal := WcResult{name: "", byteCount: 30, lineCount: 4, wordCount: 6}
+	onlyA := WcResult{name: "", byteCount: 10, lineCount: 1, wordCount: 2}
+	totalA := WcResult{name: "total", byteCount: 10, lineCount: 1, wordCount: 2}
+
+	testCases := []struct {
+		total   string
+		results []WcResult
+		want    []WcResult
+	}{
+		{total: "", results: []WcResult{a}, want: []WcResult{a}},
+		{total: "auto", results: []WcResult{a}, want: []WcResult{a}},
+		{total: "auto", results: []WcResult{a, b}, want: []WcResult{a, b, total}},
+		{total: "always", results: []WcResult{a}, want: []WcResult{a, totalA}},
+		{total: "always", results: []WcResult{a, b}, want: []WcResult{a, b, total}},
+		{total: "only", results: []WcResult{a}, want: []WcResult{onlyA}},
+		{total: "only", results: []WcResult{a, b}, want: []WcResult{onlyTotal}},
+		{total: "never", results: []WcResult{a}, want: []WcResult{a}},
+		{total: "never", results: []WcResult{a, b}, want: []WcResult{a, b}},
+	}
+	for _, tC := range testCases {
+		t.Run(fmt.Sprintf("%s with %d files", tC.total, len(tC.results)), func(t *testing.T) {
+			configs := WcConfigs{total: tC.total}
+			got := getReportResults(configs, tC.results)
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got %v want %v", got, tC.want)
+			}
+		})
+	}
+
+	t.Run("total only line should not have a name", func(t *testing.T) {
+		configs := WcConfigs{shouldCountLines: true, numberOfFlagsSet: 1}
+		got := getResultsReport(configs, onlyTotal)
+		if got != "4" {
+			t.Errorf("got %q want %q", got, "4")
+		}
+	})
+
+	jsonCases := []struct {
+		total string
+		want  string
+	}{
+		{total: "auto", want: `[{"name":"a.txt","lines":1},{"name":"b.txt","lines":3},{"name":"total","lines":4}]`},
+		{total: "always", want: `[{"name":"a.txt","lines":1},{"name":"b.txt","lines":3},{"name":"total","lines":4}]`},
+		{total: "only", want: `{"name":"","lines":4}`},
+		{total: "never", want: `[{"name":"a.txt","lines":1},{"name":"b.txt","lines":3}]`},
+	}
+	for _, tC := range jsonCases {
+		t.Run(fmt.Sprintf("json %s with 2 files", tC.total), func(t *testing.T) {
+			configs := WcConfigs{total: tC.total, json: true, shouldCountLines: true, numberOfFlagsSet: 1}
+			got, err := getResultsReportJSON(configs, getReportResults(configs, []WcResult{a, b}))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got != tC.want {
+				t.Errorf("got %s want %s", got, tC.want)
+			}
+		})
+	}
+
+	t.Run("invalid option should fail to parse", func(t *testing.T) {
+		configs := WcConfigs{}
+		_, err := configs.parseFlagsAndFileName("some-name", []string{"--total=sometimes"})
+		if err == nil {
+			t.Error("Expected an error for an invalid --total argument")
+		}
+	})
+}
+
+func TestWordDelimiters(t *testing.T) {
+	input := "name,age city\njohn,30 new york\n,,mary,,\n"
+
+	testCases := []struct {
+		desc  string
+		delim string
+		want  int
+	}{
+		{desc: "white space by default", delim: "", want: 6},
+		{desc: "comma", delim: ",", want: 5},
+		{desc: "comma and space", delim: ", ", want: 8},
+		{desc: "delimiter not in input", delim: "é", want: 3},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := DoWcReader(strings.NewReader(input), WcConfigs{delim: tC.delim})
+			if err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if got.wordCount != tC.want {
+				t.Errorf("got: %d. want: %d", got.wordCount, tC.want)
+			}
+		})
+	}
+
+	t.Run("words around multibyte delimiters", func(t *testing.T) {
+		got, err := DoWcReader(strings.NewReader("caféau laitécrème"), WcConfigs{delim: "é"})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if got.wordCount != 3 {
+			t.Errorf("got: %d. want: 3", got.wordCount)
+		}
+	})
+
+	t.Run("delim flag", func(t *testing.T) {
+		configs := WcConfigs{}
+		if _, err := configs.parseFlagsAndFileName("wc", []string{"--delim=,;", "test.txt"}); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if configs.delim != ",;" {
+			t.Errorf("got: %q. want: %q", configs.delim, ",;")
+		}
+	})
+}
+
+func TestDoWcReader(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		opts  WcConfigs
+		want  WcResult
+	}{
+		{
+			desc:  "empty input",
+			input: "",
+			want:  WcResult{},
+		},
+		{
+			desc:  "ascii text",
+			input: "hello world\nfoo bar baz\n",
+			want:  WcResult{byteCount: 24, lineCount: 2, wordCount: 5, charCount: 24, maxLineLength: 11},
+		},
+		{
+			desc:  "multibyte chars",
+			input: "olá mundo\nçava\n",
+			want:  WcResult{byteCount: 17, lineCount: 2, wordCount: 3, charCount: 15, maxLineLength: 9},
+		},
+		{
+			desc:  "crlf line endings",
+			input: "one\r\nthree\r\n",
+			want:  WcResult{byteCount: 12, lineCount: 2, wordCount: 2, charCount: 12, maxLineLength: 5},
+		},
+		{
+			desc:  "invalid utf-8",
+			input: "a\xffb \xe2\x82\n\xe2",
+			want:  WcResult{byteCount: 8, lineCount: 1, wordCount: 3, charCount: 8, maxLineLength: 6},
+		},
+		{
+			desc:  "line longer than the read buffer",
+			input: strings.Repeat("a", 100000) + " b\n",
+			want:  WcResult{byteCount: 100003, lineCount: 1, wordCount: 2, charCount: 100003, maxLineLength: 100002},
+		},
+		{
+			desc:  "custom word delimiters",
+			input: "a,b,c\nd,e\n",
+			opts:  WcConfigs{delim: ","},
+			want:  WcResult{byteCount: 10, lineCount: 2, wordCount: 5, charCount: 10, maxLineLength: 5},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := DoWcReader(strings.NewReader(tC.input), tC.opts)
+			if err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if got != tC.want {
+				t.Errorf("got: %+v. want: %+v", got, tC.want)
+			}
+
+			// runes cut between reads are counted once
+			got, err = DoWcReader(iotest.OneByteReader(strings.NewReader(tC.input)), tC.opts)
+			if err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+
+			if got != tC.want {
+				t.Errorf("one byte reads - got: %+v. want: %+v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestLineDefinitions(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		input       string
+		wantNewline int
+		wantLogical int
+	}{
+		{desc: "trailing newline", input: "one\ntwo\n", wantNewline: 2, wantLogical: 2},
+		{desc: "no trailing newline", input: "one\ntwo", wantNewline: 1, wantLogical: 2},
+		{desc: "single unterminated line", input: "one", wantNewline: 0, wantLogical: 1},
+		{desc: "crlf line endings", input: "one\r\ntwo\r\n", wantNewline: 2, wantLogical: 2},
+		{desc: "empty lines", input: "\n\n", wantNewline: 2, wantLogical: 2},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			for _, lines := range []string{"", "newline", "logical"} {
+				want := tC.wantNewline
+				if lines == "logical" {
+					want = tC.wantLogical
+				}
+
+				got, err := DoWcReader(strings.NewReader(tC.input), WcConfigs{lines: lines})
+				if err != nil {
+					t.Fatalf("expected no error. got: %v", err)
+				}
+
+				if got.lineCount != want {
+					t.Errorf("lines %q - got: %d. want: %d", lines, got.lineCount, want)
+				}
+			}
+		})
+	}
+
+	t.Run("lines flag", func(t *testing.T) {
+		configs := WcConfigs{}
+		if _, err := configs.parseFlagsAndFileName("wc", []string{"--lines=logical", "test.txt"}); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if configs.lines != "logical" {
+			t.Errorf("got: %q. want: %q", configs.lines, "logical")
+		}
+
+		if _, err := (&WcConfigs{}).parseFlagsAndFileName("wc", []string{"--lines=words"}); err == nil {
+			t.Error("expected error for invalid line definition")
+		}
+	})
+}
+
+func TestProgressReader(t *testing.T) {
+	t.Run("reports at every interval", func(t *testing.T) {
+		reports := make([]int64, 0)
+		// one byte per read, so every boundary is reached exactly
+		r := newProgressReader(iotest.OneByteReader(strings.NewReader(strings.Repeat("a", 35))), 10, func(read int64) {
+			reports = append(reports, read)
+		})
+
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		want := []int64{10, 20, 30}
+		if !reflect.DeepEqual(reports, want) {
+			t.Errorf("got: %v. want: %v", reports, want)
+		}
+	})
+
+	t.Run("reports once for reads crossing several intervals", func(t *testing.T) {
+		reports := make([]int64, 0)
+		r := newProgressReader(strings.NewReader(strings.Repeat("a", 35)), 10, func(read int64) {
+			reports = append(reports, read)
+		})
+
+		buf := make([]byte, 25)
+		for _, size := range []int{25, 10} {
+			if _, err := io.ReadFull(r, buf[:size]); err != nil {
+				t.Fatalf("expected no error. got: %v", err)
+			}
+		}
+
+		want := []int64{25, 35}
+		if !reflect.DeepEqual(reports, want) {
+			t.Errorf("got: %v. want: %v", reports, want)
+		}
+	})
+
+	t.Run("does not change the counts", func(t *testing.T) {
+		input := "hello world\nfoo bar baz\n"
+		r := newProgressReader(strings.NewReader(input), 4, func(int64) {})
+
+		got, err := DoWcReader(r, WcConfigs{})
+		if err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		want := WcResult{byteCount: 24, lineCount: 2, wordCount: 5, charCount: 24, maxLineLength: 11}
+		if got != want {
+			t.Errorf("got: %+v. want: %+v", got, want)
+		}
+	})
+
+	t.Run("progress flag", func(t *testing.T) {
+		configs := WcConfigs{}
+		if _, err := configs.parseFlagsAndFileName("wc", []string{"--progress", "test.txt"}); err != nil {
+			t.Fatalf("expected no error. got: %v", err)
+		}
+
+		if !configs.progress {
+			t.Error("expected progress to be set")
+		}
+	})
+}
+
+func TestEndOfOptionsMarker(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.WriteFile("-weird.txt", []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := WcConfigs{}
+	got, err := configs.parseFlagsAndFileName("some-name", []string{"-l", "--", "-weird.txt", "-c"})
+	if err != nil {
+		t.Fatalf("Expected to parse flags without errors. err: %v", err)
+	}
+
+	want := []string{"-weird.txt", "-c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if !configs.shouldCountLines || configs.shouldCountBytes {
+		t.Errorf("Expected only the flags before the marker to be set")
+	}
+
+	result, err := countFile(got[0], configs)
+	if err != nil {
+		t.Fatalf("Expected to count the file without errors. err: %v", err)
+	}
+	if result.lineCount != 3 {
+		t.Errorf("got %d lines want 3", result.lineCount)
+	}
+}
+
+func TestNoFilename(t *testing.T) {
+	t.Run("flags should be parsed", func(t *testing.T) {
+		for _, flag := range []string{"-q", "--no-filename"} {
+			configs := WcConfigs{}
+			if _, err := configs.parseFlagsAndFileName("some-name", []string{flag}); err != nil {
+				t.Fatalf("Expected to parse flags without errors. err: %v", err)
+			}
+			if !configs.noFilename {
+				t.Errorf("Expected %s to suppress the file names", flag)
+			}
+		}
+	})
+
+	t.Run("single file report should have no file name", func(t *testing.T) {
+		configs := WcConfigs{noFilename: true, numberOfFlagsSet: 1, shouldCountLines: true}
+		results := WcResult{name: "test.txt", byteCount: 342190, lineCount: 7145}
+
+		want := "7145"
+		if got := getResultsReport(configs, results); got != want {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+
+	t.Run("stdin report should have no file name", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			w.WriteString("one two\nthree\n")
+			w.Close()
+		}()
+		defer r.Close()
+
+		configs := WcConfigs{noFilename: true}
+		configs.flipAllFlagsIfNoneSet()
+		results, err := DoWc(r, configs)
+		if err != nil {
+			t.Fatalf("Expected to count without errors. err: %v", err)
+		}
+
+		want := "14 2 3"
+		if got := getResultsReport(configs, results); got != want {
+			t.Errorf("got '%s' want '%s'", got, want)
+		}
+	})
+
+	t.Run("totals line should have no label", func(t *testing.T) {
+		configs := WcConfigs{noFilename: true, numberOfFlagsSet: 1, shouldCountLines: true}
+		results := []WcResult{{name: "a.txt", lineCount: 2}, {name: "b.txt", lineCount: 3}}
+
+		got := make([]string, 0)
+		for _, r := range getReportResults(configs, results) {
+			got = append(got, getResultsReport(configs, r))
+		}
+
+		want := []string{"2", "3", "5"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
@@ -10,30 +10,77 @@ func main() {
 	args := os.Args[1:]
 
 	configs := WcConfigs{in: nil, shouldCountBytes: false, shouldCountLines: false}
-	filename, err := configs.parseFlagsAndFileName(programName, args)
+	filenames, err := configs.parseFlagsAndFileName(programName, args)
 	if err != nil {
 		fmt.Println("Failed to parse program flags. err: ", err)
 		os.Exit(1)
 	}
 
-	if filename != "" {
-		file, err := openFile(filename)
+	// an empty --files0-from list means there is nothing to count, not that
+	// stdin should be counted
+	if len(filenames) == 0 && configs.files0From == "" {
+		configs.in = os.Stdin
+		results, err := DoWc(configs.in, configs)
 		if err != nil {
-			fmt.Println("Expected to open file without errors. err:", err)
+			fmt.Println("Failed to perform word count. err:", err)
 			os.Exit(1)
 		}
-		defer file.Close()
 
-		configs.in = file
-	} else {
-		configs.in = os.Stdin
+		printReport(configs, []WcResult{results})
+		return
+	}
+
+	exitCode := 0
+	allResults := make([]WcResult, 0)
+	for _, filename := range walkPaths(filenames, configs.recursive) {
+		if filename == "" {
+			fmt.Fprintf(os.Stderr, "%s: invalid zero-length file name\n", programName)
+			exitCode = 1
+			continue
+		}
+
+		results, err := countFile(filename, configs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", programName, filename, err)
+			exitCode = 1
+			continue
+		}
+
+		allResults = append(allResults, results)
 	}
 
-	results, err := DoWc(configs.in)
+	printReport(configs, allResults)
+	os.Exit(exitCode)
+}
+
+func countFile(filename string, configs WcConfigs) (WcResult, error) {
+	file, err := openFile(filename)
 	if err != nil {
-		fmt.Println("Failed to perform word count. err:", err)
-		os.Exit(1)
+		return defaultWcResult, err
 	}
+	defer file.Close()
 
-	fmt.Println(getResultsReport(configs, results))
+	return DoWc(file, configs)
+}
+
+func printReport(configs WcConfigs, allResults []WcResult) {
+	if len(allResults) == 0 {
+		return
+	}
+
+	reportResults := getReportResults(configs, allResults)
+	if configs.json {
+		report, err := getResultsReportJSON(configs, reportResults)
+		if err != nil {
+			fmt.Println("Failed to build json report. err:", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(report)
+		return
+	}
+
+	for _, results := range reportResults {
+		fmt.Println(getResultsReport(configs, results))
+	}
 }